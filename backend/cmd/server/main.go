@@ -5,12 +5,14 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	_ "time/tzdata" // 内置时区数据，运行环境没有 zoneinfo 时也能解析用户时区
 
 	"github.com/joho/godotenv"
 	"newapi-subscribe/internal/config"
 	"newapi-subscribe/internal/cron"
 	"newapi-subscribe/internal/model"
 	"newapi-subscribe/internal/router"
+	"newapi-subscribe/internal/service"
 )
 
 func main() {
@@ -27,6 +29,9 @@ func main() {
 		log.Fatalf("初始化数据库失败: %v", err)
 	}
 
+	// 注册支付网关
+	service.InitPaymentProviders()
+
 	// 启动定时任务
 	cron.Start()
 
@@ -9,15 +9,17 @@ type Config struct {
 	// 服务配置
 	Port      string
 	JWTSecret string
+	SiteURL   string // 本系统对外地址，用于支付回调
 
 	// 数据库
 	DBPath string
 
 	// new-api 配置
-	NewAPIURL       string
-	NewAPIAdminUser string
-	NewAPIAdminPass string
-	NewAPIAdminID   string
+	NewAPIURL         string
+	NewAPIAdminUser   string
+	NewAPIAdminPass   string
+	NewAPIAdminID     string
+	NewAPIAccessToken string // 访问令牌，设置后代替账号密码进行管理员认证（需同时设置 NewAPIAdminID）
 
 	// 易支付配置
 	EpayURL string
@@ -34,6 +36,12 @@ type Config struct {
 	// 定时任务
 	CronEnabled  bool
 	CronSchedule string
+
+	// 额度同步
+	SyncConcurrency int // 并发同步的用户数
+	SyncRateLimit   int // 每秒最多请求 new-api 的次数 (0=不限制)
+	SyncRetries     int // new-api 请求失败时的重试次数
+	SyncTimeout     int // 单次同步最长执行时间（分钟）
 }
 
 var Cfg *Config
@@ -42,12 +50,14 @@ func Load() {
 	Cfg = &Config{
 		Port:      getEnv("PORT", "8080"),
 		JWTSecret: getEnv("JWT_SECRET", "change-me-in-production"),
+		SiteURL:   getEnv("SITE_URL", ""),
 		DBPath:    getEnv("DB_PATH", "./data/subscribe.db"),
 
-		NewAPIURL:       getEnv("NEWAPI_URL", ""),
-		NewAPIAdminUser: getEnv("NEWAPI_ADMIN_USER", ""),
-		NewAPIAdminPass: getEnv("NEWAPI_ADMIN_PASS", ""),
-		NewAPIAdminID:   getEnv("NEWAPI_ADMIN_ID", ""),
+		NewAPIURL:         getEnv("NEWAPI_URL", ""),
+		NewAPIAdminUser:   getEnv("NEWAPI_ADMIN_USER", ""),
+		NewAPIAdminPass:   getEnv("NEWAPI_ADMIN_PASS", ""),
+		NewAPIAdminID:     getEnv("NEWAPI_ADMIN_ID", ""),
+		NewAPIAccessToken: getEnv("NEWAPI_ACCESS_TOKEN", ""),
 
 		EpayURL: getEnv("EPAY_URL", ""),
 		EpayPID: getEnv("EPAY_PID", ""),
@@ -61,6 +71,11 @@ func Load() {
 
 		CronEnabled:  getEnvBool("CRON_ENABLED", true),
 		CronSchedule: getEnv("CRON_SCHEDULE", "0 0 * * *"),
+
+		SyncConcurrency: getEnvInt("SYNC_CONCURRENCY", 4),
+		SyncRateLimit:   getEnvInt("SYNC_RATE_LIMIT", 10),
+		SyncRetries:     getEnvInt("SYNC_RETRIES", 3),
+		SyncTimeout:     getEnvInt("SYNC_TIMEOUT", 30),
 	}
 }
 
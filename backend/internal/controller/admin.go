@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -34,26 +35,26 @@ func AdminGetUsers(c *gin.Context) {
 	// 获取每个用户的订阅状态和今日用量
 	type UserWithSubscription struct {
 		model.User
-		Subscription  *model.Subscription `json:"subscription"`
-		TodayUsed     int                 `json:"today_used"`
-		CurrentQuota  int                 `json:"current_quota"`
+		Subscription  *model.Subscription  `json:"subscription"`
+		Subscriptions []model.Subscription `json:"subscriptions"`
+		TodayUsed     int                  `json:"today_used"`
+		CurrentQuota  int                  `json:"current_quota"`
 	}
 
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 
 	result := make([]UserWithSubscription, len(users))
 	for i, u := range users {
 		result[i].User = u
-		var sub model.Subscription
-		if err := model.DB.Preload("Plan").
-			Where("user_id = ? AND status = ?", u.ID, model.SubscriptionStatusActive).
-			First(&sub).Error; err == nil {
-			result[i].Subscription = &sub
-		}
+		model.DB.Preload("Plan").
+			Where("user_id = ? AND status IN ?", u.ID, model.SubscriptionCurrentStatuses).
+			Order("id ASC").
+			Find(&result[i].Subscriptions)
+		result[i].Subscription = service.PrimarySubscription(result[i].Subscriptions)
 
 		// 获取绑定了 new-api 的用户的今日用量
 		if u.NewAPIBound == 1 {
-			if todayUsed, err := client.GetUserQuotaUsedToday(u.NewAPIUserID); err == nil {
+			if todayUsed, err := client.GetUserQuotaUsedToday(u.NewAPIUserID, u.Location()); err == nil {
 				result[i].TodayUsed = todayUsed
 			}
 			if newAPIUser, err := client.GetUser(u.NewAPIUserID); err == nil {
@@ -92,15 +93,17 @@ func AdminGetUser(c *gin.Context) {
 	}
 
 	// 获取订阅
-	var subscription model.Subscription
+	var subscriptions []model.Subscription
 	model.DB.Preload("Plan").
-		Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-		First(&subscription)
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionCurrentStatuses).
+		Order("id ASC").
+		Find(&subscriptions)
+	subscription := service.PrimarySubscription(subscriptions)
 
 	// 获取 new-api 余额
 	var currentQuota int
 	if user.NewAPIBound == 1 {
-		client := service.NewNewAPIClient()
+		client := service.NewAPI()
 		if newAPIUser, err := client.GetUser(user.NewAPIUserID); err == nil {
 			currentQuota = newAPIUser.Quota
 		}
@@ -111,6 +114,7 @@ func AdminGetUser(c *gin.Context) {
 		Data: gin.H{
 			"user":          user,
 			"subscription":  subscription,
+			"subscriptions": subscriptions,
 			"current_quota": currentQuota,
 		},
 	})
@@ -147,7 +151,7 @@ func AdminGetUserUsage(c *gin.Context) {
 	startDate := c.DefaultQuery("start_date", "")
 	endDate := c.DefaultQuery("end_date", "")
 
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 	logs, err := client.GetUserLogs(user.NewAPIUserID, startDate, endDate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
@@ -184,11 +188,12 @@ func AdminUpdateUser(c *gin.Context) {
 	}
 
 	var req struct {
-		Email       string `json:"email"`
-		Status      int    `json:"status"`
-		Role        int    `json:"role"`
-		EmailRemind int    `json:"email_remind"`
-		RemindDays  int    `json:"remind_days"`
+		Email       string  `json:"email"`
+		Status      int     `json:"status"`
+		Role        int     `json:"role"`
+		EmailRemind int     `json:"email_remind"`
+		RemindDays  int     `json:"remind_days"`
+		Timezone    *string `json:"timezone"` // 未传时不修改，空字符串表示使用站点默认时区
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -212,14 +217,38 @@ func AdminUpdateUser(c *gin.Context) {
 	if req.RemindDays > 0 {
 		user.RemindDays = req.RemindDays
 	}
+	if req.Timezone != nil {
+		if _, err := model.LoadTimezone(*req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, dto.Response{
+				Success: false,
+				Message: "无效的时区",
+			})
+			return
+		}
+	}
 
-	if err := model.DB.Save(&user).Error; err != nil {
+	if err := model.DB.Model(&user).Updates(map[string]interface{}{
+		"email":        user.Email,
+		"status":       user.Status,
+		"role":         user.Role,
+		"email_remind": user.EmailRemind,
+		"remind_days":  user.RemindDays,
+	}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "更新失败",
 		})
 		return
 	}
+	if req.Timezone != nil {
+		if err := service.SetUserTimezone(&user, *req.Timezone); err != nil {
+			c.JSON(http.StatusInternalServerError, dto.Response{
+				Success: false,
+				Message: "更新时区失败",
+			})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, dto.Response{
 		Success: true,
@@ -270,6 +299,7 @@ func AdminGetOrders(c *gin.Context) {
 	}
 
 	status := c.Query("status")
+	needsReview := c.Query("needs_review")
 
 	var orders []model.Order
 	var total int64
@@ -278,6 +308,9 @@ func AdminGetOrders(c *gin.Context) {
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if needsReview == "1" {
+		query = query.Where("needs_review = ?", 1)
+	}
 
 	query.Count(&total)
 	query.Preload("User").Preload("Plan").
@@ -306,19 +339,42 @@ func AdminCreatePlan(c *gin.Context) {
 		return
 	}
 
+	if err := validatePlanPrice(req.IsTrial, req.Price); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if req.QuotaWindow == "" {
+		req.QuotaWindow = model.QuotaWindowDay
+	}
+
 	plan := &model.Plan{
-		Name:         req.Name,
-		Description:  req.Description,
-		PeriodType:   req.PeriodType,
-		PeriodDays:   req.PeriodDays,
-		DailyQuota:   req.DailyQuota,
-		CarryOver:    req.CarryOver,
-		MaxCarryOver: req.MaxCarryOver,
-		PriceType:    req.PriceType,
-		Price:        req.Price,
-		NewAPIGroup:  req.NewAPIGroup,
-		Status:       req.Status,
-		SortOrder:    req.SortOrder,
+		Name:          req.Name,
+		Description:   req.Description,
+		PeriodType:    req.PeriodType,
+		PeriodDays:    req.PeriodDays,
+		QuotaWindow:   req.QuotaWindow,
+		DailyQuota:    req.DailyQuota,
+		CarryOver:     req.CarryOver,
+		MaxCarryOver:  req.MaxCarryOver,
+		PriceType:     req.PriceType,
+		Price:         req.Price,
+		NewAPIGroup:   req.NewAPIGroup,
+		GroupPriority: req.GroupPriority,
+		GraceDays:     req.GraceDays,
+		MaxPauseDays:  req.MaxPauseDays,
+		IsTrial:       req.IsTrial,
+		Status:        req.Status,
+		SortOrder:     req.SortOrder,
+	}
+
+	// 试用套餐固定价格为 0
+	if plan.IsTrial == 1 {
+		plan.PriceType = model.PriceTypeFixed
+		plan.Price = 0
 	}
 
 	if err := model.DB.Create(plan).Error; err != nil {
@@ -335,6 +391,14 @@ func AdminCreatePlan(c *gin.Context) {
 	})
 }
 
+// validatePlanPrice 正式套餐价格必须大于 0，试用套餐价格固定为 0 不校验
+func validatePlanPrice(isTrial int, price float64) error {
+	if isTrial != 1 && price <= 0 {
+		return errors.New("正式套餐价格必须大于 0")
+	}
+	return nil
+}
+
 // AdminUpdatePlan 更新套餐
 func AdminUpdatePlan(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -376,6 +440,9 @@ func AdminUpdatePlan(c *gin.Context) {
 	if req.PeriodDays > 0 {
 		plan.PeriodDays = req.PeriodDays
 	}
+	if req.QuotaWindow != "" {
+		plan.QuotaWindow = req.QuotaWindow
+	}
 	if req.DailyQuota > 0 {
 		plan.DailyQuota = req.DailyQuota
 	}
@@ -390,9 +457,28 @@ func AdminUpdatePlan(c *gin.Context) {
 	if req.NewAPIGroup != "" {
 		plan.NewAPIGroup = req.NewAPIGroup
 	}
+	plan.GroupPriority = req.GroupPriority
+	plan.GraceDays = req.GraceDays
+	plan.MaxPauseDays = req.MaxPauseDays
+	plan.IsTrial = req.IsTrial
 	plan.Status = req.Status
 	plan.SortOrder = req.SortOrder
 
+	// 由试用套餐改为正式套餐时需同时设置价格
+	if err := validatePlanPrice(plan.IsTrial, plan.Price); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 试用套餐固定价格为 0
+	if plan.IsTrial == 1 {
+		plan.PriceType = model.PriceTypeFixed
+		plan.Price = 0
+	}
+
 	if err := model.DB.Save(&plan).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
@@ -421,7 +507,7 @@ func AdminDeletePlan(c *gin.Context) {
 	// 检查是否有活跃订阅使用该套餐
 	var count int64
 	model.DB.Model(&model.Subscription{}).
-		Where("plan_id = ? AND status = ?", id, model.SubscriptionStatusActive).
+		Where("plan_id = ? AND status IN ?", id, model.SubscriptionCurrentStatuses).
 		Count(&count)
 
 	if count > 0 {
@@ -473,6 +559,16 @@ func AdminUpdateSettings(c *gin.Context) {
 		return
 	}
 
+	if tz, ok := req[model.SettingDefaultTimezone]; ok {
+		if _, err := model.LoadTimezone(tz); err != nil {
+			c.JSON(http.StatusBadRequest, dto.Response{
+				Success: false,
+				Message: "无效的时区",
+			})
+			return
+		}
+	}
+
 	for key, value := range req {
 		model.SetSetting(key, value)
 	}
@@ -483,19 +579,35 @@ func AdminUpdateSettings(c *gin.Context) {
 	})
 }
 
-// AdminTriggerSync 手动触发同步
+// AdminTriggerSync 手动触发同步，返回同步记录供查询进度；已有同步在执行时返回其进度
 func AdminTriggerSync(c *gin.Context) {
-	go service.SyncAllSubscriptions()
+	run, err := service.TriggerSync()
+	if errors.Is(err, service.ErrSyncRunning) {
+		c.JSON(http.StatusConflict, dto.Response{
+			Success: false,
+			Message: "已有同步正在进行中",
+			Data:    service.RunningSync(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "启动同步失败",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, dto.Response{
 		Success: true,
 		Message: "同步任务已启动",
+		Data:    run,
 	})
 }
 
 // AdminGetNewAPIGroups 获取 new-api 分组
 func AdminGetNewAPIGroups(c *gin.Context) {
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 	groups, err := client.GetGroups()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
@@ -531,16 +643,22 @@ func AdminCompleteOrder(c *gin.Context) {
 		return
 	}
 
-	if order.Status != model.OrderStatusPending {
+	// 待审核的已取消订单（过期后支付）也允许补单
+	reviewable := order.Status == model.OrderStatusCancelled && order.NeedsReview == 1
+	if order.Status != model.OrderStatusPending && !reviewable {
 		c.JSON(http.StatusBadRequest, dto.Response{
 			Success: false,
-			Message: "只能补单待支付状态的订单",
+			Message: "只能补单待支付或待审核状态的订单",
 		})
 		return
 	}
 
-	// 手动补单，交易号设为 MANUAL
-	if err := service.CompleteOrder(&order, "MANUAL_"+order.OrderNo); err != nil {
+	// 手动补单，交易号设为 MANUAL；已收到支付回调的保留网关交易号
+	tradeNo := "MANUAL_" + order.OrderNo
+	if reviewable && order.TradeNo != "" {
+		tradeNo = order.TradeNo
+	}
+	if err := service.CompleteOrder(&order, tradeNo); err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "补单失败: " + err.Error(),
@@ -554,6 +672,123 @@ func AdminCompleteOrder(c *gin.Context) {
 	})
 }
 
+// AdminRefundOrder 订单退款
+func AdminRefundOrder(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的订单 ID",
+		})
+		return
+	}
+
+	var req dto.RefundOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	var order model.Order
+	if err := model.DB.First(&order, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "订单不存在",
+		})
+		return
+	}
+
+	if !service.IsOrderRefundable(&order) {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "只能对已支付或待审核的订单退款",
+		})
+		return
+	}
+
+	result, err := service.RefundOrder(&order, req.Amount, req.Reason, req.Offline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "退款失败: " + err.Error(),
+			Data:    result,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "退款成功",
+		Data:    result,
+	})
+}
+
+// AdminGetOrderTasks 获取订单 new-api 操作记录
+func AdminGetOrderTasks(c *gin.Context) {
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	status := c.Query("status")
+	orderID := c.Query("order_id")
+
+	var tasks []model.OrderTask
+	var total int64
+
+	query := model.DB.Model(&model.OrderTask{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+
+	query.Count(&total)
+	query.Preload("Order").
+		Order("id DESC").
+		Offset(pagination.Offset()).
+		Limit(pagination.PerPage).
+		Find(&tasks)
+
+	c.JSON(http.StatusOK, dto.PaginatedResponse{
+		Success: true,
+		Data:    tasks,
+		Total:   total,
+		Page:    pagination.Page,
+		PerPage: pagination.PerPage,
+	})
+}
+
+// AdminRetryOrderTasks 重试订单未完成的 new-api 操作
+func AdminRetryOrderTasks(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的订单 ID",
+		})
+		return
+	}
+
+	if err := service.ResetOrderTasks(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "重试失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "重试成功",
+	})
+}
+
 // AdminGetUserTodayUsage 获取用户今日用量
 func AdminGetUserTodayUsage(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -587,8 +822,8 @@ func AdminGetUserTodayUsage(c *gin.Context) {
 		return
 	}
 
-	client := service.NewNewAPIClient()
-	todayUsed, err := client.GetUserQuotaUsedToday(user.NewAPIUserID)
+	client := service.NewAPI()
+	todayUsed, err := client.GetUserQuotaUsedToday(user.NewAPIUserID, user.Location())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
@@ -597,13 +832,12 @@ func AdminGetUserTodayUsage(c *gin.Context) {
 		return
 	}
 
-	// 获取当前订阅信息
-	var subscription model.Subscription
+	// 获取当前订阅的每日额度之和
 	var dailyQuota int
-	if err := model.DB.Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-		First(&subscription).Error; err == nil {
-		dailyQuota = subscription.DailyQuota
-	}
+	model.DB.Model(&model.Subscription{}).
+		Select("COALESCE(SUM(daily_quota), 0)").
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionSyncStatuses).
+		Scan(&dailyQuota)
 
 	// 获取当前余额
 	var currentQuota int
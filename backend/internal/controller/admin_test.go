@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"newapi-subscribe/internal/dto"
+)
+
+func TestAdminCreatePlanRequiresPriceForPaidPlans(t *testing.T) {
+	setupTest(t)
+	req := dto.CreatePlanRequest{
+		Name:        "基础版",
+		PeriodType:  "month",
+		PeriodDays:  30,
+		DailyQuota:  1000,
+		PriceType:   "fixed",
+		NewAPIGroup: "default",
+		Status:      1,
+	}
+
+	// 正式套餐未填价格时拒绝创建，与更新套餐的校验一致
+	if code, resp := postJSON(t, AdminCreatePlan, nil, req); code != http.StatusBadRequest || resp.Success {
+		t.Fatalf("未填价格的正式套餐应创建失败: %d %+v", code, resp)
+	} else if err := validatePlanPrice(req.IsTrial, req.Price); err == nil || resp.Message != err.Error() {
+		t.Errorf("创建失败提示 = %q, 期望与 validatePlanPrice 一致", resp.Message)
+	}
+
+	// 试用套餐价格为 0
+	req.IsTrial = 1
+	if code, resp := postJSON(t, AdminCreatePlan, nil, req); code != http.StatusOK || !resp.Success {
+		t.Fatalf("创建试用套餐失败: %d %+v", code, resp)
+	}
+
+	req.IsTrial = 0
+	req.Price = 30
+	if code, resp := postJSON(t, AdminCreatePlan, nil, req); code != http.StatusOK || !resp.Success {
+		t.Fatalf("创建正式套餐失败: %d %+v", code, resp)
+	}
+}
@@ -160,7 +160,7 @@ func NewAPILogin(c *gin.Context) {
 	}
 
 	// 验证 new-api 账号
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 	newAPIUser, err := client.Login(req.Username, req.Password)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, dto.Response{
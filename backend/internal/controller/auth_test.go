@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/logger"
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/model"
+	"newapi-subscribe/internal/service"
+)
+
+// setupTest 使用临时数据库和内存 new-api 初始化，测试结束时恢复原依赖
+func setupTest(t *testing.T) *service.FakeNewAPI {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	config.Cfg = &config.Config{JWTSecret: "test-secret"}
+	if err := model.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	model.DB.Logger = logger.Discard
+
+	fake := service.NewFakeNewAPI("default")
+	old := service.SetContainer(fake.Container())
+	t.Cleanup(func() {
+		service.SetContainer(old)
+		if sqlDB, err := model.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return fake
+}
+
+// postJSON 以 user 身份（为 nil 时未登录）调用 handler，返回状态码和响应
+func postJSON(t *testing.T, handler gin.HandlerFunc, user *model.User, body interface{}) (int, dto.Response) {
+	t.Helper()
+
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if user != nil {
+		c.Set("user", user)
+		c.Set("userID", user.ID)
+	}
+	handler(c)
+
+	var resp dto.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("解析响应失败: %v, body: %s", err, w.Body.String())
+	}
+	return w.Code, resp
+}
+
+func TestNewAPILoginCreatesLocalUser(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("alice", "password", "default", 0)
+
+	code, resp := postJSON(t, NewAPILogin, nil, dto.NewAPILoginRequest{Username: "alice", Password: "password"})
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("登录失败: %d %+v", code, resp)
+	}
+	if token, _ := resp.Data.(map[string]interface{})["token"].(string); token == "" {
+		t.Error("响应中没有 token")
+	}
+
+	var user model.User
+	if err := model.DB.Where("newapi_user_id = ?", newAPIUser.ID).First(&user).Error; err != nil {
+		t.Fatalf("未创建本地用户: %v", err)
+	}
+	if user.NewAPIBound != 1 || user.NewAPIUsername != "alice" {
+		t.Errorf("本地用户 = %+v", user)
+	}
+
+	// 再次登录复用同一本地用户
+	if code, _ := postJSON(t, NewAPILogin, nil, dto.NewAPILoginRequest{Username: "alice", Password: "password"}); code != http.StatusOK {
+		t.Fatalf("再次登录失败: %d", code)
+	}
+	var count int64
+	model.DB.Model(&model.User{}).Where("newapi_user_id = ?", newAPIUser.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("本地用户数 = %d，期望 1", count)
+	}
+}
+
+func TestNewAPILoginRejectsWrongPassword(t *testing.T) {
+	fake := setupTest(t)
+	fake.AddUser("alice", "password", "default", 0)
+
+	code, resp := postJSON(t, NewAPILogin, nil, dto.NewAPILoginRequest{Username: "alice", Password: "wrong"})
+	if code != http.StatusUnauthorized || resp.Success {
+		t.Fatalf("密码错误时应拒绝登录: %d %+v", code, resp)
+	}
+}
+
+func TestNewAPILoginDisabled(t *testing.T) {
+	fake := setupTest(t)
+	fake.AddUser("alice", "password", "default", 0)
+	model.SetSetting(model.SettingNewAPILoginEnabled, "0")
+
+	code, _ := postJSON(t, NewAPILogin, nil, dto.NewAPILoginRequest{Username: "alice", Password: "password"})
+	if code != http.StatusForbidden {
+		t.Fatalf("关闭 new-api 登录时状态码 = %d，期望 403", code)
+	}
+	if calls := fake.Calls("Login"); calls != 0 {
+		t.Errorf("关闭时不应请求 new-api，Login 调用 %d 次", calls)
+	}
+}
+
+func TestBindNewAPI(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("bob", "password", "default", 0)
+
+	user := &model.User{Username: "bob-local", Role: model.RoleUser, Status: model.StatusEnabled}
+	model.DB.Create(user)
+
+	code, resp := postJSON(t, BindNewAPI, user, dto.BindNewAPIRequest{Username: "bob", Password: "password"})
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("绑定失败: %d %+v", code, resp)
+	}
+	model.DB.First(user, user.ID)
+	if user.NewAPIBound != 1 || user.NewAPIUserID != newAPIUser.ID {
+		t.Errorf("绑定后用户 = %+v", user)
+	}
+
+	// 同一 new-api 账号不能再被其他用户绑定
+	other := &model.User{Username: "mallory", Role: model.RoleUser, Status: model.StatusEnabled}
+	model.DB.Create(other)
+	code, _ = postJSON(t, BindNewAPI, other, dto.BindNewAPIRequest{Username: "bob", Password: "password"})
+	if code != http.StatusBadRequest {
+		t.Errorf("重复绑定状态码 = %d，期望 400", code)
+	}
+}
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/middleware"
+	"newapi-subscribe/internal/model"
+	"newapi-subscribe/internal/service"
+)
+
+// GetBoosters 获取上架的加油包列表
+func GetBoosters(c *gin.Context) {
+	var boosters []model.Booster
+	if err := model.DB.Where("status = ?", model.BoosterStatusOn).
+		Order("sort_order ASC, id ASC").
+		Find(&boosters).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "获取加油包列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    boosters,
+	})
+}
+
+// PurchaseBooster 创建加油包订单，之后通过 /orders/pay 发起支付
+func PurchaseBooster(c *gin.Context) {
+	var req dto.PurchaseBoosterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	if user.NewAPIBound != 1 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "请先绑定 new-api 账号",
+		})
+		return
+	}
+
+	var booster model.Booster
+	if err := model.DB.Where("id = ? AND status = ?", req.BoosterID, model.BoosterStatusOn).
+		First(&booster).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "加油包不存在或已下架",
+		})
+		return
+	}
+
+	// 加油包需要有生效中的订阅，未指定时记到主订阅上
+	var subscriptions []model.Subscription
+	query := model.DB.Preload("Plan").
+		Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive)
+	if req.SubscriptionID > 0 {
+		query = query.Where("id = ?", req.SubscriptionID)
+	}
+	query.Find(&subscriptions)
+	subscription := service.PrimarySubscription(subscriptions)
+	if subscription == nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "没有生效中的订阅，无法购买加油包",
+		})
+		return
+	}
+
+	order := &model.Order{
+		OrderNo:           generateOrderNo(user.ID),
+		UserID:            user.ID,
+		PlanID:            subscription.PlanID,
+		OrderType:         model.OrderTypeBooster,
+		SubscriptionID:    subscription.ID,
+		BoosterID:         booster.ID,
+		BoosterQuota:      booster.Quota,
+		BoosterCarryQuota: booster.CarryQuota(),
+		Amount:            booster.Price,
+		Status:            model.OrderStatusPending,
+		ExpiresAt:         model.NewOrderExpiresAt(),
+	}
+
+	if err := model.DB.Create(order).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "创建订单失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    order,
+	})
+}
+
+// AdminGetBoosters 获取全部加油包
+func AdminGetBoosters(c *gin.Context) {
+	var boosters []model.Booster
+	model.DB.Order("sort_order ASC, id ASC").Find(&boosters)
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    boosters,
+	})
+}
+
+// AdminCreateBooster 创建加油包
+func AdminCreateBooster(c *gin.Context) {
+	var req dto.CreateBoosterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	booster := &model.Booster{
+		Name:         req.Name,
+		Description:  req.Description,
+		Quota:        req.Quota,
+		CarryOver:    req.CarryOver,
+		MaxCarryOver: req.MaxCarryOver,
+		Price:        req.Price,
+		Status:       req.Status,
+		SortOrder:    req.SortOrder,
+	}
+
+	if err := model.DB.Create(booster).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "创建失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    booster,
+	})
+}
+
+// AdminUpdateBooster 更新加油包，已下单的订单仍按下单时的额度发放
+func AdminUpdateBooster(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的加油包 ID",
+		})
+		return
+	}
+
+	var booster model.Booster
+	if err := model.DB.First(&booster, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "加油包不存在",
+		})
+		return
+	}
+
+	var req dto.UpdateBoosterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	if req.Name != "" {
+		booster.Name = req.Name
+	}
+	if req.Description != "" {
+		booster.Description = req.Description
+	}
+	if req.Quota > 0 {
+		booster.Quota = req.Quota
+	}
+	if req.Price > 0 {
+		booster.Price = req.Price
+	}
+	booster.CarryOver = req.CarryOver
+	booster.MaxCarryOver = req.MaxCarryOver
+	booster.Status = req.Status
+	booster.SortOrder = req.SortOrder
+
+	if err := model.DB.Save(&booster).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "更新失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    booster,
+	})
+}
+
+// AdminDeleteBooster 删除加油包
+func AdminDeleteBooster(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的加油包 ID",
+		})
+		return
+	}
+
+	if err := model.DB.Delete(&model.Booster{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "删除失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "删除成功",
+	})
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/middleware"
+	"newapi-subscribe/internal/model"
+	"newapi-subscribe/internal/service"
+)
+
+// ValidateCoupon 校验优惠码并返回优惠金额
+func ValidateCoupon(c *gin.Context) {
+	var req dto.ValidateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	var plan model.Plan
+	if err := model.DB.First(&plan, req.PlanID).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "套餐不存在",
+		})
+		return
+	}
+
+	periodDays := plan.PeriodDays
+	if req.PeriodDays > 0 {
+		periodDays = req.PeriodDays
+	}
+	amount := plan.CalculatePrice(periodDays)
+
+	coupon, discount, err := service.ValidateCoupon(req.Code, user.ID, &plan, periodDays, amount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data: gin.H{
+			"code":            coupon.Code,
+			"original_amount": amount,
+			"discount_amount": discount,
+			"amount":          amount - discount,
+		},
+	})
+}
+
+// AdminGetCoupons 获取优惠码列表
+func AdminGetCoupons(c *gin.Context) {
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	keyword := c.Query("keyword")
+
+	var coupons []model.Coupon
+	var total int64
+
+	query := model.DB.Model(&model.Coupon{})
+	if keyword != "" {
+		query = query.Where("code LIKE ? OR name LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+	}
+
+	query.Count(&total)
+	query.Order("id DESC").Offset(pagination.Offset()).Limit(pagination.PerPage).Find(&coupons)
+
+	type CouponWithUsage struct {
+		model.Coupon
+		Usage service.CouponUsageStats `json:"usage"`
+	}
+
+	result := make([]CouponWithUsage, len(coupons))
+	for i := range coupons {
+		result[i].Coupon = coupons[i]
+		result[i].Usage = service.GetCouponUsageStats(&coupons[i])
+	}
+
+	c.JSON(http.StatusOK, dto.PaginatedResponse{
+		Success: true,
+		Data:    result,
+		Total:   total,
+		Page:    pagination.Page,
+		PerPage: pagination.PerPage,
+	})
+}
+
+// AdminCreateCoupon 创建优惠码
+func AdminCreateCoupon(c *gin.Context) {
+	var req dto.CreateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	if req.DiscountType == model.CouponTypePercent && req.DiscountValue > 100 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "折扣百分比不能超过 100",
+		})
+		return
+	}
+
+	coupon := &model.Coupon{
+		Code:          strings.TrimSpace(req.Code),
+		Name:          req.Name,
+		Description:   req.Description,
+		DiscountType:  req.DiscountType,
+		DiscountValue: req.DiscountValue,
+		PlanIDs:       model.JoinPlanIDs(req.PlanIDs),
+		MinPeriodDays: req.MinPeriodDays,
+		MaxPeriodDays: req.MaxPeriodDays,
+		TotalLimit:    req.TotalLimit,
+		PerUserLimit:  req.PerUserLimit,
+		StartAt:       req.StartAt,
+		EndAt:         req.EndAt,
+		Status:        req.Status,
+	}
+
+	if err := model.DB.Create(coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "创建失败，优惠码可能已存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    coupon,
+	})
+}
+
+// AdminUpdateCoupon 更新优惠码
+func AdminUpdateCoupon(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的优惠码 ID",
+		})
+		return
+	}
+
+	var coupon model.Coupon
+	if err := model.DB.First(&coupon, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "优惠码不存在",
+		})
+		return
+	}
+
+	var req dto.UpdateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	if req.Name != "" {
+		coupon.Name = req.Name
+	}
+	if req.Description != "" {
+		coupon.Description = req.Description
+	}
+	if req.DiscountType != "" {
+		coupon.DiscountType = req.DiscountType
+	}
+	if req.DiscountValue > 0 {
+		coupon.DiscountValue = req.DiscountValue
+	}
+	if coupon.DiscountType == model.CouponTypePercent && coupon.DiscountValue > 100 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "折扣百分比不能超过 100",
+		})
+		return
+	}
+	coupon.PlanIDs = model.JoinPlanIDs(req.PlanIDs)
+	coupon.MinPeriodDays = req.MinPeriodDays
+	coupon.MaxPeriodDays = req.MaxPeriodDays
+	coupon.TotalLimit = req.TotalLimit
+	coupon.PerUserLimit = req.PerUserLimit
+	coupon.StartAt = req.StartAt
+	coupon.EndAt = req.EndAt
+	coupon.Status = req.Status
+
+	if err := model.DB.Omit("used_count").Save(&coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "更新失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    coupon,
+	})
+}
+
+// AdminDeleteCoupon 删除优惠码
+func AdminDeleteCoupon(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的优惠码 ID",
+		})
+		return
+	}
+
+	if err := model.DB.Delete(&model.Coupon{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "删除失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "删除成功",
+	})
+}
+
+// AdminGetCouponUsage 获取优惠码使用报表
+func AdminGetCouponUsage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的优惠码 ID",
+		})
+		return
+	}
+
+	var coupon model.Coupon
+	if err := model.DB.Unscoped().First(&coupon, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "优惠码不存在",
+		})
+		return
+	}
+
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	var orders []model.Order
+	var total int64
+
+	query := model.DB.Model(&model.Order{}).Where("coupon_id = ?", coupon.ID)
+	query.Count(&total)
+	query.Preload("User").Preload("Plan").
+		Order("id DESC").
+		Offset(pagination.Offset()).
+		Limit(pagination.PerPage).
+		Find(&orders)
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data: gin.H{
+			"coupon":   coupon,
+			"stats":    service.GetCouponUsageStats(&coupon),
+			"orders":   orders,
+			"total":    total,
+			"page":     pagination.Page,
+			"per_page": pagination.PerPage,
+		},
+	})
+}
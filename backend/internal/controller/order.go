@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 
@@ -70,6 +71,27 @@ func GetOrder(c *gin.Context) {
 	})
 }
 
+// GetPaymentMethods 获取可用支付方式
+func GetPaymentMethods(c *gin.Context) {
+	type providerInfo struct {
+		Provider string   `json:"provider"`
+		Methods  []string `json:"methods"`
+	}
+
+	var providers []providerInfo
+	for _, p := range service.ListPaymentProviders() {
+		providers = append(providers, providerInfo{
+			Provider: p.Name(),
+			Methods:  p.Methods(),
+		})
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    providers,
+	})
+}
+
 // CreatePayment 发起支付
 func CreatePayment(c *gin.Context) {
 	var req dto.PayRequest
@@ -100,9 +122,33 @@ func CreatePayment(c *gin.Context) {
 		return
 	}
 
-	// 调用易支付
-	epay := service.NewEpayService()
-	payURL, err := epay.CreatePayment(order.OrderNo, order.Amount, req.PaymentMethod, "订阅套餐")
+	if order.IsExpired() {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "订单已过期，请重新下单",
+		})
+		return
+	}
+
+	// 选择支付网关
+	provider, ok := service.ResolvePaymentProvider(req.Provider, req.PaymentMethod)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "不支持的支付方式",
+		})
+		return
+	}
+
+	subject := "订阅套餐"
+	switch order.OrderType {
+	case model.OrderTypeTopup:
+		subject = "钱包充值"
+	case model.OrderTypeBooster:
+		subject = "加油包"
+	}
+
+	payURL, err := provider.CreatePayment(&order, req.PaymentMethod, subject)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
@@ -111,9 +157,28 @@ func CreatePayment(c *gin.Context) {
 		return
 	}
 
-	// 更新支付方式
-	order.PaymentMethod = req.PaymentMethod
-	model.DB.Save(&order)
+	// 更新支付网关和支付方式；只改这两列，且要求订单仍待支付，
+	// 避免发起支付期间订单已被回调完成或被取消时整行写回旧状态
+	result := model.DB.Model(&model.Order{}).
+		Where("id = ? AND status = ?", order.ID, model.OrderStatusPending).
+		Updates(map[string]interface{}{
+			"payment_provider": provider.Name(),
+			"payment_method":   req.PaymentMethod,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "创建支付失败: " + result.Error.Error(),
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "订单状态异常",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, dto.Response{
 		Success: true,
@@ -125,40 +190,80 @@ func CreatePayment(c *gin.Context) {
 
 // PaymentNotify 支付回调
 func PaymentNotify(c *gin.Context) {
-	epay := service.NewEpayService()
+	// 旧回调地址 /api/orders/notify 默认为易支付
+	name := c.Param("provider")
+	if name == "" {
+		name = service.PaymentProviderEpay
+	}
+
+	provider, ok := service.GetPaymentProvider(name)
+	if !ok {
+		c.String(http.StatusNotFound, "fail")
+		return
+	}
 
 	// 验证签名
-	if !epay.VerifyNotify(c) {
+	result, err := provider.VerifyNotify(c)
+	if err != nil {
 		c.String(http.StatusBadRequest, "fail")
 		return
 	}
 
-	orderNo := c.Query("out_trade_no")
-	tradeNo := c.Query("trade_no")
-	tradeStatus := c.Query("trade_status")
-
-	if tradeStatus != "TRADE_SUCCESS" {
-		c.String(http.StatusOK, "success")
+	if !result.Paid {
+		provider.NotifyAck(c, true)
 		return
 	}
 
 	// 查找订单
 	var order model.Order
-	if err := model.DB.Where("order_no = ?", orderNo).First(&order).Error; err != nil {
-		c.String(http.StatusOK, "fail")
+	if err := model.DB.Where("order_no = ?", result.OrderNo).First(&order).Error; err != nil {
+		provider.NotifyAck(c, false)
 		return
 	}
 
-	if order.Status != model.OrderStatusPending {
-		c.String(http.StatusOK, "success")
+	// 处理订单完成
+	if err := service.ProcessPaymentResult(&order, provider.Name(), result); err != nil {
+		log.Printf("处理订单 %s 支付回调失败: %v", order.OrderNo, err)
+		provider.NotifyAck(c, false)
 		return
 	}
 
-	// 处理订单完成
-	if err := service.CompleteOrder(&order, tradeNo); err != nil {
-		c.String(http.StatusOK, "fail")
+	provider.NotifyAck(c, true)
+}
+
+// CheckOrderPayment 用户主动查询订单支付状态（已支付但未到账时使用）
+func CheckOrderPayment(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的订单 ID",
+		})
 		return
 	}
 
-	c.String(http.StatusOK, "success")
+	var order model.Order
+	if err := model.DB.Where("id = ? AND user_id = ?", id, user.ID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "订单不存在",
+		})
+		return
+	}
+
+	if order.Status == model.OrderStatusPending {
+		if err := service.CheckOrderPayment(&order); err != nil {
+			c.JSON(http.StatusInternalServerError, dto.Response{
+				Success: false,
+				Message: "查询支付状态失败: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    order,
+	})
 }
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/model"
+	"newapi-subscribe/internal/service"
+)
+
+// paidDuringCreateProvider 在创建支付期间把订单置为已支付，模拟回调先于 CreatePayment 返回
+type paidDuringCreateProvider struct{}
+
+func (p *paidDuringCreateProvider) Name() string      { return "paidduringcreate" }
+func (p *paidDuringCreateProvider) Methods() []string { return []string{"alipay"} }
+func (p *paidDuringCreateProvider) CreatePayment(order *model.Order, method, subject string) (string, error) {
+	err := model.DB.Model(&model.Order{}).Where("id = ?", order.ID).
+		Update("status", model.OrderStatusPaid).Error
+	return "https://pay.example.com", err
+}
+func (p *paidDuringCreateProvider) VerifyNotify(c *gin.Context) (*service.PaymentResult, error) {
+	return nil, errors.New("not supported")
+}
+func (p *paidDuringCreateProvider) NotifyAck(c *gin.Context, ok bool) {}
+func (p *paidDuringCreateProvider) QueryOrder(order *model.Order) (*service.PaymentResult, error) {
+	return nil, errors.New("not supported")
+}
+func (p *paidDuringCreateProvider) Refund(order *model.Order, amount float64) error { return nil }
+
+func TestCreatePaymentKeepsOrderCompletedMeanwhile(t *testing.T) {
+	setupTest(t)
+	service.RegisterPaymentProvider(&paidDuringCreateProvider{})
+
+	user := &model.User{Username: "alice"}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	order := &model.Order{
+		OrderNo:    "PAY_RACE",
+		UserID:     user.ID,
+		OrderType:  model.OrderTypeTopup,
+		PeriodDays: 0,
+		Amount:     10,
+		Status:     model.OrderStatusPending,
+	}
+	if err := model.DB.Create(order).Error; err != nil {
+		t.Fatalf("创建订单失败: %v", err)
+	}
+
+	req := dto.PayRequest{OrderID: order.ID, Provider: "paidduringcreate", PaymentMethod: "alipay"}
+	if code, resp := postJSON(t, CreatePayment, user, req); code != http.StatusBadRequest || resp.Success {
+		t.Fatalf("订单已完成时应返回状态异常: %d %+v", code, resp)
+	}
+
+	var saved model.Order
+	model.DB.First(&saved, order.ID)
+	if saved.Status != model.OrderStatusPaid {
+		t.Errorf("订单状态 = %s, 创建支付不应写回待支付", saved.Status)
+	}
+}
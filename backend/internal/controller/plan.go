@@ -86,9 +86,8 @@ func GetPlanModels(c *gin.Context) {
 		return
 	}
 
-	// 从 new-api 获取分组下的模型
-	client := service.NewNewAPIClient()
-	models, err := client.GetGroupModels(plan.NewAPIGroup)
+	// 从 new-api 获取分组下的模型（带缓存）
+	models, err := service.GetPlanModels(&plan)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
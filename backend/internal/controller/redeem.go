@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/middleware"
+	"newapi-subscribe/internal/model"
+	"newapi-subscribe/internal/service"
+)
+
+// RedeemCode 使用兑换码兑换订阅
+func RedeemCode(c *gin.Context) {
+	var req dto.RedeemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	order, err := service.RedeemSubscriptionCode(user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "兑换失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "兑换成功",
+		Data:    order,
+	})
+}
+
+// AdminGetRedeemCodes 获取兑换码列表
+func AdminGetRedeemCodes(c *gin.Context) {
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	var codes []model.RedeemCode
+	var total int64
+
+	query := redeemCodeQuery(c)
+	query.Count(&total)
+	query.Preload("Plan").
+		Order("id DESC").
+		Offset(pagination.Offset()).
+		Limit(pagination.PerPage).
+		Find(&codes)
+
+	c.JSON(http.StatusOK, dto.PaginatedResponse{
+		Success: true,
+		Data:    codes,
+		Total:   total,
+		Page:    pagination.Page,
+		PerPage: pagination.PerPage,
+	})
+}
+
+// AdminGenerateRedeemCodes 批量生成兑换码
+func AdminGenerateRedeemCodes(c *gin.Context) {
+	var req dto.GenerateRedeemCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	var plan model.Plan
+	if err := model.DB.First(&plan, req.PlanID).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "套餐不存在",
+		})
+		return
+	}
+
+	batchNo, codes, err := service.GenerateRedeemCodes(&plan, req.Days, req.Count, req.ExpiresAt, req.Remark)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "生成兑换码失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data: gin.H{
+			"batch_no": batchNo,
+			"codes":    codes,
+		},
+	})
+}
+
+// AdminExportRedeemCodes 导出兑换码 CSV
+func AdminExportRedeemCodes(c *gin.Context) {
+	var codes []model.RedeemCode
+	redeemCodeQuery(c).Preload("Plan").Order("id ASC").Find(&codes)
+
+	filename := fmt.Sprintf("redeem_codes_%s.csv", time.Now().Format("20060102150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	// 写入 BOM 以便 Excel 正确识别 UTF-8
+	c.Writer.Write([]byte("\xEF\xBB\xBF"))
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"兑换码", "批次", "套餐", "天数", "状态", "过期时间", "备注", "使用用户", "使用时间"})
+	for _, code := range codes {
+		planName := ""
+		if code.Plan != nil {
+			planName = code.Plan.Name
+		}
+		expiresAt := ""
+		if code.ExpiresAt != nil {
+			expiresAt = code.ExpiresAt.Format("2006-01-02 15:04:05")
+		}
+		usedBy := ""
+		usedAt := ""
+		if code.UsedAt != nil {
+			usedBy = strconv.FormatUint(uint64(code.UsedBy), 10)
+			usedAt = code.UsedAt.Format("2006-01-02 15:04:05")
+		}
+		w.Write([]string{
+			code.Code,
+			code.BatchNo,
+			planName,
+			strconv.Itoa(code.Days),
+			code.Status,
+			expiresAt,
+			code.Remark,
+			usedBy,
+			usedAt,
+		})
+	}
+	w.Flush()
+}
+
+// AdminRevokeRedeemCode 作废兑换码
+func AdminRevokeRedeemCode(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的兑换码 ID",
+		})
+		return
+	}
+
+	result := model.DB.Model(&model.RedeemCode{}).
+		Where("id = ? AND status = ?", id, model.RedeemCodeStatusUnused).
+		Update("status", model.RedeemCodeStatusRevoked)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "作废失败",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "只能作废未使用的兑换码",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "作废成功",
+	})
+}
+
+// AdminRevokeRedeemBatch 作废整个批次中未使用的兑换码
+func AdminRevokeRedeemBatch(c *gin.Context) {
+	batchNo := c.Param("batch_no")
+
+	result := model.DB.Model(&model.RedeemCode{}).
+		Where("batch_no = ? AND status = ?", batchNo, model.RedeemCodeStatusUnused).
+		Update("status", model.RedeemCodeStatusRevoked)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "作废失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: fmt.Sprintf("已作废 %d 个兑换码", result.RowsAffected),
+	})
+}
+
+// redeemCodeQuery 兑换码列表/导出的筛选条件
+func redeemCodeQuery(c *gin.Context) *gorm.DB {
+	query := model.DB.Model(&model.RedeemCode{})
+	if batchNo := c.Query("batch_no"); batchNo != "" {
+		query = query.Where("batch_no = ?", batchNo)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if planID := c.Query("plan_id"); planID != "" {
+		query = query.Where("plan_id = ?", planID)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		query = query.Where("code LIKE ?", "%"+keyword+"%")
+	}
+	return query
+}
@@ -1,11 +1,14 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"newapi-subscribe/internal/dto"
 	"newapi-subscribe/internal/middleware"
 	"newapi-subscribe/internal/model"
@@ -16,12 +19,13 @@ import (
 func GetCurrentSubscription(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 
-	var subscription model.Subscription
-	err := model.DB.Preload("Plan").
-		Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-		First(&subscription).Error
+	var subscriptions []model.Subscription
+	model.DB.Preload("Plan").
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionCurrentStatuses).
+		Order("id ASC").
+		Find(&subscriptions)
 
-	if err != nil {
+	if len(subscriptions) == 0 {
 		c.JSON(http.StatusOK, dto.Response{
 			Success: true,
 			Data:    nil, // 没有订阅
@@ -29,10 +33,13 @@ func GetCurrentSubscription(c *gin.Context) {
 		return
 	}
 
+	// subscription 为决定 new-api 分组的主订阅，兼容单订阅的调用方
+	subscription := service.PrimarySubscription(subscriptions)
+
 	// 获取 new-api 当前余额
 	var currentQuota int
 	if user.NewAPIBound == 1 {
-		client := service.NewNewAPIClient()
+		client := service.NewAPI()
 		if newAPIUser, err := client.GetUser(user.NewAPIUserID); err == nil {
 			currentQuota = newAPIUser.Quota
 		}
@@ -42,8 +49,9 @@ func GetCurrentSubscription(c *gin.Context) {
 		Success: true,
 		Data: gin.H{
 			"subscription":  subscription,
+			"subscriptions": subscriptions,
 			"current_quota": currentQuota,
-			"days_remaining": subscription.DaysRemaining(),
+			"days_remaining": subscription.DaysRemaining(model.LocalDate(time.Now(), user.Location())),
 		},
 	})
 }
@@ -79,16 +87,24 @@ func PurchaseSubscription(c *gin.Context) {
 		return
 	}
 
+	if plan.IsTrial == 1 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "试用套餐请直接领取",
+		})
+		return
+	}
+
 	// 确定购买天数
 	periodDays := plan.PeriodDays
 	if plan.PeriodType == model.PeriodTypeCustom && req.PeriodDays > 0 {
 		periodDays = req.PeriodDays
 	}
 
-	// 检查是否已有相同套餐的活跃订阅
+	// 检查是否已有相同套餐的活跃订阅（不同套餐可同时持有，额度叠加）
 	var existingSub model.Subscription
-	if err := model.DB.Where("user_id = ? AND plan_id = ? AND status = ?",
-		user.ID, plan.ID, model.SubscriptionStatusActive).First(&existingSub).Error; err == nil {
+	if err := model.DB.Where("user_id = ? AND plan_id = ? AND status IN ?",
+		user.ID, plan.ID, model.SubscriptionCurrentStatuses).First(&existingSub).Error; err == nil {
 		c.JSON(http.StatusBadRequest, dto.Response{
 			Success: false,
 			Message: "您已有该套餐的有效订阅，请选择续费或其他套餐",
@@ -97,7 +113,7 @@ func PurchaseSubscription(c *gin.Context) {
 	}
 
 	// 处理 new-api 账号
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 	switch req.NewAPIAction {
 	case "bind_existing":
 		// 验证并绑定现有账号
@@ -112,7 +128,7 @@ func PurchaseSubscription(c *gin.Context) {
 		user.NewAPIUserID = newAPIUser.ID
 		user.NewAPIUsername = newAPIUser.Username
 		user.NewAPIBound = 1
-		model.DB.Save(user)
+		model.SaveUserNewAPIBinding(model.DB, user)
 
 	case "create_new":
 		// 检查是否已绑定
@@ -148,16 +164,26 @@ func PurchaseSubscription(c *gin.Context) {
 		PeriodDays: periodDays,
 		Amount:     amount,
 		Status:     model.OrderStatusPending,
+		ExpiresAt:  model.NewOrderExpiresAt(),
 	}
 
-	// 检查是否有其他活跃订阅（如果有则为续费/切换套餐）
-	var anyActiveSub model.Subscription
-	if err := model.DB.Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-		First(&anyActiveSub).Error; err == nil {
-		order.OrderType = model.OrderTypeRenew
+	// 应用优惠码
+	if err := service.ApplyCoupon(order, req.CouponCode, &plan); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "优惠码不可用: " + err.Error(),
+		})
+		return
 	}
 
-	if err := model.DB.Create(order).Error; err != nil {
+	if err := createOrder(order); err != nil {
+		if errors.Is(err, service.ErrCouponUnavailable) {
+			c.JSON(http.StatusBadRequest, dto.Response{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "创建订单失败",
@@ -174,9 +200,9 @@ func PurchaseSubscription(c *gin.Context) {
 	})
 }
 
-// RenewSubscription 续费订阅
-func RenewSubscription(c *gin.Context) {
-	var req dto.RenewRequest
+// ClaimTrial 领取试用套餐
+func ClaimTrial(c *gin.Context) {
+	var req dto.ClaimTrialRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, dto.Response{
 			Success: false,
@@ -187,21 +213,69 @@ func RenewSubscription(c *gin.Context) {
 
 	user := middleware.GetCurrentUser(c)
 
-	// 获取当前订阅
-	var subscription model.Subscription
-	if err := model.DB.Preload("Plan").
-		Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-		First(&subscription).Error; err != nil {
+	var plan model.Plan
+	if err := model.DB.First(&plan, req.PlanID).Error; err != nil {
 		c.JSON(http.StatusNotFound, dto.Response{
 			Success: false,
-			Message: "没有可续费的订阅",
+			Message: "套餐不存在",
+		})
+		return
+	}
+
+	if plan.Status != model.PlanStatusOn {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "套餐已下架",
+		})
+		return
+	}
+
+	order, err := service.ClaimTrial(user, &plan)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "领取试用失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "试用已开通",
+		Data:    order,
+	})
+}
+
+// RenewSubscription 续费订阅
+func RenewSubscription(c *gin.Context) {
+	var req dto.RenewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
 		})
 		return
 	}
 
+	user := middleware.GetCurrentUser(c)
+
+	// 获取要续费的订阅
+	subscription, err := findUserSubscription(user.ID, req.SubscriptionID, model.SubscriptionCurrentStatuses)
+	if err != nil {
+		respondSubscriptionError(c, err, "没有可续费的订阅")
+		return
+	}
+
 	// 计算价格
 	var plan model.Plan
 	model.DB.First(&plan, subscription.PlanID)
+	if plan.IsTrial == 1 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "试用套餐不支持续费，请选择正式套餐",
+		})
+		return
+	}
 	amount := plan.CalculatePrice(req.PeriodDays)
 
 	// 创建续费订单
@@ -213,9 +287,242 @@ func RenewSubscription(c *gin.Context) {
 		PeriodDays: req.PeriodDays,
 		Amount:     amount,
 		Status:     model.OrderStatusPending,
+		ExpiresAt:  model.NewOrderExpiresAt(),
+
+		SubscriptionID: subscription.ID,
+	}
+
+	// 应用优惠码
+	if err := service.ApplyCoupon(order, req.CouponCode, &plan); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "优惠码不可用: " + err.Error(),
+		})
+		return
+	}
+
+	if err := createOrder(order); err != nil {
+		if errors.Is(err, service.ErrCouponUnavailable) {
+			c.JSON(http.StatusBadRequest, dto.Response{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "创建订单失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    order,
+	})
+}
+
+// SetAutoRenew 开启或关闭当前订阅的自动续费
+func SetAutoRenew(c *gin.Context) {
+	var req dto.AutoRenewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	subscription, err := findUserSubscription(user.ID, req.SubscriptionID, model.SubscriptionCurrentStatuses)
+	if err != nil {
+		respondSubscriptionError(c, err, "没有有效的订阅")
+		return
+	}
+
+	var plan model.Plan
+	model.DB.Unscoped().First(&plan, subscription.PlanID)
+	if req.AutoRenew == 1 && plan.IsTrial == 1 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "试用套餐不支持自动续费",
+		})
+		return
+	}
+
+	if err := model.DB.Model(subscription).Update("auto_renew", req.AutoRenew).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "更新失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    subscription,
+	})
+}
+
+// PauseSubscription 暂停当前订阅
+func PauseSubscription(c *gin.Context) {
+	// 请求体可选，只有一个订阅时无需指定
+	var req dto.SubscriptionActionRequest
+	c.ShouldBindJSON(&req)
+
+	user := middleware.GetCurrentUser(c)
+
+	subscription, err := findUserSubscription(user.ID, req.SubscriptionID, []string{model.SubscriptionStatusActive})
+	if err != nil {
+		respondSubscriptionError(c, err, "没有可暂停的订阅")
+		return
+	}
+
+	if err := service.PauseSubscription(subscription); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "暂停失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "订阅已暂停",
+		Data:    subscription,
+	})
+}
+
+// ResumeSubscription 恢复已暂停的订阅
+func ResumeSubscription(c *gin.Context) {
+	// 请求体可选，只有一个暂停中的订阅时无需指定
+	var req dto.SubscriptionActionRequest
+	c.ShouldBindJSON(&req)
+
+	user := middleware.GetCurrentUser(c)
+
+	subscription, err := findUserSubscription(user.ID, req.SubscriptionID, []string{model.SubscriptionStatusPaused})
+	if err != nil {
+		respondSubscriptionError(c, err, "没有已暂停的订阅")
+		return
+	}
+
+	if err := service.ResumeSubscription(subscription); err != nil {
+		if subscription.Status != model.SubscriptionStatusActive {
+			c.JSON(http.StatusBadRequest, dto.Response{
+				Success: false,
+				Message: "恢复失败: " + err.Error(),
+			})
+			return
+		}
+		// 本地已恢复，仅 new-api 额度未能立即恢复
+		c.JSON(http.StatusOK, dto.Response{
+			Success: true,
+			Message: err.Error(),
+			Data:    subscription,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "订阅已恢复",
+		Data:    subscription,
+	})
+}
+
+// PreviewPlanChange 预览变更套餐的抵扣和应付金额
+func PreviewPlanChange(c *gin.Context) {
+	var req dto.ChangePlanQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	subscription, plan, periodDays, ok := loadPlanChange(c, user.ID, req.SubscriptionID, req.PlanID, req.PeriodDays)
+	if !ok {
+		return
+	}
+
+	quote, err := service.QuotePlanChange(subscription, plan, periodDays)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    quote,
+	})
+}
+
+// ChangePlan 变更套餐（升级/降级）
+func ChangePlan(c *gin.Context) {
+	var req dto.ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	subscription, plan, periodDays, ok := loadPlanChange(c, user.ID, req.SubscriptionID, req.PlanID, req.PeriodDays)
+	if !ok {
+		return
+	}
+
+	order := &model.Order{
+		OrderNo:    generateOrderNo(user.ID),
+		UserID:     user.ID,
+		PlanID:     plan.ID,
+		PeriodDays: periodDays,
+		Amount:     plan.CalculatePrice(periodDays),
+		Status:     model.OrderStatusPending,
+		ExpiresAt:  model.NewOrderExpiresAt(),
+	}
+
+	// 应用优惠码；剩余价值已足够抵扣全部金额时不使用，避免无谓占用使用次数
+	couponCode := req.CouponCode
+	if quote, err := service.QuotePlanChange(subscription, plan, periodDays); err == nil && quote.Amount <= 0 {
+		couponCode = ""
+	}
+	if err := service.ApplyCoupon(order, couponCode, plan); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "优惠码不可用: " + err.Error(),
+		})
+		return
 	}
 
-	if err := model.DB.Create(order).Error; err != nil {
+	// 抵扣当前订阅剩余价值
+	if err := service.ApplyPlanChange(order, subscription, plan); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := createOrder(order); err != nil {
+		if errors.Is(err, service.ErrCouponUnavailable) {
+			c.JSON(http.StatusBadRequest, dto.Response{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "创建订单失败",
@@ -229,6 +536,102 @@ func RenewSubscription(c *gin.Context) {
 	})
 }
 
+// loadPlanChange 加载变更套餐所需的当前订阅和目标套餐，失败时直接返回错误响应
+func loadPlanChange(c *gin.Context, userID, subscriptionID, planID uint, reqPeriodDays int) (*model.Subscription, *model.Plan, int, bool) {
+	subscription, err := findUserSubscription(userID, subscriptionID, []string{model.SubscriptionStatusActive})
+	if err != nil {
+		respondSubscriptionError(c, err, "没有可变更的订阅")
+		return nil, nil, 0, false
+	}
+
+	var plan model.Plan
+	if err := model.DB.First(&plan, planID).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "套餐不存在",
+		})
+		return nil, nil, 0, false
+	}
+
+	if plan.Status != model.PlanStatusOn {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "套餐已下架",
+		})
+		return nil, nil, 0, false
+	}
+
+	if plan.IsTrial == 1 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "不能变更为试用套餐",
+		})
+		return nil, nil, 0, false
+	}
+
+	// 目标套餐已被用户的其他订阅持有时不能变更
+	var count int64
+	model.DB.Model(&model.Subscription{}).
+		Where("user_id = ? AND plan_id = ? AND id <> ? AND status IN ?",
+			userID, plan.ID, subscription.ID, model.SubscriptionCurrentStatuses).
+		Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "您已有该套餐的有效订阅",
+		})
+		return nil, nil, 0, false
+	}
+
+	periodDays := plan.PeriodDays
+	if plan.PeriodType == model.PeriodTypeCustom && reqPeriodDays > 0 {
+		periodDays = reqPeriodDays
+	}
+
+	return subscription, &plan, periodDays, true
+}
+
+// errSubscriptionAmbiguous 用户有多个符合条件的订阅但未指定订阅 ID
+var errSubscriptionAmbiguous = errors.New("您有多个订阅，请指定要操作的订阅")
+
+// findUserSubscription 查找用户指定状态的订阅；未指定订阅 ID 时用户只能有一个符合条件的订阅
+func findUserSubscription(userID, subscriptionID uint, statuses []string) (*model.Subscription, error) {
+	query := model.DB.Where("user_id = ? AND status IN ?", userID, statuses)
+	if subscriptionID > 0 {
+		var subscription model.Subscription
+		if err := query.First(&subscription, subscriptionID).Error; err != nil {
+			return nil, err
+		}
+		return &subscription, nil
+	}
+
+	var subscriptions []model.Subscription
+	query.Order("id ASC").Limit(2).Find(&subscriptions)
+	switch len(subscriptions) {
+	case 0:
+		return nil, gorm.ErrRecordNotFound
+	case 1:
+		return &subscriptions[0], nil
+	default:
+		return nil, errSubscriptionAmbiguous
+	}
+}
+
+// respondSubscriptionError 返回查找订阅失败的响应
+func respondSubscriptionError(c *gin.Context, err error, notFoundMessage string) {
+	if errors.Is(err, errSubscriptionAmbiguous) {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusNotFound, dto.Response{
+		Success: false,
+		Message: notFoundMessage,
+	})
+}
+
 // GetUsageLogs 获取使用日志
 func GetUsageLogs(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
@@ -239,24 +642,12 @@ func GetUsageLogs(c *gin.Context) {
 		pagination.PerPage = 20
 	}
 
-	// 获取用户订阅
-	var subscription model.Subscription
-	if err := model.DB.Where("user_id = ?", user.ID).Order("created_at DESC").First(&subscription).Error; err != nil {
-		c.JSON(http.StatusOK, dto.PaginatedResponse{
-			Success: true,
-			Data:    []model.UsageLog{},
-			Total:   0,
-			Page:    pagination.Page,
-			PerPage: pagination.PerPage,
-		})
-		return
-	}
-
+	// 每个用户每天一条，多个订阅叠加的用量合并记录
 	var logs []model.UsageLog
 	var total int64
 
-	model.DB.Model(&model.UsageLog{}).Where("subscription_id = ?", subscription.ID).Count(&total)
-	model.DB.Where("subscription_id = ?", subscription.ID).
+	model.DB.Model(&model.UsageLog{}).Where("user_id = ?", user.ID).Count(&total)
+	model.DB.Where("user_id = ?", user.ID).
 		Order("log_date DESC").
 		Offset(pagination.Offset()).
 		Limit(pagination.PerPage).
@@ -286,7 +677,7 @@ func GetUsageDetail(c *gin.Context) {
 	startDate := c.Query("start_date")
 	endDate := c.Query("end_date")
 
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 	logs, err := client.GetUserLogs(user.NewAPIUserID, startDate, endDate)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
@@ -314,8 +705,8 @@ func GetTodayUsage(c *gin.Context) {
 		return
 	}
 
-	client := service.NewNewAPIClient()
-	todayUsed, err := client.GetUserQuotaUsedToday(user.NewAPIUserID)
+	client := service.NewAPI()
+	todayUsed, err := client.GetUserQuotaUsedToday(user.NewAPIUserID, user.Location())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
@@ -324,13 +715,12 @@ func GetTodayUsage(c *gin.Context) {
 		return
 	}
 
-	// 获取当前订阅信息
-	var subscription model.Subscription
+	// 获取当前订阅的每日额度之和
 	var dailyQuota int
-	if err := model.DB.Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-		First(&subscription).Error; err == nil {
-		dailyQuota = subscription.DailyQuota
-	}
+	model.DB.Model(&model.Subscription{}).
+		Select("COALESCE(SUM(daily_quota), 0)").
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionSyncStatuses).
+		Scan(&dailyQuota)
 
 	// 获取当前余额
 	var currentQuota int
@@ -348,6 +738,27 @@ func GetTodayUsage(c *gin.Context) {
 	})
 }
 
+// createOrder 创建订单，优惠或抵扣后金额为 0 时直接完成
+func createOrder(order *model.Order) error {
+	free := (order.CouponID > 0 || order.CreditAmount > 0) && order.Amount <= 0
+	if free {
+		order.Amount = 0
+		order.PaymentMethod = model.PaymentMethodCoupon
+		if order.CouponID == 0 {
+			order.PaymentMethod = model.PaymentMethodCredit
+		}
+	}
+
+	if err := service.CreateOrder(order); err != nil {
+		return err
+	}
+
+	if free {
+		return service.CompleteOrder(order, strings.ToUpper(order.PaymentMethod)+"_"+order.OrderNo)
+	}
+	return nil
+}
+
 func generateOrderNo(userID uint) string {
 	return fmt.Sprintf("SUB%d%d", userID, time.Now().UnixNano())
 }
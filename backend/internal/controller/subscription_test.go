@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/model"
+)
+
+// createTestPlan 创建 30 天固定价格的套餐
+func createTestPlan(t *testing.T, price float64, dailyQuota int) *model.Plan {
+	t.Helper()
+
+	plan := &model.Plan{
+		Name:        "测试套餐",
+		PeriodType:  model.PeriodTypeDay,
+		PeriodDays:  30,
+		QuotaWindow: model.QuotaWindowDay,
+		DailyQuota:  dailyQuota,
+		PriceType:   model.PriceTypeFixed,
+		Price:       price,
+		GraceDays:   -1,
+		NewAPIGroup: "vip",
+		Status:      model.PlanStatusOn,
+	}
+	if err := model.DB.Create(plan).Error; err != nil {
+		t.Fatalf("创建套餐失败: %v", err)
+	}
+	return plan
+}
+
+func TestChangePlanSkipsCouponWhenCreditCoversPrice(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("nina", "password", "default", 1000)
+	user := &model.User{Username: "nina", NewAPIUserID: newAPIUser.ID, NewAPIUsername: "nina", NewAPIBound: 1}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+
+	// 当前套餐剩余约 30 天，价值远超新套餐价格
+	from := createTestPlan(t, 300, 1000)
+	to := createTestPlan(t, 10, 500)
+	today := model.LocalDate(time.Now(), user.Location())
+	sub := &model.Subscription{
+		UserID:      user.ID,
+		PlanID:      from.ID,
+		Status:      model.SubscriptionStatusActive,
+		StartDate:   today,
+		EndDate:     today.AddDate(0, 0, 30),
+		TodayQuota:  from.DailyQuota,
+		QuotaWindow: from.QuotaWindow,
+		DailyQuota:  from.DailyQuota,
+		NewAPIGroup: from.NewAPIGroup,
+	}
+	if err := model.DB.Create(sub).Error; err != nil {
+		t.Fatalf("创建订阅失败: %v", err)
+	}
+	coupon := &model.Coupon{
+		Code:          "SAVE5",
+		DiscountType:  model.CouponTypeFixed,
+		DiscountValue: 5,
+		TotalLimit:    1,
+		Status:        1,
+	}
+	if err := model.DB.Create(coupon).Error; err != nil {
+		t.Fatalf("创建优惠码失败: %v", err)
+	}
+
+	req := dto.ChangePlanRequest{SubscriptionID: sub.ID, PlanID: to.ID, CouponCode: coupon.Code}
+	if code, resp := postJSON(t, ChangePlan, user, req); code != http.StatusOK || !resp.Success {
+		t.Fatalf("变更套餐失败: %d %+v", code, resp)
+	}
+
+	var order model.Order
+	model.DB.Where("user_id = ? AND subscription_id = ?", user.ID, sub.ID).First(&order)
+	if order.CouponID != 0 || order.Amount != 0 {
+		t.Errorf("订单优惠码 = %d, 应付 = %.2f, 期望不使用优惠码且无需支付", order.CouponID, order.Amount)
+	}
+	model.DB.First(coupon, coupon.ID)
+	if coupon.UsedCount != 0 {
+		t.Errorf("优惠码已占用 %d 次, 剩余价值已抵扣全部金额时不应占用", coupon.UsedCount)
+	}
+}
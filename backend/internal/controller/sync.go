@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/model"
+	"newapi-subscribe/internal/service"
+)
+
+// AdminGetSyncRuns 获取同步记录
+func AdminGetSyncRuns(c *gin.Context) {
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	source := c.Query("source")
+	status := c.Query("status")
+
+	var runs []model.SyncRun
+	var total int64
+
+	query := model.DB.Model(&model.SyncRun{})
+	if source != "" {
+		query = query.Where("source = ?", source)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	query.Count(&total)
+	query.Order("id DESC").
+		Offset(pagination.Offset()).
+		Limit(pagination.PerPage).
+		Find(&runs)
+
+	c.JSON(http.StatusOK, dto.PaginatedResponse{
+		Success: true,
+		Data:    runs,
+		Total:   total,
+		Page:    pagination.Page,
+		PerPage: pagination.PerPage,
+	})
+}
+
+// AdminPreviewSync 预览全量同步的结果，不写入 new-api 和本地订阅
+func AdminPreviewSync(c *gin.Context) {
+	previews, err := service.PreviewSync()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "预览同步失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 默认只返回需要更新的订阅
+	if c.Query("all") != "1" {
+		changed := make([]service.SyncPreview, 0, len(previews))
+		for _, preview := range previews {
+			if preview.Changed {
+				changed = append(changed, preview)
+			}
+		}
+		previews = changed
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    previews,
+	})
+}
+
+// AdminGetSyncRun 获取同步记录详情及失败的订阅
+func AdminGetSyncRun(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的同步记录 ID",
+		})
+		return
+	}
+
+	var run model.SyncRun
+	if err := model.DB.First(&run, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, dto.Response{
+			Success: false,
+			Message: "同步记录不存在",
+		})
+		return
+	}
+
+	var failures []model.SyncResult
+	model.DB.Where("run_id = ? AND status = ?", run.ID, model.SyncResultStatusFailed).
+		Order("id ASC").
+		Find(&failures)
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data: gin.H{
+			"run":      run,
+			"failures": failures,
+		},
+	})
+}
+
+// AdminGetSyncResults 获取订阅同步结果，可按同步记录、用户、订阅筛选
+func AdminGetSyncResults(c *gin.Context) {
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	runID := c.Query("run_id")
+	userID := c.Query("user_id")
+	subscriptionID := c.Query("subscription_id")
+	status := c.Query("status")
+
+	var results []model.SyncResult
+	var total int64
+
+	query := model.DB.Model(&model.SyncResult{})
+	if runID != "" {
+		query = query.Where("run_id = ?", runID)
+	}
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if subscriptionID != "" {
+		query = query.Where("subscription_id = ?", subscriptionID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	query.Count(&total)
+	query.Order("id DESC").
+		Offset(pagination.Offset()).
+		Limit(pagination.PerPage).
+		Find(&results)
+
+	c.JSON(http.StatusOK, dto.PaginatedResponse{
+		Success: true,
+		Data:    results,
+		Total:   total,
+		Page:    pagination.Page,
+		PerPage: pagination.PerPage,
+	})
+}
+
+// AdminRetrySyncRun 重新同步某次同步中失败的订阅
+func AdminRetrySyncRun(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的同步记录 ID",
+		})
+		return
+	}
+
+	run, err := service.RetrySyncRun(uint(id))
+	if errors.Is(err, service.ErrSyncRunning) {
+		c.JSON(http.StatusConflict, dto.Response{
+			Success: false,
+			Message: "已有同步正在进行中",
+			Data:    service.RunningSync(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "重试失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Message: "重试任务已启动",
+		Data:    run,
+	})
+}
@@ -24,7 +24,7 @@ func UpdateProfile(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 	user.Email = req.Email
 
-	if err := model.DB.Save(user).Error; err != nil {
+	if err := model.DB.Model(user).Update("email", user.Email).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "更新失败",
@@ -60,7 +60,7 @@ func BindNewAPI(c *gin.Context) {
 	}
 
 	// 验证 new-api 账号
-	client := service.NewNewAPIClient()
+	client := service.NewAPI()
 	newAPIUser, err := client.Login(req.Username, req.Password)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, dto.Response{
@@ -84,7 +84,7 @@ func BindNewAPI(c *gin.Context) {
 	user.NewAPIUsername = newAPIUser.Username
 	user.NewAPIBound = 1
 
-	if err := model.DB.Save(user).Error; err != nil {
+	if err := model.SaveUserNewAPIBinding(model.DB, user); err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "绑定失败",
@@ -114,7 +114,44 @@ func UpdateEmailSettings(c *gin.Context) {
 	user.EmailRemind = req.EmailRemind
 	user.RemindDays = req.RemindDays
 
-	if err := model.DB.Save(user).Error; err != nil {
+	if err := model.DB.Model(user).Updates(map[string]interface{}{
+		"email_remind": user.EmailRemind,
+		"remind_days":  user.RemindDays,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "更新失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// UpdateTimezone 更新时区设置
+func UpdateTimezone(c *gin.Context) {
+	var req dto.UpdateTimezoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	if _, err := model.LoadTimezone(req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "无效的时区",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+	if err := service.SetUserTimezone(user, req.Timezone); err != nil {
 		c.JSON(http.StatusInternalServerError, dto.Response{
 			Success: false,
 			Message: "更新失败",
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/dto"
+	"newapi-subscribe/internal/middleware"
+	"newapi-subscribe/internal/model"
+)
+
+// GetWallet 获取钱包余额
+func GetWallet(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	var current model.User
+	model.DB.Select("balance").First(&current, user.ID)
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data: gin.H{
+			"balance": current.Balance,
+		},
+	})
+}
+
+// GetWalletTransactions 获取钱包流水
+func GetWalletTransactions(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+
+	var pagination dto.PaginationQuery
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		pagination.Page = 1
+		pagination.PerPage = 20
+	}
+
+	var transactions []model.WalletTransaction
+	var total int64
+
+	query := model.DB.Model(&model.WalletTransaction{}).Where("user_id = ?", user.ID)
+	if txType := c.Query("type"); txType != "" {
+		query = query.Where("type = ?", txType)
+	}
+	query.Count(&total)
+	query.Order("id DESC").
+		Offset(pagination.Offset()).
+		Limit(pagination.PerPage).
+		Find(&transactions)
+
+	c.JSON(http.StatusOK, dto.PaginatedResponse{
+		Success: true,
+		Data:    transactions,
+		Total:   total,
+		Page:    pagination.Page,
+		PerPage: pagination.PerPage,
+	})
+}
+
+// TopupWallet 创建钱包充值订单，之后通过 /orders/pay 发起支付
+func TopupWallet(c *gin.Context) {
+	var req dto.TopupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.Response{
+			Success: false,
+			Message: "参数错误",
+		})
+		return
+	}
+
+	user := middleware.GetCurrentUser(c)
+
+	order := &model.Order{
+		OrderNo:   generateOrderNo(user.ID),
+		UserID:    user.ID,
+		OrderType: model.OrderTypeTopup,
+		Amount:    req.Amount,
+		Status:    model.OrderStatusPending,
+		ExpiresAt: model.NewOrderExpiresAt(),
+	}
+
+	if err := model.DB.Create(order).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, dto.Response{
+			Success: false,
+			Message: "创建订单失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Response{
+		Success: true,
+		Data:    order,
+	})
+}
@@ -30,6 +30,36 @@ func Start() {
 		return
 	}
 
+	// 额度周期同步任务：按各用户时区在当地的周期开始时重置额度（兼容非整点时区）
+	if _, err := scheduler.AddFunc("*/15 * * * *", service.SyncDueSubscriptions); err != nil {
+		log.Printf("添加额度周期同步任务失败: %v", err)
+		return
+	}
+
+	// 使用日志汇总任务
+	if _, err := scheduler.AddFunc("@every 30m", service.IngestUsageLogs); err != nil {
+		log.Printf("添加使用日志汇总任务失败: %v", err)
+		return
+	}
+
+	// 待支付订单主动查询任务（补偿丢失的支付回调）
+	if _, err := scheduler.AddFunc("@every 2m", service.PollPendingOrders); err != nil {
+		log.Printf("添加订单查询任务失败: %v", err)
+		return
+	}
+
+	// 订单 new-api 操作重试任务
+	if _, err := scheduler.AddFunc("@every 5m", service.RetryOrderTasks); err != nil {
+		log.Printf("添加订单重试任务失败: %v", err)
+		return
+	}
+
+	// 过期订单取消任务
+	if _, err := scheduler.AddFunc("@every 1m", service.CancelExpiredOrders); err != nil {
+		log.Printf("添加过期订单任务失败: %v", err)
+		return
+	}
+
 	scheduler.Start()
 	log.Printf("定时任务已启动，调度: %s", config.Cfg.CronSchedule)
 }
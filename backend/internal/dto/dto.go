@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 // 认证相关
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=64"`
@@ -19,33 +21,43 @@ type NewAPILoginRequest struct {
 
 // 套餐相关
 type CreatePlanRequest struct {
-	Name         string  `json:"name" binding:"required"`
-	Description  string  `json:"description"`
-	PeriodType   string  `json:"period_type" binding:"required,oneof=day week month custom"`
-	PeriodDays   int     `json:"period_days" binding:"required,min=1"`
-	DailyQuota   int     `json:"daily_quota" binding:"required,min=1"`
-	CarryOver    int     `json:"carry_over" binding:"oneof=0 1"`
-	MaxCarryOver int     `json:"max_carry_over" binding:"min=0"`
-	PriceType    string  `json:"price_type" binding:"required,oneof=fixed daily"`
-	Price        float64 `json:"price" binding:"required,min=0"`
-	NewAPIGroup  string  `json:"newapi_group" binding:"required"`
-	Status       int     `json:"status" binding:"oneof=0 1"`
-	SortOrder    int     `json:"sort_order"`
+	Name          string  `json:"name" binding:"required"`
+	Description   string  `json:"description"`
+	PeriodType    string  `json:"period_type" binding:"required,oneof=day week month custom"`
+	PeriodDays    int     `json:"period_days" binding:"required,min=1"`
+	QuotaWindow   string  `json:"quota_window" binding:"omitempty,oneof=hour day week month"` // 额度周期，默认 day
+	DailyQuota    int     `json:"daily_quota" binding:"required,min=1"`                       // 每个额度周期发放的额度
+	CarryOver     int     `json:"carry_over" binding:"oneof=0 1"`
+	MaxCarryOver  int     `json:"max_carry_over" binding:"min=0"`
+	PriceType     string  `json:"price_type" binding:"required,oneof=fixed daily"`
+	Price         float64 `json:"price" binding:"min=0"` // 正式套餐必须大于 0，试用套餐固定为 0
+	NewAPIGroup   string  `json:"newapi_group" binding:"required"`
+	GroupPriority int     `json:"group_priority"`              // 多订阅时分组优先级，越大越优先
+	GraceDays     int     `json:"grace_days" binding:"min=-1"` // 0=使用全局设置, -1=不设宽限期
+	MaxPauseDays  int     `json:"max_pause_days" binding:"min=0"`
+	IsTrial       int     `json:"is_trial" binding:"oneof=0 1"`
+	Status        int     `json:"status" binding:"oneof=0 1"`
+	SortOrder     int     `json:"sort_order"`
 }
 
 type UpdatePlanRequest struct {
-	Name         string  `json:"name"`
-	Description  string  `json:"description"`
-	PeriodType   string  `json:"period_type" binding:"omitempty,oneof=day week month custom"`
-	PeriodDays   int     `json:"period_days" binding:"omitempty,min=1"`
-	DailyQuota   int     `json:"daily_quota" binding:"omitempty,min=1"`
-	CarryOver    int     `json:"carry_over" binding:"omitempty,oneof=0 1"`
-	MaxCarryOver int     `json:"max_carry_over" binding:"omitempty,min=0"`
-	PriceType    string  `json:"price_type" binding:"omitempty,oneof=fixed daily"`
-	Price        float64 `json:"price" binding:"omitempty,min=0"`
-	NewAPIGroup  string  `json:"newapi_group"`
-	Status       int     `json:"status" binding:"omitempty,oneof=0 1"`
-	SortOrder    int     `json:"sort_order"`
+	Name          string  `json:"name"`
+	Description   string  `json:"description"`
+	PeriodType    string  `json:"period_type" binding:"omitempty,oneof=day week month custom"`
+	PeriodDays    int     `json:"period_days" binding:"omitempty,min=1"`
+	QuotaWindow   string  `json:"quota_window" binding:"omitempty,oneof=hour day week month"`
+	DailyQuota    int     `json:"daily_quota" binding:"omitempty,min=1"`
+	CarryOver     int     `json:"carry_over" binding:"omitempty,oneof=0 1"`
+	MaxCarryOver  int     `json:"max_carry_over" binding:"omitempty,min=0"`
+	PriceType     string  `json:"price_type" binding:"omitempty,oneof=fixed daily"`
+	Price         float64 `json:"price" binding:"omitempty,min=0"`
+	NewAPIGroup   string  `json:"newapi_group"`
+	GroupPriority int     `json:"group_priority"`
+	GraceDays     int     `json:"grace_days" binding:"min=-1"`
+	MaxPauseDays  int     `json:"max_pause_days" binding:"min=0"`
+	IsTrial       int     `json:"is_trial" binding:"omitempty,oneof=0 1"`
+	Status        int     `json:"status" binding:"omitempty,oneof=0 1"`
+	SortOrder     int     `json:"sort_order"`
 }
 
 // 订阅相关
@@ -56,16 +68,136 @@ type PurchaseRequest struct {
 	// bind_existing 时需要
 	NewAPIUsername string `json:"newapi_username"`
 	NewAPIPassword string `json:"newapi_password"`
+	CouponCode     string `json:"coupon_code"` // 优惠码（可选）
+}
+
+type ClaimTrialRequest struct {
+	PlanID uint `json:"plan_id" binding:"required"`
+}
+
+type AutoRenewRequest struct {
+	SubscriptionID uint `json:"subscription_id"` // 只有一个订阅时可省略
+	AutoRenew      int  `json:"auto_renew" binding:"oneof=0 1"`
+}
+
+type SubscriptionActionRequest struct {
+	SubscriptionID uint `json:"subscription_id"` // 只有一个订阅时可省略
 }
 
 type RenewRequest struct {
-	PeriodDays int `json:"period_days" binding:"required,min=1"`
+	SubscriptionID uint   `json:"subscription_id"` // 要续费的订阅（只有一个订阅时可省略）
+	PeriodDays     int    `json:"period_days" binding:"required,min=1"`
+	CouponCode     string `json:"coupon_code"` // 优惠码（可选）
+}
+
+type ChangePlanRequest struct {
+	SubscriptionID uint   `json:"subscription_id"` // 要变更的订阅（只有一个订阅时可省略）
+	PlanID         uint   `json:"plan_id" binding:"required"`
+	PeriodDays     int    `json:"period_days"` // 自定义天数（可选）
+	CouponCode     string `json:"coupon_code"` // 优惠码（可选）
+}
+
+type ChangePlanQuery struct {
+	SubscriptionID uint `form:"subscription_id"`
+	PlanID         uint `form:"plan_id" binding:"required"`
+	PeriodDays     int  `form:"period_days"`
+}
+
+// 优惠码相关
+type ValidateCouponRequest struct {
+	Code       string `json:"code" binding:"required"`
+	PlanID     uint   `json:"plan_id" binding:"required"`
+	PeriodDays int    `json:"period_days"`
+}
+
+type CreateCouponRequest struct {
+	Code          string     `json:"code" binding:"required,max=64"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	DiscountType  string     `json:"discount_type" binding:"required,oneof=percent fixed"`
+	DiscountValue float64    `json:"discount_value" binding:"required,gt=0"`
+	PlanIDs       []uint     `json:"plan_ids"`
+	MinPeriodDays int        `json:"min_period_days" binding:"min=0"`
+	MaxPeriodDays int        `json:"max_period_days" binding:"min=0"`
+	TotalLimit    int        `json:"total_limit" binding:"min=0"`
+	PerUserLimit  int        `json:"per_user_limit" binding:"min=0"`
+	StartAt       *time.Time `json:"start_at"`
+	EndAt         *time.Time `json:"end_at"`
+	Status        int        `json:"status" binding:"oneof=0 1"`
+}
+
+type UpdateCouponRequest struct {
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	DiscountType  string     `json:"discount_type" binding:"omitempty,oneof=percent fixed"`
+	DiscountValue float64    `json:"discount_value" binding:"omitempty,gt=0"`
+	PlanIDs       []uint     `json:"plan_ids"`
+	MinPeriodDays int        `json:"min_period_days" binding:"min=0"`
+	MaxPeriodDays int        `json:"max_period_days" binding:"min=0"`
+	TotalLimit    int        `json:"total_limit" binding:"min=0"`
+	PerUserLimit  int        `json:"per_user_limit" binding:"min=0"`
+	StartAt       *time.Time `json:"start_at"`
+	EndAt         *time.Time `json:"end_at"`
+	Status        int        `json:"status" binding:"oneof=0 1"`
+}
+
+// 兑换码相关
+type RedeemRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type GenerateRedeemCodesRequest struct {
+	PlanID    uint       `json:"plan_id" binding:"required"`
+	Days      int        `json:"days" binding:"required,min=1"`
+	Count     int        `json:"count" binding:"required,min=1,max=1000"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Remark    string     `json:"remark" binding:"max=255"`
 }
 
 // 支付相关
 type PayRequest struct {
 	OrderID       uint   `json:"order_id" binding:"required"`
-	PaymentMethod string `json:"payment_method" binding:"required,oneof=alipay wxpay"`
+	Provider      string `json:"provider"` // 支付网关（可选，默认按支付方式选择）
+	PaymentMethod string `json:"payment_method" binding:"required"`
+}
+
+type TopupRequest struct {
+	Amount float64 `json:"amount" binding:"required,gt=0"`
+}
+
+// 加油包相关
+type PurchaseBoosterRequest struct {
+	BoosterID      uint `json:"booster_id" binding:"required"`
+	SubscriptionID uint `json:"subscription_id"` // 加额度的订阅（可选，默认为主订阅）
+}
+
+type CreateBoosterRequest struct {
+	Name         string  `json:"name" binding:"required"`
+	Description  string  `json:"description"`
+	Quota        int     `json:"quota" binding:"required,min=1"`
+	CarryOver    int     `json:"carry_over" binding:"oneof=0 1"`
+	MaxCarryOver int     `json:"max_carry_over" binding:"min=0"`
+	Price        float64 `json:"price" binding:"required,gt=0"`
+	Status       int     `json:"status" binding:"oneof=0 1"`
+	SortOrder    int     `json:"sort_order"`
+}
+
+type UpdateBoosterRequest struct {
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Quota        int     `json:"quota" binding:"omitempty,min=1"`
+	CarryOver    int     `json:"carry_over" binding:"oneof=0 1"`
+	MaxCarryOver int     `json:"max_carry_over" binding:"min=0"`
+	Price        float64 `json:"price" binding:"omitempty,gt=0"`
+	Status       int     `json:"status" binding:"oneof=0 1"`
+	SortOrder    int     `json:"sort_order"`
+}
+
+type RefundOrderRequest struct {
+	Amount float64 `json:"amount" binding:"min=0"` // 0 表示按剩余天数自动折算
+	Reason string  `json:"reason" binding:"max=255"`
+	// 线下已退款时仅回退订阅，不调用支付网关
+	Offline bool `json:"offline"`
 }
 
 // 用户相关
@@ -83,6 +215,10 @@ type UpdateEmailSettingsRequest struct {
 	RemindDays  int `json:"remind_days" binding:"min=1,max=30"`
 }
 
+type UpdateTimezoneRequest struct {
+	Timezone string `json:"timezone" binding:"max=64"` // IANA 时区名称，留空使用站点默认时区
+}
+
 // 通用响应
 type Response struct {
 	Success bool        `json:"success"`
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Booster 加油包模型，一次性为当前订阅增加 new-api 额度
+type Booster struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"size:128;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+
+	// 额度设置
+	Quota        int `gorm:"not null" json:"quota"`
+	CarryOver    int `gorm:"default:0" json:"carry_over"`     // 0=次日同步时失效, 1=剩余额度跨日保留
+	MaxCarryOver int `gorm:"default:0" json:"max_carry_over"` // 最多保留额度 (0=无限制)
+
+	// 价格设置
+	Price float64 `gorm:"type:decimal(10,2);not null" json:"price"`
+
+	// 状态
+	Status    int `gorm:"default:1" json:"status"` // 1=上架, 0=下架
+	SortOrder int `gorm:"default:0" json:"sort_order"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+const (
+	OrderTypeBooster = "booster" // 加油包订单
+
+	BoosterStatusOn  = 1
+	BoosterStatusOff = 0
+)
+
+// CarryQuota 加油包额度中可跨日保留的部分
+func (b *Booster) CarryQuota() int {
+	if b.CarryOver != 1 {
+		return 0
+	}
+	if b.MaxCarryOver > 0 && b.MaxCarryOver < b.Quota {
+		return b.MaxCarryOver
+	}
+	return b.Quota
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Coupon 优惠码模型
+type Coupon struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Code        string `gorm:"uniqueIndex;size:64;not null" json:"code"`
+	Name        string `gorm:"size:128" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
+
+	// 优惠设置
+	DiscountType  string  `gorm:"size:16;not null" json:"discount_type"`             // percent=百分比, fixed=固定金额
+	DiscountValue float64 `gorm:"type:decimal(10,2);not null" json:"discount_value"` // percent 时为折扣百分比 (0-100)
+
+	// 使用限制
+	PlanIDs       string `gorm:"size:255" json:"plan_ids"`         // 适用套餐 ID，逗号分隔（空=全部）
+	MinPeriodDays int    `gorm:"default:0" json:"min_period_days"` // 最少购买天数 (0=不限)
+	MaxPeriodDays int    `gorm:"default:0" json:"max_period_days"` // 最多购买天数 (0=不限)
+	TotalLimit    int    `gorm:"default:0" json:"total_limit"`     // 总使用次数 (0=不限)
+	PerUserLimit  int    `gorm:"default:0" json:"per_user_limit"`  // 每用户使用次数 (0=不限)
+	UsedCount     int    `gorm:"default:0" json:"used_count"`      // 已占用次数（待支付和已支付订单），下单时占用，取消或退款时释放
+
+	// 有效期
+	StartAt *time.Time `json:"start_at"`
+	EndAt   *time.Time `json:"end_at"`
+
+	// 状态
+	Status int `gorm:"default:1" json:"status"` // 1=启用, 0=停用
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+const (
+	CouponTypePercent = "percent"
+	CouponTypeFixed   = "fixed"
+
+	CouponStatusOn  = 1
+	CouponStatusOff = 0
+)
+
+// AppliesToPlan 是否适用于指定套餐
+func (c *Coupon) AppliesToPlan(planID uint) bool {
+	if strings.TrimSpace(c.PlanIDs) == "" {
+		return true
+	}
+	for _, id := range strings.Split(c.PlanIDs, ",") {
+		if v, err := strconv.ParseUint(strings.TrimSpace(id), 10, 32); err == nil && uint(v) == planID {
+			return true
+		}
+	}
+	return false
+}
+
+// InValidWindow 当前时间是否在有效期内
+func (c *Coupon) InValidWindow(now time.Time) bool {
+	if c.StartAt != nil && now.Before(*c.StartAt) {
+		return false
+	}
+	if c.EndAt != nil && now.After(*c.EndAt) {
+		return false
+	}
+	return true
+}
+
+// CalculateDiscount 计算优惠金额（不超过原价）
+func (c *Coupon) CalculateDiscount(amount float64) float64 {
+	var discount float64
+	if c.DiscountType == CouponTypePercent {
+		discount = amount * c.DiscountValue / 100
+	} else {
+		discount = c.DiscountValue
+	}
+	if discount > amount {
+		discount = amount
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return math.Round(discount*100) / 100
+}
+
+// JoinPlanIDs 将套餐 ID 列表转换为存储格式
+func JoinPlanIDs(ids []uint) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(parts, ",")
+}
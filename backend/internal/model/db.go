@@ -20,26 +20,50 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
+	// 额度同步会并发写入，等待锁释放而不是直接返回 database is locked；
+	// 事务开始时即获取写锁，避免两个事务同时从读锁升级为写锁
+	dsn := dbPath + "?_busy_timeout=5000&_txlock=immediate"
+
 	var err error
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	DB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 	if err != nil {
 		return err
 	}
 
+	// 优惠码使用次数改为计数字段前的数据库，迁移后按现有订单回填
+	backfillCouponUsage := !DB.Migrator().HasColumn(&Coupon{}, "used_count")
+
 	// 自动迁移
 	if err := DB.AutoMigrate(
 		&User{},
 		&Plan{},
 		&Subscription{},
 		&Order{},
+		&OrderTask{},
 		&Setting{},
 		&UsageLog{},
+		&Coupon{},
+		&RedeemCode{},
+		&TrialClaim{},
+		&WalletTransaction{},
+		&Booster{},
+		&SyncRun{},
+		&SyncResult{},
+		&SyncLock{},
+		&UsageLogCursor{},
 	); err != nil {
 		return err
 	}
 
+	if backfillCouponUsage {
+		if err := DB.Exec("UPDATE coupons SET used_count = (SELECT COUNT(*) FROM orders WHERE orders.coupon_id = coupons.id AND orders.status IN ? AND orders.deleted_at IS NULL)",
+			[]string{OrderStatusPending, OrderStatusPaid}).Error; err != nil {
+			return err
+		}
+	}
+
 	// 初始化默认设置
 	initDefaultSettings()
 
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,17 +15,44 @@ type Order struct {
 	PlanID  uint   `gorm:"not null" json:"plan_id"`
 
 	// 订单信息
-	OrderType  string  `gorm:"size:16;not null" json:"order_type"` // new=新购, renew=续费
+	OrderType  string  `gorm:"size:16;not null" json:"order_type"` // new=新购, renew=续费, upgrade=升级, downgrade=降级, topup=钱包充值, booster=加油包
 	PeriodDays int     `gorm:"not null" json:"period_days"`
 	Amount     float64 `gorm:"type:decimal(10,2);not null" json:"amount"`
 
+	// 优惠信息
+	OriginalAmount float64 `gorm:"type:decimal(10,2);default:0" json:"original_amount"` // 优惠前金额
+	DiscountAmount float64 `gorm:"type:decimal(10,2);default:0" json:"discount_amount"`
+	CouponID       uint    `gorm:"index" json:"coupon_id"`
+	CouponCode     string  `gorm:"size:64" json:"coupon_code"`
+	CreditAmount   float64 `gorm:"type:decimal(10,2);default:0" json:"credit_amount"` // 变更套餐时原订阅剩余价值抵扣
+	WalletCredit   float64 `gorm:"type:decimal(10,2);default:0" json:"wallet_credit"` // 剩余价值超出应付金额的部分，变更完成后退回钱包
+
+	// 关联订阅（支付完成后创建或延长的订阅；变更套餐时为被变更的订阅；加油包订单为加额度的订阅）
+	SubscriptionID uint `gorm:"index" json:"subscription_id"`
+
+	// 加油包信息（下单时的快照）
+	BoosterID         uint `gorm:"index" json:"booster_id"`
+	BoosterQuota      int  `gorm:"default:0" json:"booster_quota"`
+	BoosterCarryQuota int  `gorm:"default:0" json:"booster_carry_quota"` // 其中可跨日保留的额度
+
 	// 支付信息
-	PaymentMethod string `gorm:"size:32" json:"payment_method"` // alipay/wxpay
-	TradeNo       string `gorm:"size:128" json:"trade_no"`
+	PaymentProvider string `gorm:"size:32" json:"payment_provider"` // 支付网关，如 epay
+	PaymentMethod   string `gorm:"size:32" json:"payment_method"`   // alipay/wxpay
+	TradeNo       string `gorm:"size:128;index" json:"trade_no"`
 
 	// 状态
-	Status string     `gorm:"size:16;not null" json:"status"` // pending/paid/cancelled/refunded
-	PaidAt *time.Time `json:"paid_at"`
+	Status    string     `gorm:"size:16;not null" json:"status"` // pending/paid/cancelled/refunded
+	PaidAt    *time.Time `json:"paid_at"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at"` // 支付截止时间
+
+	// 人工审核（如过期后才收到支付回调）
+	NeedsReview  int    `gorm:"default:0" json:"needs_review"`
+	ReviewReason string `gorm:"size:255" json:"review_reason"`
+
+	// 退款信息
+	RefundAmount float64    `gorm:"type:decimal(10,2);default:0" json:"refund_amount"`
+	RefundReason string     `gorm:"size:255" json:"refund_reason"`
+	RefundedAt   *time.Time `json:"refunded_at"`
 
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -39,8 +67,34 @@ const (
 	OrderTypeNew   = "new"
 	OrderTypeRenew = "renew"
 
+	OrderTypeUpgrade   = "upgrade"
+	OrderTypeDowngrade = "downgrade"
+
+	PaymentMethodCoupon = "coupon" // 优惠后金额为 0，无需支付
+	PaymentMethodCredit = "credit" // 剩余价值抵扣后金额为 0，无需支付
+
 	OrderStatusPending   = "pending"
 	OrderStatusPaid      = "paid"
 	OrderStatusCancelled = "cancelled"
 	OrderStatusRefunded  = "refunded"
 )
+
+// NewOrderExpiresAt 按系统设置的支付时限计算订单过期时间
+func NewOrderExpiresAt() *time.Time {
+	minutes, err := strconv.Atoi(GetSetting(SettingOrderPaymentWindow))
+	if err != nil || minutes <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(time.Duration(minutes) * time.Minute)
+	return &expiresAt
+}
+
+// IsExpired 订单是否已超过支付时限
+func (o *Order) IsExpired() bool {
+	return o.ExpiresAt != nil && time.Now().After(*o.ExpiresAt)
+}
+
+// IsPlanChange 是否为变更套餐订单
+func (o *Order) IsPlanChange() bool {
+	return o.OrderType == OrderTypeUpgrade || o.OrderType == OrderTypeDowngrade
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+)
+
+// OrderTask 订单履约步骤（new-api 侧操作），失败后可重试
+type OrderTask struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	OrderID uint   `gorm:"not null;uniqueIndex:idx_order_task_step" json:"order_id"`
+	Step    string `gorm:"size:32;not null;uniqueIndex:idx_order_task_step" json:"step"`
+
+	// 执行状态
+	Status    string     `gorm:"size:16;not null;index" json:"status"` // pending/done/failed
+	Attempts  int        `gorm:"default:0" json:"attempts"`
+	LastError string     `gorm:"type:text" json:"last_error"`
+	DoneAt    *time.Time `json:"done_at"`
+
+	// 已向 new-api 发出增加额度的写入，重试时跳过，避免重复发放
+	Applied int `gorm:"default:0" json:"applied"`
+
+	// 变更套餐时原套餐本周期已发放的额度，发放新套餐额度前先从余额扣除
+	ReplacedQuota int `gorm:"default:0" json:"replaced_quota"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// 关联
+	Order *Order `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+}
+
+const (
+	OrderTaskStepCreateUser = "create_newapi_user" // 创建 new-api 账号
+	OrderTaskStepApplyQuota = "apply_newapi_quota" // 设置 new-api 额度和分组
+	OrderTaskStepAddBooster = "add_booster_quota"  // 增加加油包额度
+
+	OrderTaskStatusPending = "pending"
+	OrderTaskStatusDone    = "done"
+	OrderTaskStatusFailed  = "failed" // 超过最大重试次数，需人工处理
+
+	OrderTaskMaxAttempts = 10
+)
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,25 +9,37 @@ import (
 
 // Plan 订阅套餐模型
 type Plan struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"size:128;not null" json:"name"`
-	Description string         `gorm:"type:text" json:"description"`
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Name        string `gorm:"size:128;not null" json:"name"`
+	Description string `gorm:"type:text" json:"description"`
 
 	// 周期设置
 	PeriodType string `gorm:"size:16;not null" json:"period_type"` // day/week/month/custom
 	PeriodDays int    `gorm:"not null" json:"period_days"`
 
-	// 额度设置
-	DailyQuota   int `gorm:"not null" json:"daily_quota"`
-	CarryOver    int `gorm:"default:0" json:"carry_over"`     // 0=不结转, 1=结转
-	MaxCarryOver int `gorm:"default:0" json:"max_carry_over"` // 最大结转额度 (0=无限制)
+	// 额度设置（每个额度周期发放 DailyQuota，周期结束时按结转规则处理剩余额度）
+	QuotaWindow  string `gorm:"size:16;default:day" json:"quota_window"` // hour/day/week/month
+	DailyQuota   int    `gorm:"not null" json:"daily_quota"`
+	CarryOver    int    `gorm:"default:0" json:"carry_over"`     // 0=不结转, 1=结转
+	MaxCarryOver int    `gorm:"default:0" json:"max_carry_over"` // 最大结转额度 (0=无限制)
 
 	// 价格设置
 	PriceType string  `gorm:"size:16;not null" json:"price_type"` // fixed=固定价格, daily=按天计价
 	Price     float64 `gorm:"type:decimal(10,2);not null" json:"price"`
 
+	// 宽限期设置
+	GraceDays int `gorm:"default:0" json:"grace_days"` // 到期后宽限天数（0=使用全局设置, -1=不设宽限期）
+
+	// 暂停设置
+	MaxPauseDays int `gorm:"default:0" json:"max_pause_days"` // 每个计费周期最多暂停天数（0=不允许暂停）
+
+	// 试用设置（试用套餐价格为 0，每个用户仅可领取一次）
+	IsTrial int `gorm:"default:0" json:"is_trial"` // 0=正式套餐, 1=试用套餐
+
 	// new-api 分组绑定
 	NewAPIGroup string `gorm:"column:newapi_group;size:64;not null" json:"newapi_group"`
+	// 同时持有多个订阅时，使用分组优先级最高的套餐的分组
+	GroupPriority int `gorm:"default:0" json:"group_priority"`
 
 	// 状态
 	Status    int `gorm:"default:1" json:"status"` // 1=上架, 0=下架
@@ -46,6 +59,11 @@ const (
 	PriceTypeFixed = "fixed"
 	PriceTypeDaily = "daily"
 
+	QuotaWindowHour  = "hour"
+	QuotaWindowDay   = "day"
+	QuotaWindowWeek  = "week"
+	QuotaWindowMonth = "month"
+
 	PlanStatusOn  = 1
 	PlanStatusOff = 0
 )
@@ -58,3 +76,45 @@ func (p *Plan) CalculatePrice(days int) float64 {
 	// 按天计价
 	return p.Price * float64(days)
 }
+
+// DailyPrice 日均标价
+func (p *Plan) DailyPrice() float64 {
+	if p.PriceType != PriceTypeFixed {
+		return p.Price
+	}
+	if p.PeriodDays <= 0 {
+		return p.Price
+	}
+	return p.Price / float64(p.PeriodDays)
+}
+
+// GracePeriodDays 到期后的宽限天数，未单独设置时使用全局设置
+func (p *Plan) GracePeriodDays() int {
+	if p.GraceDays < 0 {
+		return 0
+	}
+	if p.GraceDays > 0 {
+		return p.GraceDays
+	}
+	days, err := strconv.Atoi(GetSetting(SettingGracePeriodDays))
+	if err != nil || days < 0 {
+		return 0
+	}
+	return days
+}
+
+// QuotaWindowStart 额度周期在 t 所处周期的开始时间，周从周一开始；未知的周期按天处理
+func QuotaWindowStart(window string, t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch window {
+	case QuotaWindowHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case QuotaWindowWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	case QuotaWindowMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
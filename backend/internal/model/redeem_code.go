@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+)
+
+// RedeemCode 兑换码模型（预付费礼品卡）
+type RedeemCode struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Code    string `gorm:"uniqueIndex;size:64;not null" json:"code"`
+	BatchNo string `gorm:"size:64;not null;index" json:"batch_no"`
+
+	// 兑换内容
+	PlanID uint `gorm:"not null" json:"plan_id"`
+	Days   int  `gorm:"not null" json:"days"`
+
+	// 状态
+	Status    string     `gorm:"size:16;not null;index" json:"status"` // unused/used/revoked
+	ExpiresAt *time.Time `json:"expires_at"`
+	Remark    string     `gorm:"size:255" json:"remark"`
+
+	// 使用信息
+	UsedBy  uint       `gorm:"default:0" json:"used_by"`
+	UsedAt  *time.Time `json:"used_at"`
+	OrderID uint       `gorm:"default:0" json:"order_id"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// 关联
+	Plan *Plan `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
+}
+
+const (
+	RedeemCodeStatusUnused  = "unused"
+	RedeemCodeStatusUsed    = "used"
+	RedeemCodeStatusRevoked = "revoked"
+
+	PaymentMethodRedeem = "redeem"
+)
+
+// IsExpired 是否已过期
+func (r *RedeemCode) IsExpired() bool {
+	return r.ExpiresAt != nil && time.Now().After(*r.ExpiresAt)
+}
@@ -18,6 +18,11 @@ const (
 	SettingRequireLogin      = "require_login"
 	SettingAllowRegister     = "allow_register"
 	SettingNewAPILoginEnabled = "newapi_login_enabled"
+	SettingDefaultGroup       = "default_newapi_group"
+	SettingOrderPaymentWindow = "order_payment_window" // 订单支付时限（分钟，0=不限制；使用优惠码的订单仍为 24 小时）
+	SettingGracePeriodDays    = "grace_period_days"    // 订阅到期后的宽限天数（0=不设宽限期）
+	SettingGraceQuotaPercent  = "grace_quota_percent"  // 宽限期每日发放额度占原每日额度的百分比（0=冻结额度）
+	SettingDefaultTimezone    = "default_timezone"     // 站点默认时区（IANA 名称，留空使用服务器时区）
 )
 
 // DefaultSettings 默认设置
@@ -27,4 +32,9 @@ var DefaultSettings = map[string]string{
 	SettingRequireLogin:      "0",
 	SettingAllowRegister:     "1",
 	SettingNewAPILoginEnabled: "1",
+	SettingDefaultGroup:       "default",
+	SettingOrderPaymentWindow: "30",
+	SettingGracePeriodDays:    "0",
+	SettingGraceQuotaPercent:  "0",
+	SettingDefaultTimezone:    "",
 }
@@ -13,23 +13,40 @@ type Subscription struct {
 	PlanID uint `gorm:"not null" json:"plan_id"`
 
 	// 订阅状态
-	Status string `gorm:"size:16;not null" json:"status"` // active/expired/cancelled
+	Status string `gorm:"size:16;not null" json:"status"` // active/grace/paused/expired/cancelled
 
 	// 时间信息
 	StartDate time.Time `gorm:"type:date;not null" json:"start_date"`
 	EndDate   time.Time `gorm:"type:date;not null" json:"end_date"`
 
-	// 当日额度信息
-	TodayQuota    int        `gorm:"not null" json:"today_quota"`
-	CarriedQuota  int        `gorm:"default:0" json:"carried_quota"`
-	LastSyncDate  *time.Time `gorm:"type:date" json:"last_sync_date"`
+	// 宽限期截止日期（到期后进入宽限期时设置）
+	GraceEndDate *time.Time `gorm:"type:date" json:"grace_end_date"`
+
+	// 暂停信息
+	PausedAt    *time.Time `gorm:"type:date" json:"paused_at"`    // 本次暂停开始日期
+	PausedDays  int        `gorm:"default:0" json:"paused_days"`  // 本计费周期已暂停天数
+	PausedQuota int        `gorm:"default:0" json:"paused_quota"` // 暂停时扣除的 new-api 额度，恢复时原样退回
+
+	// 当期额度信息
+	TodayQuota   int        `gorm:"not null" json:"today_quota"` // 本额度周期发放的额度（含结转）
+	CarriedQuota int        `gorm:"default:0" json:"carried_quota"`
+	LastSyncDate *time.Time `gorm:"type:date" json:"last_sync_date"`
+	WindowStart  *time.Time `json:"window_start"` // 本额度周期开始时间
+
+	// 加油包额度（单独记录，不计入结转额度）
+	BoosterQuota      int `gorm:"default:0" json:"booster_quota"`       // 每日同步时失效的加油包额度
+	BoosterCarryQuota int `gorm:"default:0" json:"booster_carry_quota"` // 可跨日保留的加油包额度
 
 	// 配置快照（购买时的套餐配置）
+	QuotaWindow  string `gorm:"size:16;default:day" json:"quota_window"`
 	DailyQuota   int    `gorm:"not null" json:"daily_quota"`
 	CarryOver    int    `gorm:"not null" json:"carry_over"`
 	MaxCarryOver int    `gorm:"default:0" json:"max_carry_over"`
 	NewAPIGroup  string `gorm:"column:newapi_group;size:64;not null" json:"newapi_group"`
 
+	// 到期自动续费（从钱包余额扣款）
+	AutoRenew int `gorm:"default:0" json:"auto_renew"` // 0=关闭, 1=开启
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -41,23 +58,45 @@ type Subscription struct {
 
 const (
 	SubscriptionStatusActive    = "active"
+	SubscriptionStatusGrace     = "grace"  // 已到期，处于宽限期
+	SubscriptionStatusPaused    = "paused" // 用户主动暂停
 	SubscriptionStatusExpired   = "expired"
 	SubscriptionStatusCancelled = "cancelled"
 )
 
+// SubscriptionCurrentStatuses 未终止的订阅状态（含宽限期、暂停中）
+var SubscriptionCurrentStatuses = []string{SubscriptionStatusActive, SubscriptionStatusGrace, SubscriptionStatusPaused}
+
+// SubscriptionSyncStatuses 需要每日同步额度的订阅状态
+var SubscriptionSyncStatuses = []string{SubscriptionStatusActive, SubscriptionStatusGrace}
+
 // IsActive 是否有效
 func (s *Subscription) IsActive() bool {
 	return s.Status == SubscriptionStatusActive && time.Now().Before(s.EndDate.AddDate(0, 0, 1))
 }
 
-// DaysRemaining 剩余天数
-func (s *Subscription) DaysRemaining() int {
-	if !s.IsActive() {
+// DaysRemaining 剩余天数（含今天），today 为用户时区的当天日期（见 LocalDate）
+func (s *Subscription) DaysRemaining(today time.Time) int {
+	if s.Status != SubscriptionStatusActive || s.EndDate.Before(today) {
 		return 0
 	}
-	days := int(time.Until(s.EndDate).Hours()/24) + 1
-	if days < 0 {
-		return 0
+	return int(s.EndDate.Sub(today).Hours()/24) + 1
+}
+
+// IsCurrent 是否为未终止的订阅
+func (s *Subscription) IsCurrent() bool {
+	for _, status := range SubscriptionCurrentStatuses {
+		if s.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// WindowDue 订阅的额度周期在 now 时是否已进入新周期，需要重新发放额度
+func (s *Subscription) WindowDue(now time.Time) bool {
+	if s.WindowStart == nil {
+		return true
 	}
-	return days
+	return QuotaWindowStart(s.QuotaWindow, now).After(*s.WindowStart)
 }
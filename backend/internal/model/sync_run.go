@@ -0,0 +1,76 @@
+package model
+
+import "time"
+
+// SyncRun 额度同步执行记录
+type SyncRun struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	Source string `gorm:"size:16;not null;index" json:"source"` // 触发来源: schedule/periodic/manual/retry
+	Status string `gorm:"size:16;not null;index" json:"status"` // running/success/partial/failed
+
+	// 重跑失败订阅时为原同步记录 ID
+	RetryOfID uint `gorm:"index" json:"retry_of_id"`
+
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	// 统计（按订阅计数）
+	Total     int `gorm:"default:0" json:"total"`
+	Succeeded int `gorm:"default:0" json:"succeeded"`
+	Failed    int `gorm:"default:0" json:"failed"`
+	Skipped   int `gorm:"default:0" json:"skipped"` // 未到期、未进入新额度周期，无需更新
+
+	Error string `gorm:"type:text" json:"error"` // 整体失败原因，如管理员登录失败
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SyncResult 单个订阅在一次同步中的结果
+type SyncResult struct {
+	ID             uint `gorm:"primaryKey" json:"id"`
+	RunID          uint `gorm:"not null;index" json:"run_id"`
+	SubscriptionID uint `gorm:"not null;index" json:"subscription_id"`
+	UserID         uint `gorm:"not null;index" json:"user_id"`
+
+	Status             string `gorm:"size:16;not null;index" json:"status"` // success/failed
+	SubscriptionStatus string `gorm:"size:16" json:"subscription_status"`   // 同步后的订阅状态
+
+	// 额度变化（QuotaBefore/QuotaAfter 为用户 new-api 余额，同一用户的多个订阅相同）
+	QuotaBefore int `gorm:"default:0" json:"quota_before"`
+	QuotaAfter  int `gorm:"default:0" json:"quota_after"`
+	Granted     int `gorm:"default:0" json:"granted"` // 该订阅本次发放的周期额度（未进入新周期为 0）
+	Carried     int `gorm:"default:0" json:"carried"` // 记在该订阅上的结转额度
+
+	Remark string `gorm:"size:255" json:"remark"`
+	Error  string `gorm:"type:text" json:"error"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SyncLock 额度同步锁，保存在数据库中以便多个进程（副本）之间互斥
+type SyncLock struct {
+	Name       string    `gorm:"primaryKey;size:32" json:"name"`
+	Owner      string    `gorm:"size:128;not null" json:"owner"` // 持有者: 主机名:进程号:获取时间
+	RunID      uint      `gorm:"default:0" json:"run_id"`        // 持有锁的同步记录 ID，周期同步尚未记录时为 0
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // 超时后锁视为已释放，防止进程崩溃后永久占用
+}
+
+// SyncLockName 额度同步锁名称
+const SyncLockName = "quota_sync"
+
+const (
+	SyncSourceSchedule = "schedule" // 每日定时同步
+	SyncSourcePeriodic = "periodic" // 额度周期同步，仅在有订阅变更时记录
+	SyncSourceManual   = "manual"
+	SyncSourceRetry    = "retry"
+
+	SyncRunStatusRunning = "running"
+	SyncRunStatusSuccess = "success"
+	SyncRunStatusPartial = "partial"
+	SyncRunStatusFailed  = "failed"
+
+	SyncResultStatusSuccess = "success"
+	SyncResultStatusFailed  = "failed"
+)
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// LoadTimezone 解析 IANA 时区名称（如 Asia/Shanghai），留空时返回 nil
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// SiteLocation 站点默认时区，未设置或无效时使用服务器时区
+func SiteLocation() *time.Location {
+	if loc, err := LoadTimezone(GetSetting(SettingDefaultTimezone)); err == nil && loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// Location 用户时区，未设置或无效时使用站点默认时区
+func (u *User) Location() *time.Location {
+	if loc, err := LoadTimezone(u.Timezone); err == nil && loc != nil {
+		return loc
+	}
+	return SiteLocation()
+}
+
+// UserLocation 按用户 ID 获取用户时区
+func UserLocation(userID uint) *time.Location {
+	var user User
+	if err := DB.Select("id", "timezone").First(&user, userID).Error; err != nil {
+		return SiteLocation()
+	}
+	return user.Location()
+}
+
+// LocalDate t 在 loc 时区的日期，以 UTC 零点表示，与按天存储的日期字段一致
+func LocalDate(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// TrialClaim 试用领取记录（每个本地用户、new-api 账号、邮箱仅可领取一次）
+type TrialClaim struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	UserID       uint   `gorm:"uniqueIndex;not null" json:"user_id"`
+	PlanID       uint   `gorm:"not null" json:"plan_id"`
+	OrderID      uint   `gorm:"not null" json:"order_id"`
+	NewAPIUserID int    `gorm:"column:newapi_user_id;index" json:"newapi_user_id"`
+	Email        string `gorm:"size:128;index" json:"email"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const PaymentMethodTrial = "trial"
@@ -4,18 +4,38 @@ import (
 	"time"
 )
 
-// UsageLog 使用日志模型（本地缓存）
+// UsageLog 使用日志模型（本地缓存），每个用户每天一条，由 new-api 消费日志汇总
 type UsageLog struct {
 	ID             uint      `gorm:"primaryKey" json:"id"`
-	SubscriptionID uint      `gorm:"not null;index" json:"subscription_id"`
-	LogDate        time.Time `gorm:"type:date;not null;index" json:"log_date"`
+	UserID         uint      `gorm:"not null;default:0;uniqueIndex:idx_usage_user_date" json:"user_id"`
+	SubscriptionID uint      `gorm:"not null;index" json:"subscription_id"`                                    // 首次记录时用户的主订阅
+	LogDate        time.Time `gorm:"type:date;not null;index;uniqueIndex:idx_usage_user_date" json:"log_date"` // 用户时区的日期
 
 	// 汇总数据
-	TotalQuota   int `gorm:"default:0" json:"total_quota"`
-	RequestCount int `gorm:"default:0" json:"request_count"`
+	TotalQuota       int `gorm:"default:0" json:"total_quota"`
+	RequestCount     int `gorm:"default:0" json:"request_count"`
+	PromptTokens     int `gorm:"default:0" json:"prompt_tokens"`
+	CompletionTokens int `gorm:"default:0" json:"completion_tokens"`
 
 	// 模型分布 (JSON)
-	ModelUsage string `gorm:"type:text" json:"model_usage"` // {"gpt-4": 1000, "gpt-3.5": 500}
+	ModelUsage string `gorm:"type:text" json:"model_usage"` // {"gpt-4": {"quota": 1000, "request_count": 2, "prompt_tokens": 300, "completion_tokens": 200}}
 
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ModelUsage 单个模型的用量
+type ModelUsage struct {
+	Quota            int `json:"quota"`
+	RequestCount     int `json:"request_count"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// UsageLogCursor 用户 new-api 日志的汇总进度
+type UsageLogCursor struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	LastLogID int       `gorm:"default:0" json:"last_log_id"` // 已汇总的最大日志 ID
+	LastLogAt int64     `gorm:"default:0" json:"last_log_at"` // 该日志的时间戳，下次从此时开始查询
+	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -21,10 +21,16 @@ type User struct {
 	NewAPIUsername string `gorm:"column:newapi_username;size:64" json:"newapi_username"`
 	NewAPIBound    int    `gorm:"column:newapi_bound;default:0" json:"newapi_bound"` // 0=未绑定, 1=已绑定
 
+	// 钱包余额（只通过钱包流水的条件更新变动；修改用户其他信息时按字段 Updates，不整行 Save）
+	Balance float64 `gorm:"type:decimal(10,2);default:0" json:"balance"`
+
 	// 邮件提醒设置
 	EmailRemind int `gorm:"default:1" json:"email_remind"` // 是否开启邮件提醒
 	RemindDays  int `gorm:"default:3" json:"remind_days"`  // 提前几天提醒
 
+	// 时区（IANA 名称，留空使用站点默认时区），额度重置、今日用量和到期提醒按该时区计算
+	Timezone string `gorm:"size:64" json:"timezone"`
+
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -46,6 +52,15 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
+// SaveUserNewAPIBinding 保存用户的 new-api 绑定信息
+func SaveUserNewAPIBinding(db *gorm.DB, user *User) error {
+	return db.Model(user).Updates(map[string]interface{}{
+		"newapi_user_id":  user.NewAPIUserID,
+		"newapi_username": user.NewAPIUsername,
+		"newapi_bound":    user.NewAPIBound,
+	}).Error
+}
+
 // IsAdmin 是否为管理员
 func (u *User) IsAdmin() bool {
 	return u.Role >= 10
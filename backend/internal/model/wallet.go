@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+)
+
+// WalletTransaction 钱包流水
+type WalletTransaction struct {
+	ID      uint    `gorm:"primaryKey" json:"id"`
+	UserID  uint    `gorm:"not null;index" json:"user_id"`
+	Type    string  `gorm:"size:16;not null" json:"type"`               // topup/debit/refund
+	Amount  float64 `gorm:"type:decimal(10,2);not null" json:"amount"`  // 正数为入账，负数为出账
+	Balance float64 `gorm:"type:decimal(10,2);not null" json:"balance"` // 变动后余额
+	OrderID uint    `gorm:"index" json:"order_id"`
+	Remark  string  `gorm:"size:255" json:"remark"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	WalletTxTopup  = "topup"
+	WalletTxDebit  = "debit"
+	WalletTxRefund = "refund"
+
+	OrderTypeTopup = "topup" // 钱包充值订单
+
+	PaymentMethodWallet = "wallet" // 钱包余额支付
+)
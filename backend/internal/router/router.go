@@ -39,22 +39,55 @@ func SetupRouter() *gin.Engine {
 		{
 			subscriptions.GET("/current", controller.GetCurrentSubscription)
 			subscriptions.POST("/purchase", controller.PurchaseSubscription)
+			subscriptions.POST("/trial", controller.ClaimTrial)
 			subscriptions.POST("/renew", controller.RenewSubscription)
+			subscriptions.PUT("/auto-renew", controller.SetAutoRenew)
+			subscriptions.POST("/pause", controller.PauseSubscription)
+			subscriptions.POST("/resume", controller.ResumeSubscription)
+			subscriptions.GET("/change-plan/preview", controller.PreviewPlanChange)
+			subscriptions.POST("/change-plan", controller.ChangePlan)
+			subscriptions.POST("/redeem", controller.RedeemCode)
 			subscriptions.GET("/usage", controller.GetUsageLogs)
 			subscriptions.GET("/usage/detail", controller.GetUsageDetail)
 			subscriptions.GET("/usage/today", controller.GetTodayUsage)
 		}
 
+		// 优惠码接口（需要登录）
+		coupons := api.Group("/coupons")
+		coupons.Use(middleware.AuthMiddleware())
+		{
+			coupons.POST("/validate", controller.ValidateCoupon)
+		}
+
 		// 订单接口
 		orders := api.Group("/orders")
 		{
-			orders.GET("/notify", controller.PaymentNotify) // 支付回调（公开）
+			orders.GET("/notify", controller.PaymentNotify)           // 支付回调（公开，兼容旧地址）
+			orders.Any("/notify/:provider", controller.PaymentNotify) // 各支付网关回调（公开）
 			orders.Use(middleware.AuthMiddleware())
 			orders.GET("", controller.GetOrders)
+			orders.GET("/payment-methods", controller.GetPaymentMethods)
 			orders.GET("/:id", controller.GetOrder)
+			orders.POST("/:id/check", controller.CheckOrderPayment)
 			orders.POST("/pay", controller.CreatePayment)
 		}
 
+		// 钱包接口（需要登录）
+		wallet := api.Group("/wallet")
+		wallet.Use(middleware.AuthMiddleware())
+		{
+			wallet.GET("", controller.GetWallet)
+			wallet.GET("/transactions", controller.GetWalletTransactions)
+			wallet.POST("/topup", controller.TopupWallet)
+		}
+
+		// 加油包接口
+		boosters := api.Group("/boosters")
+		{
+			boosters.GET("", controller.GetBoosters)
+			boosters.POST("/purchase", middleware.AuthMiddleware(), controller.PurchaseBooster)
+		}
+
 		// 用户接口（需要登录）
 		user := api.Group("/user")
 		user.Use(middleware.AuthMiddleware())
@@ -62,6 +95,7 @@ func SetupRouter() *gin.Engine {
 			user.PUT("/profile", controller.UpdateProfile)
 			user.POST("/bind-newapi", controller.BindNewAPI)
 			user.PUT("/email-settings", controller.UpdateEmailSettings)
+			user.PUT("/timezone", controller.UpdateTimezone)
 		}
 
 		// 管理接口（需要管理员权限）
@@ -81,18 +115,46 @@ func SetupRouter() *gin.Engine {
 			// 订单管理
 			admin.GET("/orders", controller.AdminGetOrders)
 			admin.POST("/orders/:id/complete", controller.AdminCompleteOrder)
+			admin.POST("/orders/:id/refund", controller.AdminRefundOrder)
+			admin.POST("/orders/:id/retry-tasks", controller.AdminRetryOrderTasks)
+			admin.GET("/order-tasks", controller.AdminGetOrderTasks)
 
 			// 套餐管理
 			admin.POST("/plans", controller.AdminCreatePlan)
 			admin.PUT("/plans/:id", controller.AdminUpdatePlan)
 			admin.DELETE("/plans/:id", controller.AdminDeletePlan)
 
+			// 加油包管理
+			admin.GET("/boosters", controller.AdminGetBoosters)
+			admin.POST("/boosters", controller.AdminCreateBooster)
+			admin.PUT("/boosters/:id", controller.AdminUpdateBooster)
+			admin.DELETE("/boosters/:id", controller.AdminDeleteBooster)
+
+			// 优惠码管理
+			admin.GET("/coupons", controller.AdminGetCoupons)
+			admin.POST("/coupons", controller.AdminCreateCoupon)
+			admin.PUT("/coupons/:id", controller.AdminUpdateCoupon)
+			admin.DELETE("/coupons/:id", controller.AdminDeleteCoupon)
+			admin.GET("/coupons/:id/usage", controller.AdminGetCouponUsage)
+
+			// 兑换码管理
+			admin.GET("/redeem-codes", controller.AdminGetRedeemCodes)
+			admin.POST("/redeem-codes", controller.AdminGenerateRedeemCodes)
+			admin.GET("/redeem-codes/export", controller.AdminExportRedeemCodes)
+			admin.POST("/redeem-codes/:id/revoke", controller.AdminRevokeRedeemCode)
+			admin.POST("/redeem-codes/batch/:batch_no/revoke", controller.AdminRevokeRedeemBatch)
+
 			// 系统设置
 			admin.GET("/settings", controller.AdminGetSettings)
 			admin.PUT("/settings", controller.AdminUpdateSettings)
 
 			// 同步操作
 			admin.POST("/sync/trigger", controller.AdminTriggerSync)
+			admin.GET("/sync/preview", controller.AdminPreviewSync)
+			admin.GET("/sync/runs", controller.AdminGetSyncRuns)
+			admin.GET("/sync/runs/:id", controller.AdminGetSyncRun)
+			admin.POST("/sync/runs/:id/retry", controller.AdminRetrySyncRun)
+			admin.GET("/sync/results", controller.AdminGetSyncResults)
 
 			// new-api 信息
 			admin.GET("/newapi/groups", controller.AdminGetNewAPIGroups)
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// applyOrderBooster 将加油包额度记到订单关联的订阅上
+// 下单后该订阅已失效时改记到用户的主订阅上
+func applyOrderBooster(tx *gorm.DB, order *model.Order) (*model.Subscription, error) {
+	var subscription model.Subscription
+	err := tx.Where("id = ? AND user_id = ? AND status IN ?",
+		order.SubscriptionID, order.UserID, model.SubscriptionSyncStatuses).
+		First(&subscription).Error
+	if err != nil {
+		var subs []model.Subscription
+		tx.Preload("Plan").
+			Where("user_id = ? AND status IN ?", order.UserID, model.SubscriptionSyncStatuses).
+			Find(&subs)
+		primary := PrimarySubscription(subs)
+		if primary == nil {
+			return nil, errors.New("没有可增加额度的有效订阅")
+		}
+		subscription = *primary
+	}
+
+	if err := tx.Model(&model.Subscription{}).Where("id = ?", subscription.ID).
+		Updates(map[string]interface{}{
+			"booster_quota":       gorm.Expr("booster_quota + ?", order.BoosterQuota-order.BoosterCarryQuota),
+			"booster_carry_quota": gorm.Expr("booster_carry_quota + ?", order.BoosterCarryQuota),
+		}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&model.Order{}).Where("id = ?", order.ID).
+		Update("subscription_id", subscription.ID).Error; err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// taskAddBoosterQuota 在 new-api 当前余额上增加加油包额度
+func taskAddBoosterQuota(client NewAPIBackend, order *model.Order, task *model.OrderTask) error {
+	var user model.User
+	if err := model.DB.First(&user, order.UserID).Error; err != nil {
+		return err
+	}
+	if user.NewAPIBound != 1 {
+		return errors.New("用户尚未绑定 new-api 账号")
+	}
+
+	lock, err := lockUserQuota(user.ID)
+	if err != nil {
+		return err
+	}
+	defer releaseSyncLock(lock)
+
+	// 上次已写入 new-api（只是步骤结果未保存）时不再重复增加
+	if claimed, err := claimOrderTaskWrite(task); err != nil || !claimed {
+		return err
+	}
+
+	newAPIUser, err := client.GetUser(user.NewAPIUserID)
+	if err != nil {
+		releaseOrderTaskWrite(task)
+		return err
+	}
+	newAPIUser.Quota += order.BoosterQuota
+	if err := client.UpdateUser(newAPIUser); err != nil {
+		releaseOrderTaskWrite(task)
+		return err
+	}
+
+	log.Printf("用户 %d new-api 额度已增加加油包额度 %d", user.ID, order.BoosterQuota)
+	return nil
+}
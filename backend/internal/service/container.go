@@ -0,0 +1,34 @@
+package service
+
+import (
+	"sync"
+)
+
+// Container 服务依赖的外部组件，默认使用真实实现，测试时可整体替换
+type Container struct {
+	// NewAPI 创建 new-api 后端；用户登录状态保存在实例上，每次调用应返回可独立使用的实例
+	NewAPI func() NewAPIBackend
+}
+
+var (
+	container = &Container{
+		NewAPI: func() NewAPIBackend { return NewNewAPIClient() },
+	}
+	containerMu sync.RWMutex
+)
+
+// SetContainer 替换服务依赖并返回原来的容器，便于测试结束后恢复
+func SetContainer(c *Container) *Container {
+	containerMu.Lock()
+	defer containerMu.Unlock()
+	old := container
+	container = c
+	return old
+}
+
+// NewAPI 创建 new-api 后端
+func NewAPI() NewAPIBackend {
+	containerMu.RLock()
+	defer containerMu.RUnlock()
+	return container.NewAPI()
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// couponUsageStatuses 计入优惠码使用次数的订单状态（待支付订单占用名额，过期取消后释放）
+var couponUsageStatuses = []string{model.OrderStatusPending, model.OrderStatusPaid}
+
+// couponOrderPaymentWindow 未设置订单支付时限时，使用优惠码的订单的支付时限；过期取消后释放占用的次数
+const couponOrderPaymentWindow = 24 * time.Hour
+
+var (
+	// ErrCouponUnavailable 下单时优惠码次数已被占满
+	ErrCouponUnavailable = errors.New("优惠码不可用")
+	// errCouponExhausted 优惠码总次数已用完
+	errCouponExhausted = errors.New("优惠码已被领完")
+)
+
+// ValidateCoupon 校验优惠码是否可用于本次购买，返回优惠码和优惠金额
+func ValidateCoupon(code string, userID uint, plan *model.Plan, periodDays int, amount float64) (*model.Coupon, float64, error) {
+	code = strings.TrimSpace(code)
+
+	var coupon model.Coupon
+	if err := model.DB.Where("code = ?", code).First(&coupon).Error; err != nil {
+		return nil, 0, errors.New("优惠码不存在")
+	}
+
+	if coupon.Status != model.CouponStatusOn {
+		return nil, 0, errors.New("优惠码已停用")
+	}
+
+	if !coupon.InValidWindow(time.Now()) {
+		return nil, 0, errors.New("优惠码不在有效期内")
+	}
+
+	if !coupon.AppliesToPlan(plan.ID) {
+		return nil, 0, errors.New("优惠码不适用于该套餐")
+	}
+
+	if coupon.MinPeriodDays > 0 && periodDays < coupon.MinPeriodDays {
+		return nil, 0, fmt.Errorf("购买天数需不少于 %d 天", coupon.MinPeriodDays)
+	}
+	if coupon.MaxPeriodDays > 0 && periodDays > coupon.MaxPeriodDays {
+		return nil, 0, fmt.Errorf("购买天数需不超过 %d 天", coupon.MaxPeriodDays)
+	}
+
+	// 次数限制在下单事务中由 claimCoupon 再次校验并占用，这里只提前提示
+	if coupon.TotalLimit > 0 && coupon.UsedCount >= coupon.TotalLimit {
+		return nil, 0, errCouponExhausted
+	}
+	if err := checkCouponUserLimit(model.DB, &coupon, userID); err != nil {
+		return nil, 0, err
+	}
+
+	return &coupon, coupon.CalculateDiscount(amount), nil
+}
+
+// checkCouponUserLimit 校验用户的优惠码使用次数
+func checkCouponUserLimit(tx *gorm.DB, coupon *model.Coupon, userID uint) error {
+	if coupon.PerUserLimit <= 0 {
+		return nil
+	}
+	var used int64
+	if err := tx.Model(&model.Order{}).
+		Where("coupon_id = ? AND user_id = ? AND status IN ?", coupon.ID, userID, couponUsageStatuses).
+		Count(&used).Error; err != nil {
+		return err
+	}
+	if int(used) >= coupon.PerUserLimit {
+		return errors.New("您已达到该优惠码的使用次数上限")
+	}
+	return nil
+}
+
+// claimCoupon 在创建订单的事务中占用一次优惠码使用次数，需在订单写入前调用
+// 总次数以 used_count 为条件更新，并发下单时不会超出限制；先更新优惠码行再统计用户次数，
+// 同一优惠码的下单事务在该行上互斥，统计到订单写入之间不会有其他订单插入
+func claimCoupon(tx *gorm.DB, order *model.Order) error {
+	var coupon model.Coupon
+	if err := tx.First(&coupon, order.CouponID).Error; err != nil {
+		return errors.New("优惠码不存在")
+	}
+
+	result := tx.Model(&model.Coupon{}).
+		Where("id = ? AND (total_limit = 0 OR used_count < total_limit)", coupon.ID).
+		Update("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errCouponExhausted
+	}
+	return checkCouponUserLimit(tx, &coupon, order.UserID)
+}
+
+// releaseCoupon 订单取消或退款时释放占用的优惠码使用次数
+func releaseCoupon(tx *gorm.DB, order *model.Order) error {
+	if order.CouponID == 0 {
+		return nil
+	}
+	return tx.Model(&model.Coupon{}).
+		Where("id = ? AND used_count > 0", order.CouponID).
+		Update("used_count", gorm.Expr("used_count - 1")).Error
+}
+
+// CreateOrder 创建订单，使用优惠码时在同一事务中占用使用次数，次数已满时返回 ErrCouponUnavailable
+// 使用优惠码的订单总有支付时限，否则未支付的订单会一直占用次数
+func CreateOrder(order *model.Order) error {
+	if order.CouponID > 0 && order.ExpiresAt == nil {
+		expiresAt := time.Now().Add(couponOrderPaymentWindow)
+		order.ExpiresAt = &expiresAt
+	}
+
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		if order.CouponID > 0 {
+			if err := claimCoupon(tx, order); err != nil {
+				return fmt.Errorf("%w: %v", ErrCouponUnavailable, err)
+			}
+		}
+		return tx.Create(order).Error
+	})
+}
+
+// ApplyCoupon 将优惠码应用到待创建的订单
+func ApplyCoupon(order *model.Order, code string, plan *model.Plan) error {
+	order.OriginalAmount = order.Amount
+	if strings.TrimSpace(code) == "" {
+		return nil
+	}
+
+	coupon, discount, err := ValidateCoupon(code, order.UserID, plan, order.PeriodDays, order.Amount)
+	if err != nil {
+		return err
+	}
+
+	order.CouponID = coupon.ID
+	order.CouponCode = coupon.Code
+	order.DiscountAmount = discount
+	order.Amount = order.OriginalAmount - discount
+	return nil
+}
+
+// CouponUsageStats 优惠码使用统计
+type CouponUsageStats struct {
+	CouponID      uint    `json:"coupon_id"`
+	Code          string  `json:"code"`
+	PaidCount     int64   `json:"paid_count"`
+	PendingCount  int64   `json:"pending_count"`
+	UserCount     int64   `json:"user_count"`
+	TotalDiscount float64 `json:"total_discount"`
+	TotalRevenue  float64 `json:"total_revenue"`
+}
+
+// GetCouponUsageStats 统计优惠码使用情况
+func GetCouponUsageStats(coupon *model.Coupon) CouponUsageStats {
+	stats := CouponUsageStats{
+		CouponID: coupon.ID,
+		Code:     coupon.Code,
+	}
+
+	model.DB.Model(&model.Order{}).
+		Where("coupon_id = ? AND status = ?", coupon.ID, model.OrderStatusPaid).
+		Count(&stats.PaidCount)
+	model.DB.Model(&model.Order{}).
+		Where("coupon_id = ? AND status = ?", coupon.ID, model.OrderStatusPending).
+		Count(&stats.PendingCount)
+	model.DB.Model(&model.Order{}).
+		Where("coupon_id = ? AND status = ?", coupon.ID, model.OrderStatusPaid).
+		Distinct("user_id").
+		Count(&stats.UserCount)
+
+	var sums struct {
+		TotalDiscount float64
+		TotalRevenue  float64
+	}
+	model.DB.Model(&model.Order{}).
+		Select("COALESCE(SUM(discount_amount), 0) AS total_discount, COALESCE(SUM(amount), 0) AS total_revenue").
+		Where("coupon_id = ? AND status = ?", coupon.ID, model.OrderStatusPaid).
+		Scan(&sums)
+	stats.TotalDiscount = sums.TotalDiscount
+	stats.TotalRevenue = sums.TotalRevenue
+
+	return stats
+}
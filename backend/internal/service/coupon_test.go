@@ -0,0 +1,183 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestCreateOrderClaimsCouponAtomically(t *testing.T) {
+	setupTest(t)
+	plan := createTestPlan(t, 1000, 0, 0)
+	coupon := &model.Coupon{
+		Code:          "ONCE",
+		DiscountType:  model.CouponTypeFixed,
+		DiscountValue: 1,
+		TotalLimit:    1,
+		Status:        model.CouponStatusOn,
+	}
+	if err := model.DB.Create(coupon).Error; err != nil {
+		t.Fatalf("创建优惠码失败: %v", err)
+	}
+
+	// 多个用户同时使用只能领取一次的优惠码，只有一个订单能创建成功
+	var orders []*model.Order
+	for _, name := range []string{"u1", "u2", "u3", "u4"} {
+		user := createTestUser(t, name, 0)
+		order := &model.Order{
+			OrderNo:    "C" + name,
+			UserID:     user.ID,
+			PlanID:     plan.ID,
+			OrderType:  model.OrderTypeNew,
+			PeriodDays: plan.PeriodDays,
+			Amount:     plan.Price,
+			Status:     model.OrderStatusPending,
+		}
+		if err := ApplyCoupon(order, coupon.Code, plan); err != nil {
+			t.Fatalf("应用优惠码失败: %v", err)
+		}
+		orders = append(orders, order)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(orders))
+	for i, order := range orders {
+		wg.Add(1)
+		go func(i int, order *model.Order) {
+			defer wg.Done()
+			errs[i] = CreateOrder(order)
+		}(i, order)
+	}
+	wg.Wait()
+
+	var created *model.Order
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if created != nil {
+				t.Fatal("优惠码被超额使用")
+			}
+			created = orders[i]
+		case !errors.Is(err, ErrCouponUnavailable):
+			t.Fatalf("创建订单失败: %v", err)
+		}
+	}
+	if created == nil {
+		t.Fatal("没有订单使用到优惠码")
+	}
+
+	// 订单过期取消后释放名额
+	expired := time.Now().Add(-time.Minute)
+	model.DB.Model(created).Update("expires_at", &expired)
+	CancelExpiredOrders()
+	model.DB.First(coupon, coupon.ID)
+	if coupon.UsedCount != 0 {
+		t.Fatalf("取消订单后优惠码已占用次数 = %d, want 0", coupon.UsedCount)
+	}
+	if _, _, err := ValidateCoupon(coupon.Code, orders[0].UserID, plan, plan.PeriodDays, plan.Price); err != nil {
+		t.Fatalf("释放名额后优惠码不可用: %v", err)
+	}
+}
+
+func TestCreateOrderEnforcesPerUserLimitAtomically(t *testing.T) {
+	setupTest(t)
+	plan := createTestPlan(t, 1000, 0, 0)
+	user := createTestUser(t, "once-per-user", 0)
+	coupon := &model.Coupon{
+		Code:          "PERUSER",
+		DiscountType:  model.CouponTypeFixed,
+		DiscountValue: 1,
+		PerUserLimit:  1,
+		Status:        model.CouponStatusOn,
+	}
+	if err := model.DB.Create(coupon).Error; err != nil {
+		t.Fatalf("创建优惠码失败: %v", err)
+	}
+
+	// 同一用户同时用每人限用一次的优惠码下单，只有一个订单能创建成功
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		order := &model.Order{
+			OrderNo:    "P" + string(rune('a'+i)),
+			UserID:     user.ID,
+			PlanID:     plan.ID,
+			OrderType:  model.OrderTypeNew,
+			PeriodDays: plan.PeriodDays,
+			Amount:     plan.Price,
+			Status:     model.OrderStatusPending,
+		}
+		if err := ApplyCoupon(order, coupon.Code, plan); err != nil {
+			t.Fatalf("应用优惠码失败: %v", err)
+		}
+		wg.Add(1)
+		go func(i int, order *model.Order) {
+			defer wg.Done()
+			errs[i] = CreateOrder(order)
+		}(i, order)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrCouponUnavailable):
+			t.Fatalf("创建订单失败: %v", err)
+		}
+	}
+	model.DB.First(coupon, coupon.ID)
+	if created != 1 || coupon.UsedCount != 1 {
+		t.Errorf("创建成功 %d 个订单, 优惠码已占用 %d 次, want 1, 1", created, coupon.UsedCount)
+	}
+}
+
+func TestCouponOrderExpiresWithoutPaymentWindow(t *testing.T) {
+	setupTest(t)
+	model.SetSetting(model.SettingOrderPaymentWindow, "0")
+	plan := createTestPlan(t, 1000, 0, 0)
+	user := createTestUser(t, "no-window", 0)
+	coupon := &model.Coupon{
+		Code:          "NOWINDOW",
+		DiscountType:  model.CouponTypeFixed,
+		DiscountValue: 1,
+		TotalLimit:    1,
+		Status:        model.CouponStatusOn,
+	}
+	if err := model.DB.Create(coupon).Error; err != nil {
+		t.Fatalf("创建优惠码失败: %v", err)
+	}
+
+	// 未设置支付时限时，使用优惠码的订单仍会过期，取消后释放名额
+	order := &model.Order{
+		OrderNo:    "NOWINDOW1",
+		UserID:     user.ID,
+		PlanID:     plan.ID,
+		OrderType:  model.OrderTypeNew,
+		PeriodDays: plan.PeriodDays,
+		Amount:     plan.Price,
+		Status:     model.OrderStatusPending,
+		ExpiresAt:  model.NewOrderExpiresAt(),
+	}
+	if err := ApplyCoupon(order, coupon.Code, plan); err != nil {
+		t.Fatalf("应用优惠码失败: %v", err)
+	}
+	if err := CreateOrder(order); err != nil {
+		t.Fatalf("创建订单失败: %v", err)
+	}
+	if order.ExpiresAt == nil || order.ExpiresAt.Before(time.Now().Add(23*time.Hour)) {
+		t.Fatalf("订单支付截止时间 = %v, 期望 24 小时后", order.ExpiresAt)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	model.DB.Model(order).Update("expires_at", &expired)
+	CancelExpiredOrders()
+	model.DB.First(coupon, coupon.ID)
+	if coupon.UsedCount != 0 {
+		t.Errorf("取消订单后优惠码已占用次数 = %d, want 0", coupon.UsedCount)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/smtp"
+	"time"
 
 	"newapi-subscribe/internal/config"
 	"newapi-subscribe/internal/model"
@@ -76,3 +77,116 @@ func SendExpirationReminder(email, username, planName string, daysRemaining int)
 		log.Printf("已发送到期提醒邮件给 %s", email)
 	}
 }
+
+// SendTrialEndingReminder 发送试用即将结束提醒（引导转为正式订阅）
+func SendTrialEndingReminder(email, username, planName string, daysRemaining int) {
+	siteName := model.GetSetting(model.SettingSiteName)
+	pricingURL := siteBaseURL() + "/"
+
+	var when string
+	if daysRemaining == 0 {
+		when = "今日"
+	} else {
+		when = fmt.Sprintf("%d 天后", daysRemaining)
+	}
+
+	subject := fmt.Sprintf("[%s] 您的试用将于%s结束", siteName, when)
+	body := fmt.Sprintf(`
+		<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>试用即将结束</h2>
+			<p>亲爱的 %s：</p>
+			<p>您的 <strong>%s</strong> 试用将于 <strong>%s</strong>结束，结束后额度将被清零。</p>
+			<p>喜欢我们的服务吗？现在订阅正式套餐即可继续使用。</p>
+			<p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #1677ff; color: #fff; text-decoration: none; border-radius: 4px;">查看套餐</a></p>
+			<p style="margin-top: 30px; color: #666;">
+				—— %s
+			</p>
+		</div>
+	`, username, planName, when, pricingURL, siteName)
+
+	if err := SendEmail(email, subject, body); err != nil {
+		log.Printf("发送试用结束提醒邮件失败: %v", err)
+	} else {
+		log.Printf("已发送试用结束提醒邮件给 %s", email)
+	}
+}
+
+// SendAutoRenewSuccess 发送自动续费成功通知
+func SendAutoRenewSuccess(email, username, planName string, amount, balance float64, endDate time.Time) {
+	siteName := model.GetSetting(model.SettingSiteName)
+
+	subject := fmt.Sprintf("[%s] 您的订阅已自动续费", siteName)
+	body := fmt.Sprintf(`
+		<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>自动续费成功</h2>
+			<p>亲爱的 %s：</p>
+			<p>您的 <strong>%s</strong> 订阅已通过钱包余额自动续费，扣款 <strong>%.2f</strong> 元。</p>
+			<p>订阅有效期至 <strong>%s</strong>，当前钱包余额 %.2f 元。</p>
+			<p style="margin-top: 30px; color: #666;">
+				—— %s
+			</p>
+		</div>
+	`, username, planName, amount, endDate.Format("2006-01-02"), balance, siteName)
+
+	if err := SendEmail(email, subject, body); err != nil {
+		log.Printf("发送自动续费成功邮件失败: %v", err)
+	} else {
+		log.Printf("已发送自动续费成功邮件给 %s", email)
+	}
+}
+
+// SendAutoRenewFailed 发送自动续费失败通知
+func SendAutoRenewFailed(email, username, planName, reason string) {
+	siteName := model.GetSetting(model.SettingSiteName)
+
+	subject := fmt.Sprintf("[%s] 您的订阅自动续费失败", siteName)
+	body := fmt.Sprintf(`
+		<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>自动续费失败</h2>
+			<p>亲爱的 %s：</p>
+			<p>您的 <strong>%s</strong> 订阅自动续费失败，订阅已到期。</p>
+			<p>失败原因：%s</p>
+			<p>请充值钱包后手动续费或重新购买。</p>
+			<p style="margin-top: 30px; color: #666;">
+				—— %s
+			</p>
+		</div>
+	`, username, planName, reason, siteName)
+
+	if err := SendEmail(email, subject, body); err != nil {
+		log.Printf("发送自动续费失败邮件失败: %v", err)
+	} else {
+		log.Printf("已发送自动续费失败邮件给 %s", email)
+	}
+}
+
+// SendLowBalanceReminder 发送自动续费余额不足提醒
+func SendLowBalanceReminder(email, username, planName string, price, balance float64, daysRemaining int) {
+	siteName := model.GetSetting(model.SettingSiteName)
+
+	var when string
+	if daysRemaining == 0 {
+		when = "今日"
+	} else {
+		when = fmt.Sprintf("%d 天后", daysRemaining)
+	}
+
+	subject := fmt.Sprintf("[%s] 钱包余额不足，自动续费可能失败", siteName)
+	body := fmt.Sprintf(`
+		<div style="font-family: sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>钱包余额不足</h2>
+			<p>亲爱的 %s：</p>
+			<p>您的 <strong>%s</strong> 订阅将于 <strong>%s</strong>到期并自动续费，续费需 %.2f 元，当前钱包余额 %.2f 元。</p>
+			<p>为了不影响您的正常使用，请及时充值。</p>
+			<p style="margin-top: 30px; color: #666;">
+				—— %s
+			</p>
+		</div>
+	`, username, planName, when, price, balance, siteName)
+
+	if err := SendEmail(email, subject, body); err != nil {
+		log.Printf("发送余额不足提醒邮件失败: %v", err)
+	} else {
+		log.Printf("已发送余额不足提醒邮件给 %s", email)
+	}
+}
@@ -3,33 +3,55 @@ package service
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
 )
 
+// PaymentProviderEpay 易支付网关标识
+const PaymentProviderEpay = "epay"
+
 // EpayService 易支付服务
 type EpayService struct {
-	apiURL string
-	pid    string
-	key    string
+	apiURL     string
+	pid        string
+	key        string
+	httpClient *http.Client
 }
 
 // NewEpayService 创建易支付服务
 func NewEpayService() *EpayService {
 	return &EpayService{
-		apiURL: config.Cfg.EpayURL,
-		pid:    config.Cfg.EpayPID,
-		key:    config.Cfg.EpayKey,
+		apiURL:     strings.TrimSuffix(config.Cfg.EpayURL, "/"),
+		pid:        config.Cfg.EpayPID,
+		key:        config.Cfg.EpayKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
 	}
 }
 
+// Name 网关标识
+func (e *EpayService) Name() string {
+	return PaymentProviderEpay
+}
+
+// Methods 支持的支付方式
+func (e *EpayService) Methods() []string {
+	return []string{"alipay", "wxpay"}
+}
+
 // CreatePayment 创建支付
-func (e *EpayService) CreatePayment(orderNo string, amount float64, payType, subject string) (string, error) {
+func (e *EpayService) CreatePayment(order *model.Order, payType, subject string) (string, error) {
 	if e.apiURL == "" || e.pid == "" || e.key == "" {
 		return "", fmt.Errorf("易支付未配置")
 	}
@@ -37,11 +59,11 @@ func (e *EpayService) CreatePayment(orderNo string, amount float64, payType, sub
 	params := map[string]string{
 		"pid":          e.pid,
 		"type":         payType,
-		"out_trade_no": orderNo,
-		"notify_url":   config.Cfg.NewAPIURL + "/api/orders/notify", // 使用本系统地址
-		"return_url":   config.Cfg.NewAPIURL + "/user/orders",
+		"out_trade_no": order.OrderNo,
+		"notify_url":   paymentNotifyURL(e.Name()),
+		"return_url":   paymentReturnURL(),
 		"name":         subject,
-		"money":        fmt.Sprintf("%.2f", amount),
+		"money":        fmt.Sprintf("%.2f", order.Amount),
 	}
 
 	// 生成签名
@@ -59,8 +81,8 @@ func (e *EpayService) CreatePayment(orderNo string, amount float64, payType, sub
 	return payURL, nil
 }
 
-// VerifyNotify 验证回调签名
-func (e *EpayService) VerifyNotify(c *gin.Context) bool {
+// VerifyNotify 验证回调签名并解析支付结果
+func (e *EpayService) VerifyNotify(c *gin.Context) (*PaymentResult, error) {
 	params := make(map[string]string)
 	for k, v := range c.Request.URL.Query() {
 		if k != "sign" && k != "sign_type" && len(v) > 0 {
@@ -71,7 +93,128 @@ func (e *EpayService) VerifyNotify(c *gin.Context) bool {
 	sign := c.Query("sign")
 	expectedSign := e.generateSign(params)
 
-	return sign == expectedSign
+	if sign != expectedSign {
+		return nil, errors.New("签名校验失败")
+	}
+
+	// 金额缺失或无法解析时视为校验失败，不能跳过金额核对
+	amount, err := strconv.ParseFloat(c.Query("money"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("回调金额无效: %q", c.Query("money"))
+	}
+
+	return &PaymentResult{
+		OrderNo: c.Query("out_trade_no"),
+		TradeNo: c.Query("trade_no"),
+		Amount:  amount,
+		Paid:    c.Query("trade_status") == "TRADE_SUCCESS",
+	}, nil
+}
+
+// NotifyAck 应答回调
+func (e *EpayService) NotifyAck(c *gin.Context, ok bool) {
+	if ok {
+		c.String(http.StatusOK, "success")
+		return
+	}
+	c.String(http.StatusOK, "fail")
+}
+
+// QueryOrder 查询订单支付状态
+func (e *EpayService) QueryOrder(order *model.Order) (*PaymentResult, error) {
+	if e.apiURL == "" || e.pid == "" || e.key == "" {
+		return nil, fmt.Errorf("易支付未配置")
+	}
+
+	params := url.Values{}
+	params.Set("act", "order")
+	params.Set("pid", e.pid)
+	params.Set("key", e.key)
+	params.Set("out_trade_no", order.OrderNo)
+
+	resp, err := e.httpClient.Get(e.apiURL + "/api.php?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("易支付查询订单失败: HTTP %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		Code       int         `json:"code"`
+		Msg        string      `json:"msg"`
+		TradeNo    string      `json:"trade_no"`
+		OutTradeNo string      `json:"out_trade_no"`
+		Money      json.Number `json:"money"`
+		Status     json.Number `json:"status"` // 1=已支付, 0=未支付
+	}
+
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v, body: %s", err, string(respBody))
+	}
+
+	if result.Code != 1 {
+		return nil, fmt.Errorf("易支付查询订单失败: %s", result.Msg)
+	}
+
+	if result.OutTradeNo != "" && result.OutTradeNo != order.OrderNo {
+		return nil, fmt.Errorf("易支付返回的订单号不匹配: %s", result.OutTradeNo)
+	}
+
+	paid := result.Status.String() == "1"
+	amount, err := result.Money.Float64()
+	if err != nil && paid {
+		return nil, fmt.Errorf("易支付返回的金额无效: %q", result.Money)
+	}
+
+	return &PaymentResult{
+		OrderNo: order.OrderNo,
+		TradeNo: result.TradeNo,
+		Amount:  amount,
+		Paid:    paid,
+	}, nil
+}
+
+// Refund 原路退款
+func (e *EpayService) Refund(order *model.Order, amount float64) error {
+	if e.apiURL == "" || e.pid == "" || e.key == "" {
+		return fmt.Errorf("易支付未配置")
+	}
+
+	form := url.Values{}
+	form.Set("pid", e.pid)
+	form.Set("key", e.key)
+	form.Set("out_trade_no", order.OrderNo)
+	if order.TradeNo != "" {
+		form.Set("trade_no", order.TradeNo)
+	}
+	form.Set("money", fmt.Sprintf("%.2f", amount))
+
+	resp, err := e.httpClient.PostForm(e.apiURL+"/api.php?act=refund", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("解析响应失败: %v, body: %s", err, string(respBody))
+	}
+
+	if result.Code != 1 {
+		return fmt.Errorf("易支付退款失败: %s", result.Msg)
+	}
+
+	return nil
 }
 
 // generateSign 生成签名
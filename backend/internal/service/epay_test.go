@@ -0,0 +1,149 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/model"
+)
+
+// signedEpayNotify 构造带签名的易支付回调请求
+func signedEpayNotify(e *EpayService, params map[string]string) *gin.Context {
+	query := url.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	query.Set("sign", e.generateSign(params))
+	query.Set("sign_type", "MD5")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/orders/notify/epay?"+query.Encode(), nil)
+	return c
+}
+
+func TestEpayVerifyNotifyRequiresAmount(t *testing.T) {
+	e := &EpayService{pid: "1000", key: "secret"}
+	params := map[string]string{
+		"pid":          "1000",
+		"out_trade_no": "SUB1",
+		"trade_no":     "T1",
+		"trade_status": "TRADE_SUCCESS",
+	}
+
+	// 签名正确但缺少金额或金额无法解析时校验失败
+	if _, err := e.VerifyNotify(signedEpayNotify(e, params)); err == nil {
+		t.Error("缺少 money 的回调应校验失败")
+	}
+	params["money"] = "abc"
+	if _, err := e.VerifyNotify(signedEpayNotify(e, params)); err == nil {
+		t.Error("money 无法解析的回调应校验失败")
+	}
+
+	params["money"] = "9.90"
+	result, err := e.VerifyNotify(signedEpayNotify(e, params))
+	if err != nil {
+		t.Fatalf("VerifyNotify: %v", err)
+	}
+	if !result.Paid || result.Amount != 9.9 || result.OrderNo != "SUB1" {
+		t.Errorf("支付结果 = %+v, 期望 SUB1 已支付 9.90", result)
+	}
+}
+
+// newEpayQueryServer 模拟易支付 api.php?act=order 接口，按订单号返回预设的状态码和响应
+func newEpayQueryServer(t *testing.T, responses map[string]struct {
+	status int
+	body   string
+}) *EpayService {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/api.php" || query.Get("act") != "order" ||
+			query.Get("pid") != "1000" || query.Get("key") != "secret" {
+			t.Errorf("查询请求 = %s", r.URL.String())
+		}
+		resp, ok := responses[query.Get("out_trade_no")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	t.Cleanup(server.Close)
+	return &EpayService{apiURL: server.URL, pid: "1000", key: "secret", httpClient: server.Client()}
+}
+
+func TestEpayQueryOrder(t *testing.T) {
+	e := newEpayQueryServer(t, map[string]struct {
+		status int
+		body   string
+	}{
+		"PAID_STR":   {http.StatusOK, `{"code":1,"trade_no":"T1","out_trade_no":"PAID_STR","money":"9.90","status":"1"}`},
+		"PAID_NUM":   {http.StatusOK, `{"code":1,"trade_no":"T2","out_trade_no":"PAID_NUM","money":9.9,"status":1}`},
+		"UNPAID":     {http.StatusOK, `{"code":1,"trade_no":"","out_trade_no":"UNPAID","money":"9.90","status":0}`},
+		"NOT_FOUND":  {http.StatusOK, `{"code":-1,"msg":"订单号不存在"}`},
+		"BAD_GATE":   {http.StatusBadGateway, `{"code":1,"out_trade_no":"BAD_GATE","money":"9.90","status":1}`},
+		"OTHER_NO":   {http.StatusOK, `{"code":1,"out_trade_no":"SOMEONE_ELSE","money":"9.90","status":1}`},
+		"PAID_NOAMT": {http.StatusOK, `{"code":1,"trade_no":"T3","out_trade_no":"PAID_NOAMT","status":1}`},
+	})
+
+	// money 为字符串或数字时都能解析
+	for _, orderNo := range []string{"PAID_STR", "PAID_NUM"} {
+		result, err := e.QueryOrder(&model.Order{OrderNo: orderNo})
+		if err != nil {
+			t.Fatalf("查询 %s: %v", orderNo, err)
+		}
+		if !result.Paid || result.Amount != 9.9 || result.TradeNo == "" || result.OrderNo != orderNo {
+			t.Errorf("%s 支付结果 = %+v, 期望已支付 9.90", orderNo, result)
+		}
+	}
+
+	result, err := e.QueryOrder(&model.Order{OrderNo: "UNPAID"})
+	if err != nil {
+		t.Fatalf("查询未支付订单: %v", err)
+	}
+	if result.Paid {
+		t.Errorf("未支付订单结果 = %+v", result)
+	}
+
+	// 非 200 响应、code != 1、订单号不符、已支付但缺少金额均视为查询失败
+	for _, orderNo := range []string{"NOT_FOUND", "BAD_GATE", "OTHER_NO", "PAID_NOAMT"} {
+		if result, err := e.QueryOrder(&model.Order{OrderNo: orderNo}); err == nil {
+			t.Errorf("查询 %s 应失败, 结果 = %+v", orderNo, result)
+		}
+	}
+}
+
+func TestEpayQueryOrderAmountMismatch(t *testing.T) {
+	setupTest(t)
+	user := createTestUser(t, "ivy", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+	model.DB.Model(order).Update("payment_provider", PaymentProviderEpay)
+	order.PaymentProvider = PaymentProviderEpay
+
+	e := newEpayQueryServer(t, map[string]struct {
+		status int
+		body   string
+	}{
+		order.OrderNo: {http.StatusOK, `{"code":1,"trade_no":"T4","out_trade_no":"` + order.OrderNo + `","money":"0.01","status":1}`},
+	})
+
+	// 查询到的实付金额与订单金额不符时不完成订单
+	result, err := e.QueryOrder(order)
+	if err != nil {
+		t.Fatalf("QueryOrder: %v", err)
+	}
+	if err := ProcessPaymentResult(order, e.Name(), result); err == nil {
+		t.Error("实付金额不符时应处理失败")
+	}
+	var saved model.Order
+	model.DB.First(&saved, order.ID)
+	if saved.Status != model.OrderStatusPending {
+		t.Errorf("订单状态 = %s, 期望保持待支付", saved.Status)
+	}
+}
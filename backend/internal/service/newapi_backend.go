@@ -0,0 +1,35 @@
+package service
+
+import (
+	"time"
+)
+
+// NewAPIBackend new-api 接口，由 NewAPIClient 通过 HTTP 实现，测试时使用 FakeNewAPI
+type NewAPIBackend interface {
+	// AdminLogin 确保管理员认证可用
+	AdminLogin() error
+	// Login 用户登录并返回用户信息，之后 GetSelf 返回该用户
+	Login(username, password string) (*NewAPIUser, error)
+	// GetSelf 获取当前登录用户信息
+	GetSelf() (*NewAPIUser, error)
+	// GetUser 获取用户信息（需要管理员权限）
+	GetUser(userID int) (*NewAPIUser, error)
+	// UpdateUser 更新用户（需要管理员权限）
+	UpdateUser(user *NewAPIUser) error
+	// CreateUser 创建用户（需要管理员权限）
+	CreateUser(username, password, group string) (*NewAPIUser, error)
+	// GetGroups 获取分组列表（需要管理员权限）
+	GetGroups() ([]string, error)
+	// GetGroupModels 获取分组下启用渠道提供的模型及其倍率（需要管理员权限）
+	GetGroupModels(group string) (*NewAPIGroupModels, error)
+	// GetUserLogs 获取用户在时间范围内的全部日志
+	GetUserLogs(userID int, startDate, endDate string) ([]NewAPILog, error)
+	// EachUserLog 按页遍历符合条件的日志（按 ID 倒序），fn 返回 false 时停止遍历
+	EachUserLog(query NewAPILogQuery, fn func(NewAPILog) bool) error
+	// GetUserLogsPage 获取一页符合条件的日志，按 ID 倒序，page 从 1 开始
+	GetUserLogsPage(query NewAPILogQuery, page, pageSize int) ([]NewAPILog, error)
+	// GetUserQuotaUsedToday 获取用户今日已用额度
+	GetUserQuotaUsedToday(userID int, loc *time.Location) (int, error)
+}
+
+var _ NewAPIBackend = (*NewAPIClient)(nil)
@@ -7,8 +7,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -49,9 +50,15 @@ type NewAPILog struct {
 	CompletionTokens int    `json:"completion_tokens"`
 }
 
-// NewNewAPIClient 创建 new-api 客户端
+const (
+	// NewAPILogTypeConsume new-api 消费日志类型
+	NewAPILogTypeConsume = 2
+	// newAPILogPageSize 分页读取日志时的每页条数
+	newAPILogPageSize = 100
+)
+
+// NewNewAPIClient 创建 new-api 客户端，管理员请求共享进程内的管理员会话
 func NewNewAPIClient() *NewAPIClient {
-	jar, _ := cookiejar.New(nil)
 	baseURL := config.Cfg.NewAPIURL
 	// 移除末尾斜杠
 	baseURL = strings.TrimSuffix(baseURL, "/")
@@ -60,23 +67,42 @@ func NewNewAPIClient() *NewAPIClient {
 		adminID: config.Cfg.NewAPIAdminID,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
-			Jar:     jar,
 		},
 	}
 }
 
-// AdminLogin 管理员登录
+// withRateLimit 让客户端的所有请求经过限流器，多个客户端可共享同一限流器
+func (c *NewAPIClient) withRateLimit(limiter *rateLimiter) *NewAPIClient {
+	if limiter != nil {
+		c.httpClient.Transport = &rateLimitedTransport{limiter: limiter, base: http.DefaultTransport}
+	}
+	return c
+}
+
+// AdminLogin 确保管理员会话可用，已有未过期的会话或使用访问令牌时不重新登录
 func (c *NewAPIClient) AdminLogin() error {
-	_, err := c.login(config.Cfg.NewAPIAdminUser, config.Cfg.NewAPIAdminPass)
+	if useAccessToken() {
+		return nil
+	}
+	_, _, err := newAPIAdmin.get(c)
 	return err
 }
 
 // Login 用户登录并返回用户信息
 func (c *NewAPIClient) Login(username, password string) (*NewAPIUser, error) {
-	return c.login(username, password)
+	user, cookies, err := c.postLogin(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cookies = cookies
+	c.currentUser = user
+
+	return user, nil
 }
 
-func (c *NewAPIClient) login(username, password string) (*NewAPIUser, error) {
+// postLogin 登录并返回用户信息和会话 cookie
+func (c *NewAPIClient) postLogin(username, password string) (*NewAPIUser, []*http.Cookie, error) {
 	data := map[string]string{
 		"username": username,
 		"password": password,
@@ -85,7 +111,7 @@ func (c *NewAPIClient) login(username, password string) (*NewAPIUser, error) {
 
 	resp, err := c.httpClient.Post(c.baseURL+"/api/user/login", "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
@@ -98,17 +124,14 @@ func (c *NewAPIClient) login(username, password string) (*NewAPIUser, error) {
 	}
 
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, fmt.Errorf("解析响应失败: %v", err)
+		return nil, nil, fmt.Errorf("解析响应失败: %v", err)
 	}
 
 	if !result.Success {
-		return nil, errors.New(result.Message)
+		return nil, nil, errors.New(result.Message)
 	}
 
-	c.cookies = resp.Cookies()
-	c.currentUser = &result.Data
-
-	return &result.Data, nil
+	return &result.Data, resp.Cookies(), nil
 }
 
 // GetSelf 获取当前登录用户信息
@@ -148,32 +171,12 @@ func (c *NewAPIClient) GetSelf() (*NewAPIUser, error) {
 	return &result.Data, nil
 }
 
-// addAdminHeader 添加管理员 header
-func (c *NewAPIClient) addAdminHeader(req *http.Request) {
-	for _, cookie := range c.cookies {
-		req.AddCookie(cookie)
-	}
-	if c.adminID != "" {
-		req.Header.Set("New-Api-User", c.adminID)
-	}
-}
-
 // GetUser 获取用户信息（需要管理员权限）
 func (c *NewAPIClient) GetUser(userID int) (*NewAPIUser, error) {
-	if err := c.AdminLogin(); err != nil {
-		return nil, fmt.Errorf("管理员登录失败: %v", err)
-	}
-
-	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/user/%d", c.baseURL, userID), nil)
-	c.addAdminHeader(req)
-
-	resp, err := c.httpClient.Do(req)
+	respBody, err := c.doAdmin("GET", fmt.Sprintf("/api/user/%d", userID), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
 
 	var result struct {
 		Success bool       `json:"success"`
@@ -194,22 +197,11 @@ func (c *NewAPIClient) GetUser(userID int) (*NewAPIUser, error) {
 
 // UpdateUser 更新用户（需要管理员权限）
 func (c *NewAPIClient) UpdateUser(user *NewAPIUser) error {
-	if err := c.AdminLogin(); err != nil {
-		return fmt.Errorf("管理员登录失败: %v", err)
-	}
-
 	body, _ := json.Marshal(user)
-	req, _ := http.NewRequest("PUT", c.baseURL+"/api/user/", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
-	c.addAdminHeader(req)
-
-	resp, err := c.httpClient.Do(req)
+	respBody, err := c.doAdmin("PUT", "/api/user/", body)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
 
 	var result struct {
 		Success bool   `json:"success"`
@@ -229,10 +221,6 @@ func (c *NewAPIClient) UpdateUser(user *NewAPIUser) error {
 
 // CreateUser 创建用户（需要管理员权限）
 func (c *NewAPIClient) CreateUser(username, password, group string) (*NewAPIUser, error) {
-	if err := c.AdminLogin(); err != nil {
-		return nil, fmt.Errorf("管理员登录失败: %v", err)
-	}
-
 	data := map[string]interface{}{
 		"username":     username,
 		"password":     password,
@@ -241,17 +229,10 @@ func (c *NewAPIClient) CreateUser(username, password, group string) (*NewAPIUser
 	}
 	body, _ := json.Marshal(data)
 
-	req, _ := http.NewRequest("POST", c.baseURL+"/api/user/", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
-	c.addAdminHeader(req)
-
-	resp, err := c.httpClient.Do(req)
+	respBody, err := c.doAdmin("POST", "/api/user/", body)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
 
 	var result struct {
 		Success bool       `json:"success"`
@@ -272,20 +253,10 @@ func (c *NewAPIClient) CreateUser(username, password, group string) (*NewAPIUser
 
 // GetGroups 获取分组列表（需要管理员权限）
 func (c *NewAPIClient) GetGroups() ([]string, error) {
-	if err := c.AdminLogin(); err != nil {
-		return nil, fmt.Errorf("管理员登录失败: %v", err)
-	}
-
-	req, _ := http.NewRequest("GET", c.baseURL+"/api/group/", nil)
-	c.addAdminHeader(req)
-
-	resp, err := c.httpClient.Do(req)
+	respBody, err := c.doAdmin("GET", "/api/group/", nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
 
 	var result struct {
 		Success bool     `json:"success"`
@@ -304,100 +275,312 @@ func (c *NewAPIClient) GetGroups() ([]string, error) {
 	return result.Data, nil
 }
 
-// GetGroupModels 获取分组下的模型
-func (c *NewAPIClient) GetGroupModels(group string) (interface{}, error) {
-	return map[string]string{"group": group}, nil
+// NewAPIModel 模型的计费倍率
+type NewAPIModel struct {
+	Name            string  `json:"name"`
+	QuotaType       int     `json:"quota_type"`       // 0=按量计费, 1=按次计费
+	ModelRatio      float64 `json:"model_ratio"`      // 按量计费时每个输入 token 消耗的额度（未配置时为 0）
+	CompletionRatio float64 `json:"completion_ratio"` // 输出 token 相对输入 token 的倍率
+	ModelPrice      float64 `json:"model_price"`      // 按次计费时每次请求的价格（美元）
 }
 
-// GetUserLogs 获取用户日志（需要管理员权限）
-func (c *NewAPIClient) GetUserLogs(userID int, startDate, endDate string) ([]NewAPILog, error) {
-	if err := c.AdminLogin(); err != nil {
-		return nil, fmt.Errorf("管理员登录失败: %v", err)
-	}
+// NewAPIGroupModels 分组可用的模型
+type NewAPIGroupModels struct {
+	Group      string        `json:"group"`
+	GroupRatio float64       `json:"group_ratio"` // 分组倍率，消耗额度 = 模型消耗 × 分组倍率
+	Models     []NewAPIModel `json:"models"`      // 按名称排序
+}
 
-	params := url.Values{}
-	if startDate != "" {
-		params.Set("start_timestamp", startDate)
-	}
-	if endDate != "" {
-		params.Set("end_timestamp", endDate)
+// newAPIChannel new-api 渠道，Models 和 Group 为逗号分隔的列表
+type newAPIChannel struct {
+	ID     int    `json:"id"`
+	Status int    `json:"status"` // 1=启用
+	Models string `json:"models"`
+	Group  string `json:"group"`
+}
+
+// newAPIPricing new-api 模型定价
+type newAPIPricing struct {
+	ModelName       string  `json:"model_name"`
+	QuotaType       int     `json:"quota_type"`
+	ModelRatio      float64 `json:"model_ratio"`
+	ModelPrice      float64 `json:"model_price"`
+	CompletionRatio float64 `json:"completion_ratio"`
+}
+
+// newAPIChannelPageSize 分页读取渠道时的每页条数
+const newAPIChannelPageSize = 100
+
+// GetGroupModels 获取分组下启用渠道提供的模型及其倍率（需要管理员权限）
+func (c *NewAPIClient) GetGroupModels(group string) (*NewAPIGroupModels, error) {
+	channels, err := c.getChannels()
+	if err != nil {
+		return nil, err
 	}
 
-	reqURL := fmt.Sprintf("%s/api/log?user_id=%d&%s", c.baseURL, userID, params.Encode())
-	req, _ := http.NewRequest("GET", reqURL, nil)
-	c.addAdminHeader(req)
+	names := make(map[string]bool)
+	for _, channel := range channels {
+		if channel.Status != 1 || !containsItem(channel.Group, group) {
+			continue
+		}
+		for _, name := range strings.Split(channel.Models, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[name] = true
+			}
+		}
+	}
 
-	resp, err := c.httpClient.Do(req)
+	pricing, groupRatios, err := c.getPricing()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
+	result := &NewAPIGroupModels{Group: group, GroupRatio: 1, Models: []NewAPIModel{}}
+	if ratio, ok := groupRatios[group]; ok {
+		result.GroupRatio = ratio
+	}
+	for name := range names {
+		item := NewAPIModel{Name: name, CompletionRatio: 1}
+		if price, ok := pricing[name]; ok {
+			item.QuotaType = price.QuotaType
+			item.ModelRatio = price.ModelRatio
+			item.ModelPrice = price.ModelPrice
+			if price.CompletionRatio > 0 {
+				item.CompletionRatio = price.CompletionRatio
+			}
+		}
+		result.Models = append(result.Models, item)
+	}
+	sort.Slice(result.Models, func(i, j int) bool { return result.Models[i].Name < result.Models[j].Name })
+	return result, nil
+}
+
+// getChannels 获取全部渠道
+func (c *NewAPIClient) getChannels() ([]newAPIChannel, error) {
+	var channels []newAPIChannel
+	for page := 1; ; page++ {
+		respBody, err := c.doAdmin("GET", fmt.Sprintf("/api/channel/?p=%d&page_size=%d", page, newAPIChannelPageSize), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Success bool            `json:"success"`
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		}
+
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			return nil, fmt.Errorf("解析响应失败: %v, body: %s", err, string(respBody))
+		}
+
+		if !result.Success {
+			return nil, fmt.Errorf("获取渠道失败: %s", result.Message)
+		}
+
+		items, err := decodeChannelPage(result.Data)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, items...)
+		if len(items) < newAPIChannelPageSize {
+			return channels, nil
+		}
+	}
+}
+
+// decodeChannelPage 解析渠道分页数据，兼容直接返回数组和 {"items": [...]} 两种格式
+func decodeChannelPage(data json.RawMessage) ([]newAPIChannel, error) {
+	var channels []newAPIChannel
+	if err := json.Unmarshal(data, &channels); err == nil {
+		return channels, nil
+	}
+
+	var page struct {
+		Items []newAPIChannel `json:"items"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("解析渠道失败: %v", err)
+	}
+	return page.Items, nil
+}
+
+// getPricing 获取模型定价（按模型名索引）和分组倍率
+func (c *NewAPIClient) getPricing() (map[string]newAPIPricing, map[string]float64, error) {
+	respBody, err := c.doAdmin("GET", "/api/pricing", nil)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var result struct {
-		Success bool        `json:"success"`
-		Message string      `json:"message"`
-		Data    []NewAPILog `json:"data"`
+		Success    bool               `json:"success"`
+		Message    string             `json:"message"`
+		Data       []newAPIPricing    `json:"data"`
+		GroupRatio map[string]float64 `json:"group_ratio"`
 	}
 
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, fmt.Errorf("解析响应失败: %v", err)
+		return nil, nil, fmt.Errorf("解析响应失败: %v, body: %s", err, string(respBody))
 	}
 
 	if !result.Success {
-		return nil, errors.New(result.Message)
+		return nil, nil, fmt.Errorf("获取模型定价失败: %s", result.Message)
 	}
 
-	return result.Data, nil
+	pricing := make(map[string]newAPIPricing, len(result.Data))
+	for _, item := range result.Data {
+		pricing[item.ModelName] = item
+	}
+	return pricing, result.GroupRatio, nil
 }
 
-// GetUserQuotaUsedToday 获取用户今日已用额度
-func (c *NewAPIClient) GetUserQuotaUsedToday(userID int) (int, error) {
-	if err := c.AdminLogin(); err != nil {
-		return 0, fmt.Errorf("管理员登录失败: %v", err)
+// containsItem 判断逗号分隔的列表 list 中是否包含 item
+func containsItem(list, item string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.TrimSpace(v) == item {
+			return true
+		}
 	}
+	return false
+}
 
-	// 获取今日时间范围
-	now := time.Now()
-	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	endOfDay := startOfDay.Add(24 * time.Hour)
+// NewAPILogQuery new-api 日志查询条件
+type NewAPILogQuery struct {
+	UserID         int
+	Type           int   // 日志类型，0 为全部类型
+	StartTimestamp int64 // 0 为不限
+	EndTimestamp   int64 // 0 为不限
+}
 
-	params := url.Values{}
-	params.Set("user_id", fmt.Sprintf("%d", userID))
-	params.Set("start_timestamp", fmt.Sprintf("%d", startOfDay.Unix()))
-	params.Set("end_timestamp", fmt.Sprintf("%d", endOfDay.Unix()))
+// GetUserLogs 获取用户在时间范围内的全部日志（需要管理员权限），startDate/endDate 为 Unix 时间戳，留空为不限
+func (c *NewAPIClient) GetUserLogs(userID int, startDate, endDate string) ([]NewAPILog, error) {
+	return collectUserLogs(c, userID, startDate, endDate)
+}
 
-	reqURL := fmt.Sprintf("%s/api/log/?%s", c.baseURL, params.Encode())
-	req, _ := http.NewRequest("GET", reqURL, nil)
-	c.addAdminHeader(req)
+// EachUserLog 按页遍历符合条件的日志（按 ID 倒序），fn 返回 false 时停止遍历
+// 范围较大时使用，避免一次性加载全部日志
+func (c *NewAPIClient) EachUserLog(query NewAPILogQuery, fn func(NewAPILog) bool) error {
+	return eachUserLog(c, query, fn)
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return 0, err
+// GetUserLogsPage 获取一页符合条件的日志（需要管理员权限），按 ID 倒序，page 从 1 开始
+func (c *NewAPIClient) GetUserLogsPage(query NewAPILogQuery, page, pageSize int) ([]NewAPILog, error) {
+	params := url.Values{}
+	params.Set("user_id", fmt.Sprintf("%d", query.UserID))
+	params.Set("p", fmt.Sprintf("%d", page))
+	params.Set("page_size", fmt.Sprintf("%d", pageSize))
+	if query.Type != 0 {
+		params.Set("type", fmt.Sprintf("%d", query.Type))
+	}
+	if query.StartTimestamp > 0 {
+		params.Set("start_timestamp", fmt.Sprintf("%d", query.StartTimestamp))
+	}
+	if query.EndTimestamp > 0 {
+		params.Set("end_timestamp", fmt.Sprintf("%d", query.EndTimestamp))
 	}
-	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
+	respBody, err := c.doAdmin("GET", "/api/log/?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
 
 	var result struct {
-		Success bool        `json:"success"`
-		Message string      `json:"message"`
-		Data    []NewAPILog `json:"data"`
+		Success bool            `json:"success"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
 	}
 
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return 0, fmt.Errorf("解析响应失败: %v", err)
+		return nil, fmt.Errorf("解析响应失败: %v", err)
 	}
 
 	if !result.Success {
-		return 0, errors.New(result.Message)
+		return nil, errors.New(result.Message)
+	}
+
+	return decodeLogPage(result.Data)
+}
+
+// decodeLogPage 解析日志分页数据，兼容直接返回数组和 {"items": [...]} 两种格式
+func decodeLogPage(data json.RawMessage) ([]NewAPILog, error) {
+	var logs []NewAPILog
+	if err := json.Unmarshal(data, &logs); err == nil {
+		return logs, nil
+	}
+
+	var page struct {
+		Items []NewAPILog `json:"items"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("解析日志失败: %v", err)
 	}
+	return page.Items, nil
+}
+
+// GetUserQuotaUsedToday 获取用户今日已用额度，今日按 loc 时区计算，只统计消费日志
+func (c *NewAPIClient) GetUserQuotaUsedToday(userID int, loc *time.Location) (int, error) {
+	return quotaUsedToday(c, userID, loc)
+}
+
+// collectUserLogs 通过 backend 读取用户在时间范围内的全部日志
+func collectUserLogs(backend NewAPIBackend, userID int, startDate, endDate string) ([]NewAPILog, error) {
+	query := NewAPILogQuery{UserID: userID}
+	query.StartTimestamp, _ = strconv.ParseInt(startDate, 10, 64)
+	query.EndTimestamp, _ = strconv.ParseInt(endDate, 10, 64)
+
+	var logs []NewAPILog
+	err := backend.EachUserLog(query, func(log NewAPILog) bool {
+		logs = append(logs, log)
+		return true
+	})
+	return logs, err
+}
+
+// eachUserLog 通过 backend 按页遍历日志，每条日志只回调一次
+// new-api 按偏移分页，遍历期间写入的新日志会使后续页后移，同一条日志可能出现在相邻两页
+func eachUserLog(backend NewAPIBackend, query NewAPILogQuery, fn func(NewAPILog) bool) error {
+	seen := make(map[int]bool)
+	for page := 1; ; page++ {
+		logs, err := backend.GetUserLogsPage(query, page, newAPILogPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, log := range logs {
+			// 部分 new-api 版本忽略类型参数，本地再过滤一次
+			if (query.Type != 0 && log.Type != query.Type) || seen[log.ID] {
+				continue
+			}
+			seen[log.ID] = true
+			if !fn(log) {
+				return nil
+			}
+		}
+		if len(logs) < newAPILogPageSize {
+			return nil
+		}
+	}
+}
+
+// quotaUsedToday 通过 backend 统计用户今日的消费额度
+func quotaUsedToday(backend NewAPIBackend, userID int, loc *time.Location) (int, error) {
+	// 统计今日 0 点到现在的日志，截止时间固定后遍历期间写入的日志不会影响分页
+	now := time.Now().In(loc)
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
 
 	// 计算今日已用额度
 	var totalUsed int
-	for _, log := range result.Data {
+	err := backend.EachUserLog(NewAPILogQuery{
+		UserID:         userID,
+		Type:           NewAPILogTypeConsume,
+		StartTimestamp: startOfDay.Unix(),
+		EndTimestamp:   now.Unix(),
+	}, func(log NewAPILog) bool {
 		totalUsed += log.Quota
+		return true
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return totalUsed, nil
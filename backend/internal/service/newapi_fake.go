@@ -0,0 +1,328 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeNewAPI 内存中的 new-api，用于在没有真实 new-api 的情况下测试订单、同步和登录流程
+// 用户、日志和分组在所有客户端间共享，并发安全；可按方法注入错误并统计调用次数
+type FakeNewAPI struct {
+	mu         sync.Mutex
+	users      map[int]*fakeNewAPIUser
+	logs       []NewAPILog
+	groups     []string
+	models     map[string]*NewAPIGroupModels
+	nextUserID int
+	nextLogID  int
+	errors     map[string]error
+	calls      map[string]int
+}
+
+// fakeNewAPIUser 内存中的 new-api 用户
+type fakeNewAPIUser struct {
+	NewAPIUser
+	password string
+}
+
+// NewFakeNewAPI 创建内存 new-api，groups 为可用分组
+func NewFakeNewAPI(groups ...string) *FakeNewAPI {
+	return &FakeNewAPI{
+		users:  make(map[int]*fakeNewAPIUser),
+		groups: groups,
+		models: make(map[string]*NewAPIGroupModels),
+		errors: make(map[string]error),
+		calls:  make(map[string]int),
+	}
+}
+
+// Container 返回使用该 new-api 的服务依赖，配合 SetContainer 使用
+func (f *FakeNewAPI) Container() *Container {
+	return &Container{NewAPI: f.Client}
+}
+
+// Client 创建一个客户端，登录状态在客户端之间相互独立
+func (f *FakeNewAPI) Client() NewAPIBackend {
+	return &fakeNewAPIClient{fake: f}
+}
+
+// AddUser 添加一个已启用的普通用户
+func (f *FakeNewAPI) AddUser(username, password, group string, quota int) NewAPIUser {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addUser(username, password, group, quota).NewAPIUser
+}
+
+// addUser 添加用户，调用方需持有 f.mu
+func (f *FakeNewAPI) addUser(username, password, group string, quota int) *fakeNewAPIUser {
+	f.nextUserID++
+	user := &fakeNewAPIUser{
+		NewAPIUser: NewAPIUser{
+			ID:       f.nextUserID,
+			Username: username,
+			Role:     1,
+			Status:   1,
+			Quota:    quota,
+			Group:    group,
+		},
+		password: password,
+	}
+	f.users[user.ID] = user
+	return user
+}
+
+// User 获取用户当前信息
+func (f *FakeNewAPI) User(userID int) (NewAPIUser, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[userID]
+	if !ok {
+		return NewAPIUser{}, false
+	}
+	return user.NewAPIUser, true
+}
+
+// UserByName 按用户名获取用户
+func (f *FakeNewAPI) UserByName(username string) (NewAPIUser, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.Username == username {
+			return user.NewAPIUser, true
+		}
+	}
+	return NewAPIUser{}, false
+}
+
+// SetQuota 直接修改用户余额，模拟用户在 new-api 上的消费
+func (f *FakeNewAPI) SetQuota(userID, quota int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if user, ok := f.users[userID]; ok {
+		user.Quota = quota
+	}
+}
+
+// AddLog 添加一条日志，未指定时间时使用当前时间，返回分配了 ID 的日志
+func (f *FakeNewAPI) AddLog(log NewAPILog) NewAPILog {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextLogID++
+	log.ID = f.nextLogID
+	if log.CreatedAt == 0 {
+		log.CreatedAt = time.Now().Unix()
+	}
+	f.logs = append(f.logs, log)
+	return log
+}
+
+// SetGroupModels 设置分组的倍率和可用模型
+func (f *FakeNewAPI) SetGroupModels(group string, groupRatio float64, models ...NewAPIModel) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.models[group] = &NewAPIGroupModels{Group: group, GroupRatio: groupRatio, Models: models}
+}
+
+// SetError 让 method（如 "UpdateUser"）之后的调用返回 err，err 为 nil 时恢复正常
+func (f *FakeNewAPI) SetError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	f.errors[method] = err
+}
+
+// Calls 获取 method 被调用的次数
+func (f *FakeNewAPI) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// call 记录一次调用并返回注入的错误，调用方需持有 f.mu
+func (f *FakeNewAPI) call(method string) error {
+	f.calls[method]++
+	return f.errors[method]
+}
+
+// fakeNewAPIClient FakeNewAPI 的客户端，保存登录用户
+type fakeNewAPIClient struct {
+	fake *FakeNewAPI
+	self *NewAPIUser
+}
+
+var _ NewAPIBackend = (*fakeNewAPIClient)(nil)
+
+// AdminLogin 实现 NewAPIBackend
+func (c *fakeNewAPIClient) AdminLogin() error {
+	c.fake.mu.Lock()
+	defer c.fake.mu.Unlock()
+	return c.fake.call("AdminLogin")
+}
+
+// Login 实现 NewAPIBackend，与 new-api 一样拒绝密码错误和已禁用的用户
+func (c *fakeNewAPIClient) Login(username, password string) (*NewAPIUser, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("Login"); err != nil {
+		return nil, err
+	}
+
+	for _, user := range f.users {
+		if user.Username == username && user.password == password && user.Status == 1 {
+			self := user.NewAPIUser
+			c.self = &self
+			return &self, nil
+		}
+	}
+	return nil, errors.New("用户名或密码错误，或用户已被封禁")
+}
+
+// GetSelf 实现 NewAPIBackend
+func (c *fakeNewAPIClient) GetSelf() (*NewAPIUser, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetSelf"); err != nil {
+		return nil, err
+	}
+
+	if c.self == nil {
+		return nil, errors.New("无权进行此操作，未登录且未提供 access token")
+	}
+	return c.self, nil
+}
+
+// GetUser 实现 NewAPIBackend
+func (c *fakeNewAPIClient) GetUser(userID int) (*NewAPIUser, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetUser"); err != nil {
+		return nil, err
+	}
+
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	result := user.NewAPIUser
+	return &result, nil
+}
+
+// UpdateUser 实现 NewAPIBackend，按传入的信息整体覆盖用户（密码除外）
+func (c *fakeNewAPIClient) UpdateUser(user *NewAPIUser) error {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("UpdateUser"); err != nil {
+		return err
+	}
+
+	existing, ok := f.users[user.ID]
+	if !ok {
+		return errors.New("record not found")
+	}
+	existing.NewAPIUser = *user
+	return nil
+}
+
+// CreateUser 实现 NewAPIBackend，用户名重复时失败
+func (c *fakeNewAPIClient) CreateUser(username, password, group string) (*NewAPIUser, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateUser"); err != nil {
+		return nil, err
+	}
+
+	for _, user := range f.users {
+		if user.Username == username {
+			return nil, fmt.Errorf("创建用户失败: 用户名 %s 已存在", username)
+		}
+	}
+	result := f.addUser(username, password, group, 0).NewAPIUser
+	return &result, nil
+}
+
+// GetGroups 实现 NewAPIBackend
+func (c *fakeNewAPIClient) GetGroups() ([]string, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetGroups"); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), f.groups...), nil
+}
+
+// GetGroupModels 实现 NewAPIBackend，未设置的分组没有可用模型
+func (c *fakeNewAPIClient) GetGroupModels(group string) (*NewAPIGroupModels, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetGroupModels"); err != nil {
+		return nil, err
+	}
+
+	models, ok := f.models[group]
+	if !ok {
+		return &NewAPIGroupModels{Group: group, GroupRatio: 1, Models: []NewAPIModel{}}, nil
+	}
+	result := *models
+	result.Models = append([]NewAPIModel{}, models.Models...)
+	return &result, nil
+}
+
+// GetUserLogs 实现 NewAPIBackend
+func (c *fakeNewAPIClient) GetUserLogs(userID int, startDate, endDate string) ([]NewAPILog, error) {
+	return collectUserLogs(c, userID, startDate, endDate)
+}
+
+// EachUserLog 实现 NewAPIBackend
+func (c *fakeNewAPIClient) EachUserLog(query NewAPILogQuery, fn func(NewAPILog) bool) error {
+	return eachUserLog(c, query, fn)
+}
+
+// GetUserLogsPage 实现 NewAPIBackend，与 new-api 一样按 ID 倒序分页
+func (c *fakeNewAPIClient) GetUserLogsPage(query NewAPILogQuery, page, pageSize int) ([]NewAPILog, error) {
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetUserLogsPage"); err != nil {
+		return nil, err
+	}
+
+	var logs []NewAPILog
+	for _, log := range f.logs {
+		if log.UserID != query.UserID ||
+			(query.Type != 0 && log.Type != query.Type) ||
+			(query.StartTimestamp > 0 && log.CreatedAt < query.StartTimestamp) ||
+			(query.EndTimestamp > 0 && log.CreatedAt > query.EndTimestamp) {
+			continue
+		}
+		logs = append(logs, log)
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ID > logs[j].ID })
+
+	start := (page - 1) * pageSize
+	if start >= len(logs) {
+		return nil, nil
+	}
+	end := start + pageSize
+	if end > len(logs) {
+		end = len(logs)
+	}
+	return logs[start:end], nil
+}
+
+// GetUserQuotaUsedToday 实现 NewAPIBackend
+func (c *fakeNewAPIClient) GetUserQuotaUsedToday(userID int, loc *time.Location) (int, error) {
+	return quotaUsedToday(c, userID, loc)
+}
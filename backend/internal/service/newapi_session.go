@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"newapi-subscribe/internal/config"
+)
+
+// newAPIAdminSessionTTL 管理员会话的最长复用时间，过期或请求返回 401 时重新登录
+const newAPIAdminSessionTTL = 12 * time.Hour
+
+// adminSession 进程内共享的 new-api 管理员会话，只在没有会话、会话过期或失效时登录
+type adminSession struct {
+	mu         sync.Mutex
+	cookies    []*http.Cookie
+	expiresAt  time.Time
+	generation int // 每次登录递增，用于判断失效的是否为当前会话
+}
+
+var newAPIAdmin = &adminSession{}
+
+// get 返回当前会话的 cookie，需要时使用 c 登录；并发调用时只登录一次
+func (s *adminSession) get(c *NewAPIClient) ([]*http.Cookie, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cookies != nil && time.Now().Before(s.expiresAt) {
+		return s.cookies, s.generation, nil
+	}
+
+	_, cookies, err := c.postLogin(config.Cfg.NewAPIAdminUser, config.Cfg.NewAPIAdminPass)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.cookies = cookies
+	s.expiresAt = time.Now().Add(newAPIAdminSessionTTL)
+	s.generation++
+	return s.cookies, s.generation, nil
+}
+
+// invalidate 使 generation 对应的会话失效，会话已被其他请求刷新时不做处理
+func (s *adminSession) invalidate(generation int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.generation == generation {
+		s.cookies = nil
+	}
+}
+
+// useAccessToken 是否使用 new-api 访问令牌而不是账号密码进行管理员认证
+func useAccessToken() bool {
+	return config.Cfg.NewAPIAccessToken != ""
+}
+
+// addAdminAuth 添加管理员认证信息，返回使用的会话版本（访问令牌认证时为 0）
+func (c *NewAPIClient) addAdminAuth(req *http.Request) (int, error) {
+	if c.adminID != "" {
+		req.Header.Set("New-Api-User", c.adminID)
+	}
+	if useAccessToken() {
+		req.Header.Set("Authorization", "Bearer "+config.Cfg.NewAPIAccessToken)
+		return 0, nil
+	}
+
+	cookies, generation, err := newAPIAdmin.get(c)
+	if err != nil {
+		return 0, err
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return generation, nil
+}
+
+// doAdmin 以管理员身份发送请求并返回响应内容，会话失效（401）时重新登录并重试一次
+func (c *NewAPIClient) doAdmin(method, path string, body []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		req, _ := http.NewRequest(method, c.baseURL+path, reqBody)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		generation, err := c.addAdminAuth(req)
+		if err != nil {
+			return nil, fmt.Errorf("管理员登录失败: %v", err)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && !useAccessToken() {
+			newAPIAdmin.invalidate(generation)
+			continue
+		}
+		return respBody, nil
+	}
+}
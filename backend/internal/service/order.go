@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// pendingOrderPollWindow 主动查询支付状态的时间范围：待支付订单按创建时间，已取消订单按取消时间
+const pendingOrderPollWindow = 24 * time.Hour
+
+// CancelExpiredOrders 取消超过支付时限的待支付订单
+// 已发起支付的订单先向支付网关确认，时限后才到账的支付转人工审核；查询失败时照常取消，由 PollPendingOrders 继续跟进
+func CancelExpiredOrders() {
+	var orders []model.Order
+	if err := model.DB.Where("status = ? AND expires_at IS NOT NULL AND expires_at < ?", model.OrderStatusPending, time.Now()).
+		Find(&orders).Error; err != nil {
+		log.Printf("查询过期订单失败: %v", err)
+		return
+	}
+
+	var cancelled int64
+	for i := range orders {
+		order := &orders[i]
+		if _, ok := GetPaymentProvider(order.PaymentProvider); ok {
+			if err := CheckOrderPayment(order); err != nil {
+				log.Printf("取消前查询订单 %s 支付状态失败: %v", order.OrderNo, err)
+			} else if order.Status != model.OrderStatusPending {
+				continue
+			}
+		}
+
+		// 以状态为条件取消，期间已通过回调完成的订单不受影响；取消后释放占用的优惠码次数
+		err := model.DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&model.Order{}).
+				Where("id = ? AND status = ?", order.ID, model.OrderStatusPending).
+				Update("status", model.OrderStatusCancelled)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			cancelled++
+			return releaseCoupon(tx, order)
+		})
+		if err != nil {
+			log.Printf("取消过期订单 %s 失败: %v", order.OrderNo, err)
+		}
+	}
+	if cancelled > 0 {
+		log.Printf("已取消 %d 个过期未支付订单", cancelled)
+	}
+}
+
+// FlagOrderForReview 过期订单收到支付时标记为待人工审核，不激活订阅
+// 已取消的订单释放过优惠码次数，收到支付后重新计入
+func FlagOrderForReview(order *model.Order, tradeNo, reason string) error {
+	reclaim := order.Status == model.OrderStatusCancelled && order.CouponID > 0
+	if order.Status == model.OrderStatusPending {
+		order.Status = model.OrderStatusCancelled
+	}
+	order.TradeNo = tradeNo
+	order.NeedsReview = 1
+	order.ReviewReason = reason
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(order).Error; err != nil {
+			return err
+		}
+		if reclaim {
+			return tx.Model(&model.Coupon{}).Where("id = ?", order.CouponID).
+				Update("used_count", gorm.Expr("used_count + 1")).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("订单 %s 已转人工审核: %s, 交易号=%s", order.OrderNo, reason, tradeNo)
+	return nil
+}
+
+// ProcessPaymentResult 处理支付网关确认的支付结果（回调或主动查询）
+func ProcessPaymentResult(order *model.Order, providerName string, result *PaymentResult) error {
+	if !result.Paid {
+		return nil
+	}
+
+	// 支付网关必须与下单网关一致
+	if order.PaymentProvider != "" && order.PaymentProvider != providerName {
+		return fmt.Errorf("支付网关不一致: 下单 %s, 回调 %s", order.PaymentProvider, providerName)
+	}
+
+	// 过期或已取消的订单不再激活，转人工审核
+	if order.Status == model.OrderStatusCancelled ||
+		(order.Status == model.OrderStatusPending && order.IsExpired()) {
+		if order.NeedsReview == 1 {
+			return nil
+		}
+		return FlagOrderForReview(order, result.TradeNo, "订单过期后收到支付")
+	}
+
+	if order.Status != model.OrderStatusPending {
+		return nil
+	}
+
+	// 校验支付金额，网关未返回金额时同样视为不符
+	if math.Abs(result.Amount-order.Amount) > 0.01 {
+		return fmt.Errorf("支付金额不符: 应付 %.2f, 实付 %.2f", order.Amount, result.Amount)
+	}
+
+	if order.PaymentProvider == "" {
+		order.PaymentProvider = providerName
+	}
+
+	return CompleteOrder(order, result.TradeNo)
+}
+
+// CheckOrderPayment 向支付网关查询订单，已支付则完成订单；订单已过期或已取消时转人工审核
+func CheckOrderPayment(order *model.Order) error {
+	if order.PaymentProvider == "" {
+		return errors.New("订单尚未发起支付")
+	}
+
+	provider, ok := GetPaymentProvider(order.PaymentProvider)
+	if !ok {
+		return fmt.Errorf("支付网关 %s 不可用", order.PaymentProvider)
+	}
+
+	result, err := provider.QueryOrder(order)
+	if err != nil {
+		return err
+	}
+
+	return ProcessPaymentResult(order, provider.Name(), result)
+}
+
+// PollPendingOrders 主动查询近期待支付和刚取消的订单，补偿丢失的支付回调
+// 取消后才确认的支付不激活订阅，转人工审核
+func PollPendingOrders() {
+	since := time.Now().Add(-pendingOrderPollWindow)
+	var orders []model.Order
+	model.DB.Where("payment_provider NOT IN ? AND needs_review = ?", []string{"", model.PaymentMethodWallet}, 0).
+		Where(model.DB.Where("status = ? AND created_at > ?", model.OrderStatusPending, since).
+			Or("status = ? AND updated_at > ?", model.OrderStatusCancelled, since)).
+		Find(&orders)
+
+	for i := range orders {
+		if err := CheckOrderPayment(&orders[i]); err != nil {
+			log.Printf("查询订单 %s 支付状态失败: %v", orders[i].OrderNo, err)
+			continue
+		}
+		if orders[i].Status == model.OrderStatusPaid {
+			log.Printf("订单 %s 通过主动查询确认支付", orders[i].OrderNo)
+		}
+	}
+}
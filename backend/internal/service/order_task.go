@@ -0,0 +1,219 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+// orderTaskMu 串行执行 new-api 侧操作，避免并发为同一用户重复创建账号
+var orderTaskMu sync.Mutex
+
+// RunOrderTasks 按顺序执行订单未完成的 new-api 步骤，遇到失败即停止
+func RunOrderTasks(orderID uint) error {
+	orderTaskMu.Lock()
+	defer orderTaskMu.Unlock()
+
+	var tasks []model.OrderTask
+	model.DB.Where("order_id = ? AND status = ?", orderID, model.OrderTaskStatusPending).
+		Order("id ASC").
+		Find(&tasks)
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	var order model.Order
+	if err := model.DB.First(&order, orderID).Error; err != nil {
+		return err
+	}
+
+	// 订单已退款等情况下不再执行
+	if order.Status != model.OrderStatusPaid {
+		model.DB.Model(&model.OrderTask{}).
+			Where("order_id = ? AND status = ?", orderID, model.OrderTaskStatusPending).
+			Updates(map[string]interface{}{
+				"status":     model.OrderTaskStatusFailed,
+				"last_error": "订单状态已变更为 " + order.Status,
+			})
+		return nil
+	}
+
+	client := NewAPI()
+	for i := range tasks {
+		if err := runOrderTask(client, &order, &tasks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryOrderTasks 重试所有未完成的 new-api 步骤
+func RetryOrderTasks() {
+	var orderIDs []uint
+	model.DB.Model(&model.OrderTask{}).
+		Where("status = ?", model.OrderTaskStatusPending).
+		Distinct().
+		Pluck("order_id", &orderIDs)
+
+	for _, orderID := range orderIDs {
+		if err := RunOrderTasks(orderID); err != nil {
+			log.Printf("重试订单 %d 的 new-api 操作失败: %v", orderID, err)
+		}
+	}
+}
+
+// ResetOrderTasks 将订单失败的步骤重置为待执行并立即重试
+func ResetOrderTasks(orderID uint) error {
+	if err := model.DB.Model(&model.OrderTask{}).
+		Where("order_id = ? AND status = ?", orderID, model.OrderTaskStatusFailed).
+		Updates(map[string]interface{}{
+			"status":   model.OrderTaskStatusPending,
+			"attempts": 0,
+		}).Error; err != nil {
+		return err
+	}
+	return RunOrderTasks(orderID)
+}
+
+// runOrderTask 执行单个步骤并记录结果
+func runOrderTask(client NewAPIBackend, order *model.Order, task *model.OrderTask) error {
+	var err error
+	switch task.Step {
+	case model.OrderTaskStepCreateUser:
+		err = taskCreateNewAPIUser(client, order)
+	case model.OrderTaskStepApplyQuota:
+		err = taskApplyNewAPIQuota(client, order, task)
+	case model.OrderTaskStepAddBooster:
+		err = taskAddBoosterQuota(client, order, task)
+	default:
+		err = fmt.Errorf("未知的步骤: %s", task.Step)
+	}
+
+	task.Attempts++
+	if err != nil {
+		task.LastError = err.Error()
+		if task.Attempts >= model.OrderTaskMaxAttempts {
+			task.Status = model.OrderTaskStatusFailed
+		}
+		model.DB.Save(task)
+		return fmt.Errorf("%s: %v", task.Step, err)
+	}
+
+	now := time.Now()
+	task.Status = model.OrderTaskStatusDone
+	task.LastError = ""
+	task.DoneAt = &now
+	model.DB.Save(task)
+	return nil
+}
+
+// claimOrderTaskWrite 在向 new-api 增加额度前标记步骤已写入，已标记过时返回 false
+// 先标记后写入：写入成功但步骤结果未保存时，重试不会重复发放额度
+func claimOrderTaskWrite(task *model.OrderTask) (bool, error) {
+	result := model.DB.Model(&model.OrderTask{}).
+		Where("id = ? AND applied = ?", task.ID, 0).
+		Update("applied", 1)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("订单 %d 的 %s 已写入 new-api，跳过", task.OrderID, task.Step)
+		task.Applied = 1
+		return false, nil
+	}
+	task.Applied = 1
+	return true, nil
+}
+
+// releaseOrderTaskWrite 写入 new-api 失败时撤销标记，允许重试
+func releaseOrderTaskWrite(task *model.OrderTask) {
+	model.DB.Model(&model.OrderTask{}).Where("id = ?", task.ID).Update("applied", 0)
+	task.Applied = 0
+}
+
+// taskCreateNewAPIUser 为未绑定的用户创建 new-api 账号
+func taskCreateNewAPIUser(client NewAPIBackend, order *model.Order) error {
+	var user model.User
+	if err := model.DB.First(&user, order.UserID).Error; err != nil {
+		return err
+	}
+	if user.NewAPIBound == 1 {
+		return nil
+	}
+
+	var plan model.Plan
+	if err := model.DB.First(&plan, order.PlanID).Error; err != nil {
+		return err
+	}
+
+	// 生成随机用户名: 前缀_随机字符串
+	randomUsername := generateRandomUsername()
+	// 生成随机密码
+	randomPassword := generateRandomPassword()
+
+	// 创建新账号
+	newAPIUser, err := client.CreateUser(randomUsername, randomPassword, plan.NewAPIGroup)
+	if err != nil {
+		log.Printf("创建 new-api 账号失败: %v", err)
+		// 尝试使用带时间戳的用户名再次创建
+		randomUsername = fmt.Sprintf("u_%d_%s", time.Now().Unix(), generateRandomString(4))
+		newAPIUser, err = client.CreateUser(randomUsername, randomPassword, plan.NewAPIGroup)
+		if err != nil {
+			return err
+		}
+	}
+
+	user.NewAPIUserID = newAPIUser.ID
+	user.NewAPIUsername = newAPIUser.Username
+	user.NewAPIBound = 1
+	if err := model.SaveUserNewAPIBinding(model.DB, &user); err != nil {
+		return err
+	}
+
+	// 补记试用领取记录的 new-api 账号
+	model.DB.Model(&model.TrialClaim{}).
+		Where("user_id = ? AND newapi_user_id = ?", user.ID, 0).
+		Update("newapi_user_id", newAPIUser.ID)
+	log.Printf("为用户 %d 创建 new-api 账号: %s", user.ID, newAPIUser.Username)
+	return nil
+}
+
+// taskApplyNewAPIQuota 按订阅设置 new-api 初始额度和分组
+func taskApplyNewAPIQuota(client NewAPIBackend, order *model.Order, task *model.OrderTask) error {
+	var user model.User
+	if err := model.DB.First(&user, order.UserID).Error; err != nil {
+		return err
+	}
+	if user.NewAPIBound != 1 {
+		return errors.New("用户尚未绑定 new-api 账号")
+	}
+
+	var subscription model.Subscription
+	if err := model.DB.First(&subscription, order.SubscriptionID).Error; err != nil {
+		return err
+	}
+
+	lock, err := lockUserQuota(user.ID)
+	if err != nil {
+		return err
+	}
+	defer releaseSyncLock(lock)
+
+	// 上次已写入 new-api（只是步骤结果未保存）时不再重复发放
+	if claimed, err := claimOrderTaskWrite(task); err != nil || !claimed {
+		return err
+	}
+
+	// 用户只有这一个订阅时余额设为每日额度，已有其他订阅时在当前余额上叠加（变更套餐时先扣除原套餐本周期的额度）
+	if _, err := adjustUserQuotaLocked(client, &user, subscription.ID, task.ReplacedQuota, subscription.DailyQuota); err != nil {
+		releaseOrderTaskWrite(task)
+		return err
+	}
+
+	log.Printf("用户 %d new-api 额度已增加订阅 %d 的每日额度 %d", user.ID, subscription.ID, subscription.DailyQuota)
+	return nil
+}
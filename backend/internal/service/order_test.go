@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/model"
+)
+
+// fakePaymentProvider 按订单号返回预设支付结果的支付网关
+type fakePaymentProvider struct {
+	paid      map[string]bool
+	refundErr error // Refund 返回的错误
+}
+
+func (p *fakePaymentProvider) Name() string      { return "fakepay" }
+func (p *fakePaymentProvider) Methods() []string { return []string{"alipay"} }
+func (p *fakePaymentProvider) CreatePayment(order *model.Order, method, subject string) (string, error) {
+	return "", nil
+}
+func (p *fakePaymentProvider) VerifyNotify(c *gin.Context) (*PaymentResult, error) {
+	return nil, errors.New("not supported")
+}
+func (p *fakePaymentProvider) NotifyAck(c *gin.Context, ok bool) {}
+func (p *fakePaymentProvider) QueryOrder(order *model.Order) (*PaymentResult, error) {
+	return &PaymentResult{
+		OrderNo: order.OrderNo,
+		TradeNo: "T_" + order.OrderNo,
+		Amount:  order.Amount,
+		Paid:    p.paid[order.OrderNo],
+	}, nil
+}
+func (p *fakePaymentProvider) Refund(order *model.Order, amount float64) error { return p.refundErr }
+
+// setupFakePayment 注册测试支付网关，测试结束后移除
+func setupFakePayment(t *testing.T) *fakePaymentProvider {
+	t.Helper()
+
+	provider := &fakePaymentProvider{paid: make(map[string]bool)}
+	RegisterPaymentProvider(provider)
+	t.Cleanup(func() {
+		paymentProvidersMu.Lock()
+		delete(paymentProviders, provider.Name())
+		paymentProvidersMu.Unlock()
+	})
+	return provider
+}
+
+func TestCancelExpiredOrdersFlagsPaidOrderForReview(t *testing.T) {
+	setupTest(t)
+	provider := setupFakePayment(t)
+	user := createTestUser(t, "expired", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+
+	expired := time.Now().Add(-time.Minute)
+	paid := createTestOrder(t, user, plan)
+	unpaid := createTestOrder(t, user, plan)
+	for _, order := range []*model.Order{paid, unpaid} {
+		if err := model.DB.Model(order).Updates(map[string]interface{}{
+			"payment_provider": provider.Name(),
+			"expires_at":       &expired,
+		}).Error; err != nil {
+			t.Fatalf("更新订单失败: %v", err)
+		}
+	}
+	provider.paid[paid.OrderNo] = true
+
+	CancelExpiredOrders()
+
+	model.DB.First(paid, paid.ID)
+	if paid.Status != model.OrderStatusCancelled || paid.NeedsReview != 1 {
+		t.Fatalf("已支付的过期订单 status=%s needs_review=%d, want cancelled/1", paid.Status, paid.NeedsReview)
+	}
+	model.DB.First(unpaid, unpaid.ID)
+	if unpaid.Status != model.OrderStatusCancelled || unpaid.NeedsReview != 0 {
+		t.Fatalf("未支付的过期订单 status=%s needs_review=%d, want cancelled/0", unpaid.Status, unpaid.NeedsReview)
+	}
+}
+
+func TestPollPendingOrdersChecksRecentlyCancelledOrders(t *testing.T) {
+	setupTest(t)
+	provider := setupFakePayment(t)
+	user := createTestUser(t, "cancelled", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+
+	order := createTestOrder(t, user, plan)
+	if err := model.DB.Model(order).Updates(map[string]interface{}{
+		"payment_provider": provider.Name(),
+		"status":           model.OrderStatusCancelled,
+	}).Error; err != nil {
+		t.Fatalf("更新订单失败: %v", err)
+	}
+
+	// 取消后支付网关才确认到账
+	provider.paid[order.OrderNo] = true
+	PollPendingOrders()
+
+	model.DB.First(order, order.ID)
+	if order.Status != model.OrderStatusCancelled || order.NeedsReview != 1 {
+		t.Fatalf("取消后到账的订单 status=%s needs_review=%d, want cancelled/1", order.Status, order.NeedsReview)
+	}
+	var count int64
+	model.DB.Model(&model.Subscription{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("转人工审核的订单不应激活订阅，实际创建 %d 个订阅", count)
+	}
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+// PauseSubscription 暂停订阅，暂停期间扣除该订阅的 new-api 额度且不参与每日同步
+func PauseSubscription(sub *model.Subscription) error {
+	if sub.Status != model.SubscriptionStatusActive {
+		return errors.New("只能暂停生效中的订阅")
+	}
+
+	allowance, err := pauseAllowance(sub)
+	if err != nil {
+		return err
+	}
+	if allowance <= 0 {
+		return errors.New("本计费周期的暂停天数已用完")
+	}
+
+	today := model.LocalDate(time.Now(), model.UserLocation(sub.UserID))
+	result := model.DB.Model(&model.Subscription{}).
+		Where("id = ? AND status = ?", sub.ID, model.SubscriptionStatusActive).
+		Updates(map[string]interface{}{
+			"status":    model.SubscriptionStatusPaused,
+			"paused_at": &today,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订阅状态已变更，请刷新后重试")
+	}
+
+	// 扣除该订阅本周期的剩余额度（没有其他订阅时清零），失败时撤销暂停
+	delta, err := adjustUserQuota(NewAPI(), sub.UserID, sub.ID, -sub.TodayQuota)
+	if err != nil {
+		model.DB.Model(&model.Subscription{}).
+			Where("id = ?", sub.ID).
+			Updates(map[string]interface{}{
+				"status":    model.SubscriptionStatusActive,
+				"paused_at": nil,
+			})
+		return fmt.Errorf("清零 new-api 额度失败: %v", err)
+	}
+
+	// 记录扣除的额度，恢复时原样退回而不是重新发放
+	pausedQuota := -delta
+	if err := model.DB.Model(&model.Subscription{}).Where("id = ?", sub.ID).
+		Update("paused_quota", pausedQuota).Error; err != nil {
+		log.Printf("记录订阅 %d 暂停时扣除的额度 %d 失败: %v", sub.ID, pausedQuota, err)
+	}
+
+	sub.Status = model.SubscriptionStatusPaused
+	sub.PausedAt = &today
+	sub.PausedQuota = pausedQuota
+	log.Printf("订阅 %d 已暂停，本周期剩余可暂停 %d 天", sub.ID, allowance)
+	return nil
+}
+
+// ResumeSubscription 恢复订阅，按暂停天数顺延到期日并退回暂停时扣除的 new-api 额度
+// 每次暂停至少计 1 天，当天暂停又恢复同样占用可暂停天数；暂停期间进入新额度周期时由下次同步按结转规则发放
+func ResumeSubscription(sub *model.Subscription) error {
+	if sub.Status != model.SubscriptionStatusPaused || sub.PausedAt == nil {
+		return errors.New("订阅未处于暂停状态")
+	}
+
+	// 套餐已不支持暂停时不再限制顺延天数，避免订阅无法恢复
+	allowance, err := pauseAllowance(sub)
+
+	today := model.LocalDate(time.Now(), model.UserLocation(sub.UserID))
+	days := int(today.Sub(*sub.PausedAt).Hours() / 24)
+	if days < 1 {
+		days = 1
+	}
+	if err == nil && days > allowance {
+		days = allowance
+	}
+
+	endDate := sub.EndDate.AddDate(0, 0, days)
+	result := model.DB.Model(&model.Subscription{}).
+		Where("id = ? AND status = ?", sub.ID, model.SubscriptionStatusPaused).
+		Updates(map[string]interface{}{
+			"status":       model.SubscriptionStatusActive,
+			"paused_at":    nil,
+			"paused_days":  sub.PausedDays + days,
+			"paused_quota": 0,
+			"end_date":     endDate,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订阅状态已变更，请刷新后重试")
+	}
+
+	pausedQuota := sub.PausedQuota
+	sub.Status = model.SubscriptionStatusActive
+	sub.PausedAt = nil
+	sub.PausedDays += days
+	sub.PausedQuota = 0
+	sub.EndDate = endDate
+
+	log.Printf("订阅 %d 已恢复，暂停 %d 天，到期日顺延至 %s，退回额度 %d", sub.ID, days, endDate.Format("2006-01-02"), pausedQuota)
+
+	if _, err := adjustUserQuota(NewAPI(), sub.UserID, sub.ID, pausedQuota); err != nil {
+		return fmt.Errorf("订阅已恢复，但退回 new-api 额度失败（将在下一个额度周期同步时发放）: %v", err)
+	}
+	return nil
+}
+
+// ResumeOverduePauses 自动恢复暂停天数已达到套餐上限的订阅，暂停天数按用户时区计算
+func ResumeOverduePauses(now time.Time) {
+	var subscriptions []model.Subscription
+	model.DB.Where("status = ?", model.SubscriptionStatusPaused).Find(&subscriptions)
+
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if sub.PausedAt == nil {
+			continue
+		}
+		allowance, err := pauseAllowance(sub)
+		if err != nil {
+			allowance = 0
+		}
+		today := model.LocalDate(now, model.UserLocation(sub.UserID))
+		if int(today.Sub(*sub.PausedAt).Hours()/24) < allowance {
+			continue
+		}
+		if err := ResumeSubscription(sub); err != nil {
+			log.Printf("自动恢复订阅 %d 失败: %v", sub.ID, err)
+		}
+	}
+}
+
+// pauseAllowance 本计费周期剩余可暂停天数
+func pauseAllowance(sub *model.Subscription) (int, error) {
+	var plan model.Plan
+	if err := model.DB.Unscoped().First(&plan, sub.PlanID).Error; err != nil {
+		return 0, errors.New("套餐不存在")
+	}
+	if plan.MaxPauseDays <= 0 {
+		return 0, errors.New("该套餐不支持暂停")
+	}
+	return plan.MaxPauseDays - sub.PausedDays, nil
+}
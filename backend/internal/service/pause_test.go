@@ -0,0 +1,43 @@
+package service
+
+import (
+	"testing"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestPauseResumeRestoresRemainingQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("kim", "password", "default", 0)
+	user := createTestUser(t, "kim", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	plan.MaxPauseDays = 3
+	model.DB.Save(plan)
+	order := createTestOrder(t, user, plan)
+	if err := CompleteOrder(order, "trade-6"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	// 用掉大部分额度后当天暂停再恢复，只能拿回剩余的额度
+	fake.SetQuota(newAPIUser.ID, 200)
+	var sub model.Subscription
+	model.DB.First(&sub, order.SubscriptionID)
+	if err := PauseSubscription(&sub); err != nil {
+		t.Fatalf("PauseSubscription: %v", err)
+	}
+	if got, _ := fake.User(newAPIUser.ID); got.Quota != 0 || sub.PausedQuota != 200 {
+		t.Fatalf("暂停后余额 = %d, 记录的额度 = %d", got.Quota, sub.PausedQuota)
+	}
+
+	model.DB.First(&sub, sub.ID)
+	if err := ResumeSubscription(&sub); err != nil {
+		t.Fatalf("ResumeSubscription: %v", err)
+	}
+	if got, _ := fake.User(newAPIUser.ID); got.Quota != 200 {
+		t.Errorf("恢复后余额 = %d，期望退回 200", got.Quota)
+	}
+	model.DB.First(&sub, sub.ID)
+	if sub.PausedDays != 1 || sub.PausedQuota != 0 {
+		t.Errorf("已暂停天数 = %d, 暂停额度 = %d，期望 1, 0", sub.PausedDays, sub.PausedQuota)
+	}
+}
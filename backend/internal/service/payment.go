@@ -0,0 +1,128 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
+)
+
+// PaymentResult 支付网关返回的订单支付结果（回调或主动查询）
+type PaymentResult struct {
+	OrderNo string  `json:"order_no"`
+	TradeNo string  `json:"trade_no"`
+	Amount  float64 `json:"amount"`
+	Paid    bool    `json:"paid"`
+}
+
+// PaymentProvider 支付网关接口
+type PaymentProvider interface {
+	// Name 网关标识，同时用于回调路由 /api/orders/notify/:provider
+	Name() string
+	// Methods 支持的支付方式，如 alipay/wxpay
+	Methods() []string
+	// CreatePayment 创建支付，返回支付跳转地址
+	CreatePayment(order *model.Order, method, subject string) (string, error)
+	// VerifyNotify 校验并解析支付回调
+	VerifyNotify(c *gin.Context) (*PaymentResult, error)
+	// NotifyAck 按网关要求应答回调
+	NotifyAck(c *gin.Context, ok bool)
+	// QueryOrder 主动查询订单支付状态
+	QueryOrder(order *model.Order) (*PaymentResult, error)
+	// Refund 原路退款
+	Refund(order *model.Order, amount float64) error
+}
+
+var (
+	paymentProviders   = make(map[string]PaymentProvider)
+	paymentProvidersMu sync.RWMutex
+)
+
+// InitPaymentProviders 根据配置注册支付网关
+func InitPaymentProviders() {
+	if config.Cfg.EpayURL != "" && config.Cfg.EpayPID != "" && config.Cfg.EpayKey != "" {
+		RegisterPaymentProvider(NewEpayService())
+	}
+}
+
+// RegisterPaymentProvider 注册支付网关
+func RegisterPaymentProvider(p PaymentProvider) {
+	paymentProvidersMu.Lock()
+	defer paymentProvidersMu.Unlock()
+	paymentProviders[p.Name()] = p
+}
+
+// GetPaymentProvider 按名称获取支付网关
+func GetPaymentProvider(name string) (PaymentProvider, bool) {
+	paymentProvidersMu.RLock()
+	defer paymentProvidersMu.RUnlock()
+	p, ok := paymentProviders[name]
+	return p, ok
+}
+
+// GetPaymentProviderByMethod 按支付方式查找支付网关
+func GetPaymentProviderByMethod(method string) (PaymentProvider, bool) {
+	for _, p := range ListPaymentProviders() {
+		if providerSupports(p, method) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ListPaymentProviders 获取已注册的支付网关（按名称排序）
+func ListPaymentProviders() []PaymentProvider {
+	paymentProvidersMu.RLock()
+	defer paymentProvidersMu.RUnlock()
+
+	providers := make([]PaymentProvider, 0, len(paymentProviders))
+	for _, p := range paymentProviders {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name() < providers[j].Name()
+	})
+	return providers
+}
+
+// ResolvePaymentProvider 根据指定网关或支付方式选择支付网关
+func ResolvePaymentProvider(name, method string) (PaymentProvider, bool) {
+	if name == "" {
+		return GetPaymentProviderByMethod(method)
+	}
+	p, ok := GetPaymentProvider(name)
+	if !ok || !providerSupports(p, method) {
+		return nil, false
+	}
+	return p, true
+}
+
+func providerSupports(p PaymentProvider, method string) bool {
+	for _, m := range p.Methods() {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// paymentNotifyURL 支付网关回调地址
+func paymentNotifyURL(provider string) string {
+	return siteBaseURL() + "/api/orders/notify/" + provider
+}
+
+// paymentReturnURL 支付完成后的跳转地址
+func paymentReturnURL() string {
+	return siteBaseURL() + "/user/orders"
+}
+
+// siteBaseURL 本系统对外地址，未配置时沿用 new-api 地址
+func siteBaseURL() string {
+	if config.Cfg.SiteURL != "" {
+		return strings.TrimSuffix(config.Cfg.SiteURL, "/")
+	}
+	return strings.TrimSuffix(config.Cfg.NewAPIURL, "/")
+}
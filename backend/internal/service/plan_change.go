@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+// PlanChangeQuote 套餐变更报价
+type PlanChangeQuote struct {
+	OrderType     string  `json:"order_type"` // upgrade/downgrade
+	FromPlanID    uint    `json:"from_plan_id"`
+	ToPlanID      uint    `json:"to_plan_id"`
+	PeriodDays    int     `json:"period_days"`
+	RemainingDays int     `json:"remaining_days"`
+	Price         float64 `json:"price"`         // 新套餐价格
+	CreditAmount  float64 `json:"credit_amount"` // 当前订阅剩余价值抵扣
+	WalletCredit  float64 `json:"wallet_credit"` // 剩余价值超出新套餐价格的部分，变更完成后退回钱包
+	Amount        float64 `json:"amount"`        // 应付金额
+}
+
+// SubscriptionRemainingValue 计算订阅剩余天数的价值
+// 按当前套餐最近一笔已支付订单抵扣前的日均价格折算，无关联订单时按套餐标价
+func SubscriptionRemainingValue(sub *model.Subscription, today time.Time) (float64, int) {
+	remaining := int(sub.EndDate.Sub(today).Hours() / 24)
+	if remaining <= 0 {
+		return 0, 0
+	}
+
+	var dailyPrice float64
+	var last model.Order
+	if err := model.DB.Where("subscription_id = ? AND plan_id = ? AND status = ? AND period_days > 0",
+		sub.ID, sub.PlanID, model.OrderStatusPaid).
+		Order("id DESC").
+		First(&last).Error; err == nil {
+		dailyPrice = (last.Amount + last.CreditAmount) / float64(last.PeriodDays)
+	} else {
+		var plan model.Plan
+		if err := model.DB.Unscoped().First(&plan, sub.PlanID).Error; err == nil {
+			dailyPrice = plan.DailyPrice()
+		}
+	}
+
+	value := dailyPrice * float64(remaining)
+	return math.Round(value*100) / 100, remaining
+}
+
+// QuotePlanChange 计算从当前订阅变更到新套餐的报价
+func QuotePlanChange(sub *model.Subscription, plan *model.Plan, periodDays int) (*PlanChangeQuote, error) {
+	if sub.PlanID == plan.ID {
+		return nil, errors.New("新套餐与当前套餐相同，请使用续费")
+	}
+
+	var currentPlan model.Plan
+	if err := model.DB.Unscoped().First(&currentPlan, sub.PlanID).Error; err != nil {
+		return nil, errors.New("当前订阅的套餐不存在")
+	}
+
+	today := model.LocalDate(time.Now(), model.UserLocation(sub.UserID))
+	credit, remaining := SubscriptionRemainingValue(sub, today)
+	price := plan.CalculatePrice(periodDays)
+
+	quote := &PlanChangeQuote{
+		OrderType:     planChangeType(&currentPlan, plan),
+		FromPlanID:    currentPlan.ID,
+		ToPlanID:      plan.ID,
+		PeriodDays:    periodDays,
+		RemainingDays: remaining,
+		Price:         price,
+	}
+	quote.CreditAmount = math.Min(credit, price)
+	quote.WalletCredit = math.Round((credit-quote.CreditAmount)*100) / 100
+	quote.Amount = math.Round((price-quote.CreditAmount)*100) / 100
+	return quote, nil
+}
+
+// ApplyPlanChange 将订单设置为套餐变更订单，并用当前订阅剩余价值抵扣金额，超出应付金额的部分记入 WalletCredit
+// 需在 ApplyCoupon 之后调用，抵扣基于优惠后金额
+func ApplyPlanChange(order *model.Order, sub *model.Subscription, plan *model.Plan) error {
+	quote, err := QuotePlanChange(sub, plan, order.PeriodDays)
+	if err != nil {
+		return err
+	}
+
+	credit := quote.CreditAmount + quote.WalletCredit
+	order.OrderType = quote.OrderType
+	order.SubscriptionID = sub.ID
+	order.CreditAmount = math.Min(credit, order.Amount)
+	order.WalletCredit = math.Round((credit-order.CreditAmount)*100) / 100
+	order.Amount = math.Round((order.Amount-order.CreditAmount)*100) / 100
+	return nil
+}
+
+// planChangeType 按日均标价判断升级或降级，价格相同时比较每日额度
+func planChangeType(from, to *model.Plan) string {
+	fromPrice, toPrice := from.DailyPrice(), to.DailyPrice()
+	if toPrice > fromPrice || (toPrice == fromPrice && to.DailyQuota >= from.DailyQuota) {
+		return model.OrderTypeUpgrade
+	}
+	return model.OrderTypeDowngrade
+}
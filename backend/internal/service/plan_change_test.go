@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestDowngradeCreditsExcessToWallet(t *testing.T) {
+	fake := setupTest(t)
+	apiUser := fake.AddUser("downgrade", "secret", "default", 0)
+	user := createTestUser(t, "downgrade", apiUser.ID)
+
+	// 当前套餐 30 天 30 元，订单抵扣前金额为 30 元（实付 10 元，抵扣 20 元）
+	from := createTestPlan(t, 1000, 0, 0)
+	model.DB.Model(from).Update("price", 30)
+	paid := createTestOrder(t, user, from)
+	if err := CompleteOrder(paid, "trade-from"); err != nil {
+		t.Fatalf("完成订单失败: %v", err)
+	}
+	model.DB.Model(paid).Updates(map[string]interface{}{"amount": 10, "credit_amount": 20})
+
+	var sub model.Subscription
+	if err := model.DB.Where("user_id = ?", user.ID).First(&sub).Error; err != nil {
+		t.Fatalf("查询订阅失败: %v", err)
+	}
+
+	// 新套餐 30 天 6 元，剩余 30 天价值 30 元，抵扣 6 元后剩余 24 元退回钱包
+	to := createTestPlan(t, 500, 0, 0)
+	model.DB.Model(to).Update("price", 6)
+	quote, err := QuotePlanChange(&sub, to, to.PeriodDays)
+	if err != nil {
+		t.Fatalf("计算报价失败: %v", err)
+	}
+	if quote.OrderType != model.OrderTypeDowngrade || quote.CreditAmount != 6 || quote.WalletCredit != 24 || quote.Amount != 0 {
+		t.Fatalf("报价 = %+v, want downgrade/抵扣 6/退回 24/应付 0", quote)
+	}
+
+	order := createTestOrder(t, user, to)
+	if err := ApplyPlanChange(order, &sub, to); err != nil {
+		t.Fatalf("设置变更订单失败: %v", err)
+	}
+	model.DB.Save(order)
+	if err := CompleteOrder(order, ""); err != nil {
+		t.Fatalf("完成变更订单失败: %v", err)
+	}
+
+	var balance float64
+	model.DB.Model(&model.User{}).Where("id = ?", user.ID).Select("balance").Scan(&balance)
+	if balance != 24 {
+		t.Fatalf("钱包余额 = %.2f, want 24", balance)
+	}
+}
+
+func TestPlanChangeReplacesCurrentWindowQuota(t *testing.T) {
+	fake := setupTest(t)
+	apiUser := fake.AddUser("upgrade", "secret", "default", 2000)
+	user := createTestUser(t, "upgrade", apiUser.ID)
+	from := createTestPlan(t, 1000, 0, 0)
+	createSyncedSubscription(t, user, from, localToday(user).AddDate(0, 0, 10))
+	sub := createSyncedSubscription(t, user, from, localToday(user).AddDate(0, 0, 10))
+
+	// 还有其他订阅时，先扣除原套餐本周期的 1000 再叠加新套餐的 3000
+	to := createTestPlan(t, 3000, 0, 0)
+	order := createTestOrder(t, user, to)
+	if err := ApplyPlanChange(order, sub, to); err != nil {
+		t.Fatalf("设置变更订单失败: %v", err)
+	}
+	order.PaymentMethod = model.PaymentMethodWallet
+	model.DB.Save(order)
+	if err := CompleteOrder(order, ""); err != nil {
+		t.Fatalf("完成变更订单失败: %v", err)
+	}
+
+	got, _ := fake.User(apiUser.ID)
+	if got.Quota != 4000 {
+		t.Errorf("new-api 余额 = %d, want 4000", got.Quota)
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+const (
+	// groupModelsCacheTTL 分组模型列表的缓存时间，new-api 渠道或倍率调整后最多延迟这么久生效
+	groupModelsCacheTTL = 10 * time.Minute
+	// newAPIQuotaPerUnit new-api 中 1 美元对应的额度；模型倍率为 1 时每个 token 消耗 1 额度
+	newAPIQuotaPerUnit = 500000
+)
+
+// groupModelsEntry 缓存的分组模型列表
+type groupModelsEntry struct {
+	models    *NewAPIGroupModels
+	expiresAt time.Time
+}
+
+var (
+	groupModelsCache   = make(map[string]groupModelsEntry)
+	groupModelsCacheMu sync.Mutex
+)
+
+// PlanModel 套餐可用的模型，以及每日额度大约可以使用的量
+type PlanModel struct {
+	NewAPIModel
+	DailyTokens   int `json:"daily_tokens"`   // 按量计费时每日额度全部用于输入约可使用的 token 数，倍率未配置时为 0
+	DailyRequests int `json:"daily_requests"` // 按次计费时每日额度约可请求的次数
+}
+
+// PlanModels 套餐分组下可用的模型
+type PlanModels struct {
+	Group      string      `json:"group"`
+	GroupRatio float64     `json:"group_ratio"`
+	DailyQuota int         `json:"daily_quota"`
+	Models     []PlanModel `json:"models"`
+}
+
+// GetGroupModels 获取分组可用的模型，结果缓存 groupModelsCacheTTL；刷新失败时沿用过期的缓存
+func GetGroupModels(group string) (*NewAPIGroupModels, error) {
+	groupModelsCacheMu.Lock()
+	entry, ok := groupModelsCache[group]
+	groupModelsCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.models, nil
+	}
+
+	models, err := NewAPI().GetGroupModels(group)
+	if err != nil {
+		if ok {
+			return entry.models, nil
+		}
+		return nil, err
+	}
+
+	groupModelsCacheMu.Lock()
+	groupModelsCache[group] = groupModelsEntry{
+		models:    models,
+		expiresAt: time.Now().Add(groupModelsCacheTTL),
+	}
+	groupModelsCacheMu.Unlock()
+	return models, nil
+}
+
+// GetPlanModels 获取套餐分组可用的模型，并按模型和分组倍率估算每日额度可使用的 token 数或请求次数
+func GetPlanModels(plan *model.Plan) (*PlanModels, error) {
+	groupModels, err := GetGroupModels(plan.NewAPIGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PlanModels{
+		Group:      groupModels.Group,
+		GroupRatio: groupModels.GroupRatio,
+		DailyQuota: plan.DailyQuota,
+		Models:     make([]PlanModel, 0, len(groupModels.Models)),
+	}
+	for _, item := range groupModels.Models {
+		planModel := PlanModel{NewAPIModel: item}
+		if item.QuotaType == 1 {
+			if perRequest := item.ModelPrice * newAPIQuotaPerUnit * groupModels.GroupRatio; perRequest > 0 {
+				planModel.DailyRequests = int(float64(plan.DailyQuota) / perRequest)
+			}
+		} else if perToken := item.ModelRatio * groupModels.GroupRatio; perToken > 0 {
+			planModel.DailyTokens = int(float64(plan.DailyQuota) / perToken)
+		}
+		result.Models = append(result.Models, planModel)
+	}
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/config"
+)
+
+func TestGetPlanModelsEstimatesDailyUsage(t *testing.T) {
+	fake := setupTest(t)
+	fake.SetGroupModels("vip", 2,
+		NewAPIModel{Name: "gpt-4o", ModelRatio: 1.25, CompletionRatio: 4},
+		NewAPIModel{Name: "mj-imagine", QuotaType: 1, ModelPrice: 0.1},
+		NewAPIModel{Name: "unpriced", CompletionRatio: 1},
+	)
+	plan := createTestPlan(t, 500000, 0, 0)
+
+	models, err := GetPlanModels(plan)
+	if err != nil {
+		t.Fatalf("GetPlanModels: %v", err)
+	}
+	if len(models.Models) != 3 || models.GroupRatio != 2 || models.DailyQuota != 500000 {
+		t.Fatalf("模型列表 = %+v", models)
+	}
+
+	want := map[string][2]int{
+		"gpt-4o":     {200000, 0}, // 500000 / (1.25 × 2)
+		"mj-imagine": {0, 5},      // 500000 / (0.1 × 500000 × 2)
+		"unpriced":   {0, 0},
+	}
+	for _, m := range models.Models {
+		if got := [2]int{m.DailyTokens, m.DailyRequests}; got != want[m.Name] {
+			t.Errorf("%s 每日 token/请求数 = %v，期望 %v", m.Name, got, want[m.Name])
+		}
+	}
+}
+
+func TestGetGroupModelsCachesResult(t *testing.T) {
+	fake := setupTest(t)
+	fake.SetGroupModels("vip", 1, NewAPIModel{Name: "gpt-4o", ModelRatio: 1.25})
+
+	for i := 0; i < 3; i++ {
+		if _, err := GetGroupModels("vip"); err != nil {
+			t.Fatalf("GetGroupModels: %v", err)
+		}
+	}
+	if calls := fake.Calls("GetGroupModels"); calls != 1 {
+		t.Errorf("GetGroupModels 请求 new-api %d 次，期望 1 次", calls)
+	}
+
+	// 缓存过期后 new-api 不可用时沿用旧结果
+	entry := groupModelsCache["vip"]
+	entry.expiresAt = time.Now().Add(-time.Second)
+	groupModelsCache["vip"] = entry
+	fake.SetError("GetGroupModels", errors.New("connection refused"))
+
+	models, err := GetGroupModels("vip")
+	if err != nil || len(models.Models) != 1 {
+		t.Fatalf("刷新失败时应返回旧缓存: %+v, %v", models, err)
+	}
+	if _, err := GetGroupModels("default"); err == nil {
+		t.Error("没有缓存时应返回错误")
+	}
+}
+
+func TestNewAPIClientGetGroupModels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "admin"})
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": NewAPIUser{ID: 1}})
+	})
+	mux.HandleFunc("/api/channel/", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data": map[string]interface{}{"items": []newAPIChannel{
+				{ID: 1, Status: 1, Group: "default,vip", Models: "gpt-4o, gpt-4o-mini"},
+				{ID: 2, Status: 1, Group: "vip", Models: "claude-3-5-sonnet,gpt-4o"},
+				{ID: 3, Status: 2, Group: "vip", Models: "disabled-model"},
+				{ID: 4, Status: 1, Group: "default", Models: "default-only"},
+			}},
+		})
+	})
+	mux.HandleFunc("/api/pricing", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data": []newAPIPricing{
+				{ModelName: "gpt-4o", ModelRatio: 1.25, CompletionRatio: 4},
+				{ModelName: "gpt-4o-mini", ModelRatio: 0.075, CompletionRatio: 4},
+			},
+			"group_ratio": map[string]float64{"default": 1, "vip": 1.5},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config.Cfg = &config.Config{NewAPIURL: server.URL, NewAPIAdminUser: "root", NewAPIAdminPass: "123456"}
+	newAPIAdmin = &adminSession{}
+	defer func() { newAPIAdmin = &adminSession{} }()
+
+	models, err := NewNewAPIClient().GetGroupModels("vip")
+	if err != nil {
+		t.Fatalf("GetGroupModels: %v", err)
+	}
+	if models.GroupRatio != 1.5 {
+		t.Errorf("分组倍率 = %v，期望 1.5", models.GroupRatio)
+	}
+
+	var names []string
+	for _, m := range models.Models {
+		names = append(names, m.Name)
+	}
+	if want := "[claude-3-5-sonnet gpt-4o gpt-4o-mini]"; fmt.Sprint(names) != want {
+		t.Fatalf("模型 = %v，期望 %s", names, want)
+	}
+	if m := models.Models[1]; m.ModelRatio != 1.25 || m.CompletionRatio != 4 {
+		t.Errorf("gpt-4o 倍率 = %+v", m)
+	}
+	if m := models.Models[0]; m.ModelRatio != 0 || m.CompletionRatio != 1 {
+		t.Errorf("未定价模型倍率 = %+v", m)
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+// quotaStack 用户多个订阅叠加后的额度
+type quotaStack struct {
+	Quota        int          // 写入 new-api 的余额
+	Carried      int          // 结转额度
+	Booster      int          // 保留的加油包额度，不计入结转
+	BoosterCarry int          // 其中可跨日保留的加油包额度
+	Group        string       // 写入 new-api 的分组
+	PrimaryID    uint         // 决定分组的订阅，加油包额度记在该订阅上
+	CarriedID    uint         // 结转额度记在该订阅上
+	Granted      map[uint]int // 进入新额度周期的订阅及本期发放的额度
+	Frozen       bool         // 进入新周期的订阅均处于冻结额度的宽限期
+}
+
+// stackSubscriptionQuota 叠加用户各订阅的额度，只有进入新额度周期的订阅重新发放额度
+// 剩余额度依次视为未用完的加油包额度、未进入新周期的订阅的本期额度，其余按进入新周期的订阅的结转规则结转；
+// 结转上限为支持结转的订阅的上限之和，任一订阅不限结转时不设上限；
+// 宽限期内的订阅按 gracePercent 发放额度，为 0 时不发放；
+// expireBoosters 为 true 时（每日同步）加油包只保留可跨日的部分
+func stackSubscriptionQuota(subs []*model.Subscription, now time.Time, remaining, gracePercent int, expireBoosters bool) *quotaStack {
+	stack := &quotaStack{Granted: make(map[uint]int)}
+	remaining = max(remaining, 0)
+
+	booster, boosterCarry := 0, 0
+	for _, sub := range subs {
+		booster += sub.BoosterQuota + sub.BoosterCarryQuota
+		boosterCarry += sub.BoosterCarryQuota
+	}
+	boosterLeft := min(remaining, booster)
+	remaining -= boosterLeft
+	stack.BoosterCarry = min(boosterLeft, boosterCarry)
+	stack.Booster = boosterLeft
+	if expireBoosters {
+		stack.Booster = stack.BoosterCarry
+	}
+
+	var due []*model.Subscription
+	held := 0
+	for _, sub := range subs {
+		if sub.WindowDue(now) {
+			due = append(due, sub)
+		} else {
+			held += sub.TodayQuota
+		}
+	}
+	held = min(remaining, held)
+	remaining -= held
+
+	stack.Frozen = len(due) > 0
+	carryOver, unlimited, maxCarry := false, false, 0
+	for _, sub := range due {
+		granted := sub.DailyQuota
+		if sub.Status == model.SubscriptionStatusGrace {
+			if gracePercent <= 0 {
+				granted = 0
+			} else {
+				stack.Frozen = false
+				if gracePercent < 100 {
+					granted = sub.DailyQuota * gracePercent / 100
+				}
+			}
+		} else {
+			stack.Frozen = false
+		}
+		stack.Granted[sub.ID] = granted
+		stack.Quota += granted
+
+		if sub.CarryOver == 1 {
+			carryOver = true
+			if sub.MaxCarryOver > 0 {
+				maxCarry += sub.MaxCarryOver
+			} else {
+				unlimited = true
+			}
+		}
+	}
+
+	if carryOver && remaining > 0 {
+		stack.Carried = remaining
+		if !unlimited && stack.Carried > maxCarry {
+			stack.Carried = maxCarry
+		}
+	}
+	stack.Quota += stack.Carried + stack.Booster + held
+
+	if primary := primarySubscription(subs); primary != nil {
+		stack.PrimaryID = primary.ID
+		stack.Group = primary.NewAPIGroup
+	}
+	if primary := primarySubscription(due); primary != nil {
+		stack.CarriedID = primary.ID
+	}
+	return stack
+}
+
+// PrimarySubscription 按套餐分组优先级选出决定 new-api 分组的订阅
+func PrimarySubscription(subs []model.Subscription) *model.Subscription {
+	ptrs := make([]*model.Subscription, len(subs))
+	for i := range subs {
+		ptrs[i] = &subs[i]
+	}
+	return primarySubscription(ptrs)
+}
+
+// primarySubscription 分组优先级最高的订阅；优先级相同时依次比较是否生效中、每日额度和订阅 ID
+func primarySubscription(subs []*model.Subscription) *model.Subscription {
+	var primary *model.Subscription
+	var primaryPriority int
+	for _, sub := range subs {
+		priority := subscriptionGroupPriority(sub)
+		if primary == nil || betterPrimary(sub, priority, primary, primaryPriority) {
+			primary, primaryPriority = sub, priority
+		}
+	}
+	return primary
+}
+
+// betterPrimary a 是否比 b 更适合作为主订阅
+func betterPrimary(a *model.Subscription, aPriority int, b *model.Subscription, bPriority int) bool {
+	if aPriority != bPriority {
+		return aPriority > bPriority
+	}
+	aActive := a.Status == model.SubscriptionStatusActive
+	bActive := b.Status == model.SubscriptionStatusActive
+	if aActive != bActive {
+		return aActive
+	}
+	if a.DailyQuota != b.DailyQuota {
+		return a.DailyQuota > b.DailyQuota
+	}
+	return a.ID < b.ID
+}
+
+// subscriptionGroupPriority 订阅所属套餐的分组优先级
+func subscriptionGroupPriority(sub *model.Subscription) int {
+	if sub.Plan != nil {
+		return sub.Plan.GroupPriority
+	}
+	var plan model.Plan
+	if err := model.DB.Unscoped().First(&plan, sub.PlanID).Error; err != nil {
+		return 0
+	}
+	return plan.GroupPriority
+}
+
+// graceQuotaPercent 宽限期每日额度发放比例
+func graceQuotaPercent() int {
+	percent, err := strconv.Atoi(model.GetSetting(model.SettingGraceQuotaPercent))
+	if err != nil || percent < 0 {
+		return 0
+	}
+	return percent
+}
+
+// adjustUserQuota 按订阅变动调整用户 new-api 余额，并按分组优先级重新选择分组，返回余额实际的变化量
+// 除 subID 外没有其他生效中的订阅时，余额直接设为 delta（不低于 0），该订阅的加油包额度随之清空
+func adjustUserQuota(client NewAPIBackend, userID, subID uint, delta int) (int, error) {
+	var user model.User
+	if err := model.DB.First(&user, userID).Error; err != nil {
+		return 0, err
+	}
+	if user.NewAPIBound != 1 {
+		return 0, nil
+	}
+
+	lock, err := lockUserQuota(userID)
+	if err != nil {
+		return 0, err
+	}
+	defer releaseSyncLock(lock)
+
+	return adjustUserQuotaLocked(client, &user, subID, 0, delta)
+}
+
+// adjustUserQuotaLocked 同 adjustUserQuota，调用方需已绑定 new-api 并持有用户额度锁
+// 有其他订阅时先从余额扣除 replaced（不低于 0）再叠加 delta，用于变更套餐时替换原套餐本周期的额度
+func adjustUserQuotaLocked(client NewAPIBackend, user *model.User, subID uint, replaced, delta int) (int, error) {
+	var subs []model.Subscription
+	model.DB.Preload("Plan").
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionSyncStatuses).
+		Find(&subs)
+
+	hasOthers := false
+	for _, sub := range subs {
+		if sub.ID != subID {
+			hasOthers = true
+			break
+		}
+	}
+
+	newAPIUser, err := client.GetUser(user.NewAPIUserID)
+	if err != nil {
+		return 0, err
+	}
+	oldQuota := newAPIUser.Quota
+
+	quota := delta
+	if hasOthers {
+		quota = max(newAPIUser.Quota-replaced, 0) + delta
+	}
+	if quota < 0 {
+		quota = 0
+	}
+	newAPIUser.Quota = quota
+	if primary := PrimarySubscription(subs); primary != nil {
+		newAPIUser.Group = primary.NewAPIGroup
+	}
+	if err := client.UpdateUser(newAPIUser); err != nil {
+		return 0, err
+	}
+
+	if !hasOthers {
+		model.DB.Model(&model.Subscription{}).Where("id = ?", subID).
+			Updates(map[string]interface{}{
+				"booster_quota":       0,
+				"booster_carry_quota": 0,
+			})
+	}
+	return quota - oldQuota, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestAdjustUserQuotaWaitsForUserLock(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("frank", "password", "default", 300)
+	user := createTestUser(t, "frank", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+	// 还有其他订阅时在当前余额上叠加
+	createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+
+	// 同步持有用户额度锁期间，订单任务不能读取并写回余额
+	lock, err := lockUserQuota(user.ID)
+	if err != nil {
+		t.Fatalf("获取用户额度锁失败: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := adjustUserQuota(fake.Client(), user.ID, sub.ID, 100)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("持有锁期间调整额度未等待: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	fake.SetQuota(newAPIUser.ID, 500)
+	releaseSyncLock(lock)
+
+	if err := <-done; err != nil {
+		t.Fatalf("调整额度失败: %v", err)
+	}
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 600 {
+		t.Errorf("new-api 余额 = %d, 期望在锁释放后的 500 上增加到 600", got.Quota)
+	}
+
+	var count int64
+	model.DB.Model(&model.SyncLock{}).Where("name <> ?", model.SyncLockName).Count(&count)
+	if count != 0 {
+		t.Errorf("调整完成后仍有 %d 个用户额度锁未释放", count)
+	}
+}
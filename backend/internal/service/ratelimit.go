@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"newapi-subscribe/internal/config"
+)
+
+// syncRetryBackoff 首次重试前的等待时间，之后每次翻倍
+const syncRetryBackoff = time.Second
+
+// rateLimiter 按固定间隔放行请求，限制每秒请求数，可在多个 goroutine 间共享
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter 创建每秒最多放行 rps 个请求的限流器，rps <= 0 时返回 nil（不限制）
+func newRateLimiter(rps int) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(rps)}
+}
+
+// Wait 等待到可以发出下一个请求，ctx 结束时提前返回
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitedTransport 发出请求前先经过限流器
+type rateLimitedTransport struct {
+	limiter *rateLimiter
+	base    http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// rateLimited 为 new-api HTTP 客户端加上限流，其他实现原样返回
+func rateLimited(backend NewAPIBackend, limiter *rateLimiter) NewAPIBackend {
+	if client, ok := backend.(*NewAPIClient); ok {
+		return client.withRateLimit(limiter)
+	}
+	return backend
+}
+
+// withRetry 执行 new-api 请求，失败时按指数退避重试 config.Cfg.SyncRetries 次，ctx 结束时不再重试
+// 只用于可重复执行的请求（读取用户、按绝对值写入余额）
+func withRetry(ctx context.Context, fn func() error) error {
+	backoff := syncRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= config.Cfg.SyncRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// redeemCodeCharset 兑换码字符集（去除易混淆的 0/O/1/I）
+const redeemCodeCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateRedeemCodes 批量生成兑换码
+func GenerateRedeemCodes(plan *model.Plan, days, count int, expiresAt *time.Time, remark string) (string, []model.RedeemCode, error) {
+	batchNo := fmt.Sprintf("RB%s%s", time.Now().Format("20060102150405"), strings.ToUpper(generateRandomString(4)))
+
+	codes := make([]model.RedeemCode, 0, count)
+	for i := 0; i < count; i++ {
+		code, err := generateRedeemCode()
+		if err != nil {
+			return "", nil, err
+		}
+		codes = append(codes, model.RedeemCode{
+			Code:      code,
+			BatchNo:   batchNo,
+			PlanID:    plan.ID,
+			Days:      days,
+			Status:    model.RedeemCodeStatusUnused,
+			ExpiresAt: expiresAt,
+			Remark:    remark,
+		})
+	}
+
+	if err := model.DB.CreateInBatches(codes, 100).Error; err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("已生成兑换码批次 %s: 套餐=%d, 天数=%d, 数量=%d", batchNo, plan.ID, days, count)
+	return batchNo, codes, nil
+}
+
+// RedeemSubscriptionCode 兑换码兑换订阅，创建 0 元订单并走订单完成流程
+func RedeemSubscriptionCode(user *model.User, code string) (*model.Order, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	var order *model.Order
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		var redeem model.RedeemCode
+		if err := tx.Where("code = ?", code).First(&redeem).Error; err != nil {
+			return errors.New("兑换码不存在")
+		}
+		switch {
+		case redeem.Status == model.RedeemCodeStatusUsed:
+			return errors.New("兑换码已被使用")
+		case redeem.Status == model.RedeemCodeStatusRevoked:
+			return errors.New("兑换码已作废")
+		case redeem.IsExpired():
+			return errors.New("兑换码已过期")
+		}
+
+		var plan model.Plan
+		if err := tx.First(&plan, redeem.PlanID).Error; err != nil {
+			return errors.New("兑换码对应的套餐不存在")
+		}
+
+		order = &model.Order{
+			OrderNo:       fmt.Sprintf("RDM%d%d", user.ID, time.Now().UnixNano()),
+			UserID:        user.ID,
+			PlanID:        plan.ID,
+			OrderType:     model.OrderTypeNew,
+			PeriodDays:    redeem.Days,
+			Amount:        0,
+			PaymentMethod: model.PaymentMethodRedeem,
+			Status:        model.OrderStatusPending,
+		}
+
+		// 已有同套餐的订阅时续费该订阅，否则新增一个订阅
+		var existing model.Subscription
+		if err := tx.Where("user_id = ? AND plan_id = ? AND status IN ?", user.ID, plan.ID, model.SubscriptionCurrentStatuses).
+			First(&existing).Error; err == nil {
+			order.OrderType = model.OrderTypeRenew
+			order.SubscriptionID = existing.ID
+		}
+
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+
+		// 以状态为条件占用兑换码，防止并发重复兑换
+		now := time.Now()
+		result := tx.Model(&model.RedeemCode{}).
+			Where("id = ? AND status = ?", redeem.ID, model.RedeemCodeStatusUnused).
+			Updates(map[string]interface{}{
+				"status":   model.RedeemCodeStatusUsed,
+				"used_by":  user.ID,
+				"used_at":  &now,
+				"order_id": order.ID,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("兑换码已被使用")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CompleteOrder(order, "REDEEM_"+code); err != nil {
+		// 激活失败时释放兑换码
+		model.DB.Model(&model.RedeemCode{}).
+			Where("order_id = ?", order.ID).
+			Updates(map[string]interface{}{
+				"status":   model.RedeemCodeStatusUnused,
+				"used_by":  0,
+				"used_at":  nil,
+				"order_id": 0,
+			})
+		model.DB.Model(order).Update("status", model.OrderStatusCancelled)
+		return nil, err
+	}
+
+	log.Printf("用户 %d 兑换码 %s 兑换成功，订单 %s", user.ID, code, order.OrderNo)
+	return order, nil
+}
+
+// generateRedeemCode 生成 XXXX-XXXX-XXXX-XXXX 格式的兑换码
+func generateRedeemCode() (string, error) {
+	max := big.NewInt(int64(len(redeemCodeCharset)))
+	var sb strings.Builder
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(redeemCodeCharset[n.Int64()])
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestRedeemExtendsByExactDays(t *testing.T) {
+	fake := setupTest(t)
+	apiUser := fake.AddUser("redeem", "secret", "default", 0)
+	user := createTestUser(t, "redeem", apiUser.ID)
+
+	// 按月套餐的 10 天兑换码不应被取整为一个月
+	plan := createTestPlan(t, 1000, 0, 0)
+	if err := model.DB.Model(plan).Update("period_type", model.PeriodTypeMonth).Error; err != nil {
+		t.Fatalf("更新套餐失败: %v", err)
+	}
+	_, codes, err := GenerateRedeemCodes(plan, 10, 2, nil, "")
+	if err != nil {
+		t.Fatalf("生成兑换码失败: %v", err)
+	}
+
+	if _, err := RedeemSubscriptionCode(user, codes[0].Code); err != nil {
+		t.Fatalf("兑换失败: %v", err)
+	}
+	var sub model.Subscription
+	if err := model.DB.Where("user_id = ?", user.ID).First(&sub).Error; err != nil {
+		t.Fatalf("查询订阅失败: %v", err)
+	}
+	today := localToday(user)
+	if want := today.AddDate(0, 0, 10); !sub.EndDate.Equal(want) {
+		t.Fatalf("兑换后到期日 = %v, want %v", sub.EndDate, want)
+	}
+
+	// 续费同样只延长兑换码的天数
+	if _, err := RedeemSubscriptionCode(user, codes[1].Code); err != nil {
+		t.Fatalf("续费兑换失败: %v", err)
+	}
+	if err := model.DB.First(&sub, sub.ID).Error; err != nil {
+		t.Fatalf("查询订阅失败: %v", err)
+	}
+	if want := today.AddDate(0, 0, 20); !sub.EndDate.Equal(want) {
+		t.Fatalf("续费后到期日 = %v, want %v", sub.EndDate, want)
+	}
+}
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// RefundResult 退款结果
+type RefundResult struct {
+	OrderNo            string    `json:"order_no"`
+	RefundAmount       float64   `json:"refund_amount"`
+	UnusedDays         int       `json:"unused_days"`
+	SubscriptionID     uint      `json:"subscription_id"`
+	SubscriptionStatus string    `json:"subscription_status"`
+	EndDate            time.Time `json:"end_date"`
+}
+
+// CalculateRefund 按订阅剩余天数折算订单可退金额
+// 订单购买的天数总是追加在订阅末尾，因此未使用的天数为 min(订单天数, 剩余天数)
+func CalculateRefund(order *model.Order, sub *model.Subscription, today time.Time) (float64, int) {
+	if order.PeriodDays <= 0 || sub == nil {
+		return 0, 0
+	}
+
+	remaining := int(sub.EndDate.Sub(today).Hours() / 24)
+	if remaining <= 0 {
+		return 0, 0
+	}
+
+	unusedDays := remaining
+	if unusedDays > order.PeriodDays {
+		unusedDays = order.PeriodDays
+	}
+
+	amount := order.Amount * float64(unusedDays) / float64(order.PeriodDays)
+	return math.Round(amount*100) / 100, unusedDays
+}
+
+// RefundOrder 订单退款：原路退款、标记订单、缩短或终止订阅，并重置 new-api 额度和分组
+// amount 为 0 时按剩余天数自动折算；offline 为 true 时表示已线下退款，不调用支付网关
+func RefundOrder(order *model.Order, amount float64, reason string, offline bool) (*RefundResult, error) {
+	if !IsOrderRefundable(order) {
+		return nil, errors.New("只能对已支付或待审核的订单退款")
+	}
+	// 网关退款在本地退款提交后进行，网关不可用时提前拒绝
+	if !offline && order.PaymentMethod != model.PaymentMethodWallet {
+		if _, ok := GetPaymentProvider(order.PaymentProvider); !ok {
+			return nil, errors.New("订单支付网关不可用，请线下退款后选择仅回退订阅")
+		}
+	}
+
+	today := model.LocalDate(time.Now(), model.UserLocation(order.UserID))
+	now := time.Now()
+	result := &RefundResult{OrderNo: order.OrderNo}
+	quotaChanged, viaGateway := false, false
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		// 以订单状态为条件标记为已退款，并发退款时只有一个能成功；之后任一步失败时一并回滚
+		claim := tx.Model(&model.Order{}).
+			Where("id = ? AND status = ? AND needs_review = ?", order.ID, order.Status, order.NeedsReview).
+			Updates(map[string]interface{}{
+				"status":        model.OrderStatusRefunded,
+				"refund_reason": reason,
+				"refunded_at":   &now,
+				"needs_review":  0,
+			})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return errors.New("订单状态已变更，请刷新后重试")
+		}
+		if err := releaseCoupon(tx, order); err != nil {
+			return err
+		}
+
+		// 待审核订单（过期后支付）未激活订阅、充值和加油包订单不占订阅时长，全额退款
+		var sub *model.Subscription
+		prorated, unusedDays := order.Amount, 0
+		if order.Status == model.OrderStatusPaid && order.OrderType != model.OrderTypeTopup &&
+			order.OrderType != model.OrderTypeBooster {
+			var err error
+			sub, err = findOrderSubscription(tx, order)
+			if err != nil {
+				return err
+			}
+			prorated, unusedDays = CalculateRefund(order, sub, today)
+		}
+		if amount <= 0 {
+			amount = prorated
+		}
+		if amount > order.Amount {
+			return fmt.Errorf("退款金额不能超过订单金额 %.2f", order.Amount)
+		}
+		if err := tx.Model(&model.Order{}).Where("id = ?", order.ID).
+			Update("refund_amount", amount).Error; err != nil {
+			return err
+		}
+		result.RefundAmount = amount
+		result.UnusedDays = unusedDays
+
+		// 收回加油包额度
+		if order.Status == model.OrderStatusPaid && order.OrderType == model.OrderTypeBooster {
+			if err := removeOrderBooster(tx, order); err != nil {
+				return err
+			}
+			quotaChanged = true
+		}
+
+		// 回退订阅时长
+		if sub != nil {
+			if sub.IsCurrent() {
+				newEndDate := sub.EndDate.AddDate(0, 0, -unusedDays)
+				if !newEndDate.After(today) {
+					newEndDate = today
+					sub.Status = model.SubscriptionStatusExpired
+					quotaChanged = true
+				}
+				sub.EndDate = newEndDate
+				if err := tx.Save(sub).Error; err != nil {
+					return err
+				}
+			}
+			result.SubscriptionID = sub.ID
+			result.SubscriptionStatus = sub.Status
+			result.EndDate = sub.EndDate
+		}
+
+		// 钱包退款随事务提交；网关退款在提交后进行，不在事务中等待外部请求
+		if amount > 0 {
+			var err error
+			viaGateway, err = refundPayment(tx, order, amount, offline)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order.Status = model.OrderStatusRefunded
+	order.RefundAmount = result.RefundAmount
+	order.RefundReason = reason
+	order.RefundedAt = &now
+	order.NeedsReview = 0
+
+	log.Printf("订单 %s 已退款: 金额=%.2f, 回退天数=%d", order.OrderNo, result.RefundAmount, result.UnusedDays)
+
+	// 网关退款失败时本地退款不回滚，订单转人工审核，由管理员线下退款
+	var gatewayErr error
+	if viaGateway {
+		if gatewayErr = gatewayRefund(order, result.RefundAmount); gatewayErr != nil {
+			reason := "网关退款失败，需线下退款: " + gatewayErr.Error()
+			model.DB.Model(&model.Order{}).Where("id = ?", order.ID).
+				Updates(map[string]interface{}{
+					"needs_review":  1,
+					"review_reason": reason,
+				})
+			order.NeedsReview = 1
+			order.ReviewReason = reason
+			log.Printf("订单 %s 网关退款失败，已转人工审核: %v", order.OrderNo, gatewayErr)
+		}
+	}
+
+	// 订阅终止或收回加油包后按剩余订阅重新设置 new-api 额度和分组
+	if quotaChanged {
+		if err := refreshNewAPIUser(NewAPI(), order.UserID); err != nil {
+			return result, fmt.Errorf("本地退款已完成，但更新 new-api 账号失败: %v", err)
+		}
+	}
+
+	if gatewayErr != nil {
+		return result, fmt.Errorf("本地退款已完成，但网关退款失败，订单已转人工审核，请线下退款: %v", gatewayErr)
+	}
+	return result, nil
+}
+
+// refundPayment 在 tx 中退回款项：钱包支付的订单退回钱包，返回是否还需通过下单网关退款
+// 已入账的充值订单无论是否线下退款都需扣回钱包余额
+func refundPayment(tx *gorm.DB, order *model.Order, amount float64, offline bool) (bool, error) {
+	switch {
+	case order.OrderType == model.OrderTypeTopup && order.Status == model.OrderStatusPaid:
+		if err := DebitWallet(tx, order.UserID, amount, model.WalletTxRefund, order.ID, "充值退款 "+order.OrderNo); err != nil {
+			return false, err
+		}
+		return !offline, nil
+	case offline:
+		return false, nil
+	case order.PaymentMethod == model.PaymentMethodWallet:
+		return false, CreditWallet(tx, order.UserID, amount, model.WalletTxRefund, order.ID, "订单退款 "+order.OrderNo)
+	default:
+		return true, nil
+	}
+}
+
+// gatewayRefund 通过订单的支付网关退款
+func gatewayRefund(order *model.Order, amount float64) error {
+	provider, ok := GetPaymentProvider(order.PaymentProvider)
+	if !ok {
+		return fmt.Errorf("支付网关 %s 不可用", order.PaymentProvider)
+	}
+	return provider.Refund(order, amount)
+}
+
+// IsOrderRefundable 订单是否可退款
+func IsOrderRefundable(order *model.Order) bool {
+	return order.Status == model.OrderStatusPaid ||
+		(order.Status == model.OrderStatusCancelled && order.NeedsReview == 1)
+}
+
+// findOrderSubscription 在 tx 中查找订单对应的订阅
+func findOrderSubscription(tx *gorm.DB, order *model.Order) (*model.Subscription, error) {
+	var sub model.Subscription
+	if order.SubscriptionID > 0 {
+		if err := tx.First(&sub, order.SubscriptionID).Error; err != nil {
+			return nil, fmt.Errorf("订单关联的订阅不存在: %v", err)
+		}
+		return &sub, nil
+	}
+
+	// 兼容未记录订阅 ID 的历史订单，只回退同一套餐的订阅
+	err := tx.Where("user_id = ? AND plan_id = ? AND status IN ?", order.UserID, order.PlanID, model.SubscriptionCurrentStatuses).
+		Order("id DESC").
+		First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// removeOrderBooster 在 tx 中从用户的订阅上扣回加油包订单增加的额度
+// 同步时加油包额度会转记到主订阅上，因此先扣订单关联的订阅，不足部分依次从其他订阅扣除
+func removeOrderBooster(tx *gorm.DB, order *model.Order) error {
+	var subs []model.Subscription
+	if err := tx.Where("user_id = ? AND status IN ?", order.UserID, model.SubscriptionCurrentStatuses).
+		Order("id ASC").
+		Find(&subs).Error; err != nil {
+		return err
+	}
+	sort.SliceStable(subs, func(i, j int) bool {
+		return subs[i].ID == order.SubscriptionID && subs[j].ID != order.SubscriptionID
+	})
+
+	booster, boosterCarry := order.BoosterQuota-order.BoosterCarryQuota, order.BoosterCarryQuota
+	for _, sub := range subs {
+		if booster <= 0 && boosterCarry <= 0 {
+			break
+		}
+		quota, carry := min(booster, sub.BoosterQuota), min(boosterCarry, sub.BoosterCarryQuota)
+		if quota <= 0 && carry <= 0 {
+			continue
+		}
+		if err := tx.Model(&model.Subscription{}).Where("id = ?", sub.ID).
+			Updates(map[string]interface{}{
+				"booster_quota":       gorm.Expr("booster_quota - ?", quota),
+				"booster_carry_quota": gorm.Expr("booster_carry_quota - ?", carry),
+			}).Error; err != nil {
+			return err
+		}
+		booster -= quota
+		boosterCarry -= carry
+	}
+	return nil
+}
+
+// refreshNewAPIUser 按用户剩余的订阅重新设置 new-api 额度和分组
+// 余额不超过生效中订阅本周期已发放的额度与加油包额度之和（暂停中的订阅额度已扣除，不计入），分组按优先级重新选择；
+// 没有未终止的订阅时清零额度并恢复默认分组
+func refreshNewAPIUser(client NewAPIBackend, userID uint) error {
+	var user model.User
+	if err := model.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+	if user.NewAPIBound != 1 {
+		return nil
+	}
+
+	lock, err := lockUserQuota(userID)
+	if err != nil {
+		return err
+	}
+	defer releaseSyncLock(lock)
+
+	var subs []model.Subscription
+	model.DB.Preload("Plan").
+		Where("user_id = ? AND status IN ?", userID, model.SubscriptionCurrentStatuses).
+		Find(&subs)
+
+	newAPIUser, err := client.GetUser(user.NewAPIUserID)
+	if err != nil {
+		return err
+	}
+
+	if len(subs) == 0 {
+		newAPIUser.Quota = 0
+		newAPIUser.Group = model.GetSetting(model.SettingDefaultGroup)
+		return client.UpdateUser(newAPIUser)
+	}
+
+	var live []model.Subscription
+	entitled := 0
+	for _, sub := range subs {
+		if sub.Status == model.SubscriptionStatusPaused {
+			continue
+		}
+		live = append(live, sub)
+		entitled += sub.TodayQuota + sub.BoosterQuota + sub.BoosterCarryQuota
+	}
+	newAPIUser.Quota = max(min(newAPIUser.Quota, entitled), 0)
+	if primary := PrimarySubscription(live); primary != nil {
+		newAPIUser.Group = primary.NewAPIGroup
+	}
+	return client.UpdateUser(newAPIUser)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestRefundOrderOnlyOnce(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("ivan", "password", "default", 0)
+	user := createTestUser(t, "ivan", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+	order.PaymentMethod = model.PaymentMethodWallet
+	model.DB.Save(order)
+	if err := CompleteOrder(order, ""); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	// 两次退款都使用退款前加载的订单，模拟并发的管理员操作
+	first, second := *order, *order
+	if _, err := RefundOrder(&first, 0, "test", false); err != nil {
+		t.Fatalf("RefundOrder: %v", err)
+	}
+	if _, err := RefundOrder(&second, 0, "test", false); err == nil {
+		t.Fatal("重复退款应失败")
+	}
+
+	var refunds []model.WalletTransaction
+	model.DB.Where("user_id = ? AND type = ?", user.ID, model.WalletTxRefund).Find(&refunds)
+	if len(refunds) != 1 || refunds[0].Amount != plan.Price {
+		t.Errorf("退款流水 = %+v，期望一笔 %.2f", refunds, plan.Price)
+	}
+
+	var sub model.Subscription
+	model.DB.First(&sub, order.SubscriptionID)
+	if sub.Status != model.SubscriptionStatusExpired {
+		t.Errorf("订阅状态 = %s，期望全额退款后过期", sub.Status)
+	}
+}
+
+func TestRefundOrderRollsBackOnGatewayFailure(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("judy", "password", "default", 0)
+	user := createTestUser(t, "judy", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+	order.PaymentProvider = "missing"
+	model.DB.Save(order)
+	if err := CompleteOrder(order, "trade-5"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	if _, err := RefundOrder(order, 0, "test", false); err == nil {
+		t.Fatal("支付网关不可用时退款应失败")
+	}
+
+	var got model.Order
+	model.DB.First(&got, order.ID)
+	var sub model.Subscription
+	model.DB.First(&sub, order.SubscriptionID)
+	if got.Status != model.OrderStatusPaid || sub.Status != model.SubscriptionStatusActive {
+		t.Errorf("退款失败后订单 = %s, 订阅 = %s，期望保持不变", got.Status, sub.Status)
+	}
+}
+
+func TestRefundOrderFlagsReviewWhenGatewayRefundFails(t *testing.T) {
+	fake := setupTest(t)
+	provider := setupFakePayment(t)
+	provider.refundErr = errors.New("gateway timeout")
+	newAPIUser := fake.AddUser("mia", "password", "default", 0)
+	user := createTestUser(t, "mia", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+	order.PaymentProvider = provider.Name()
+	model.DB.Save(order)
+	if err := CompleteOrder(order, "trade-9"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	// 网关在本地退款提交后调用，失败时本地退款保留，订单转人工审核
+	result, err := RefundOrder(order, 0, "test", false)
+	if err == nil {
+		t.Fatal("网关退款失败时应返回错误")
+	}
+	if result == nil || result.RefundAmount != plan.Price {
+		t.Errorf("退款结果 = %+v, 期望本地已退款 %.2f", result, plan.Price)
+	}
+
+	var got model.Order
+	model.DB.First(&got, order.ID)
+	var sub model.Subscription
+	model.DB.First(&sub, order.SubscriptionID)
+	if got.Status != model.OrderStatusRefunded || got.NeedsReview != 1 || got.ReviewReason == "" {
+		t.Errorf("订单 = %s, 待审核 = %d (%s), 期望已退款并转人工审核", got.Status, got.NeedsReview, got.ReviewReason)
+	}
+	if sub.Status != model.SubscriptionStatusExpired {
+		t.Errorf("订阅状态 = %s, 期望已回退", sub.Status)
+	}
+	if n, _ := fake.User(newAPIUser.ID); n.Quota != 0 {
+		t.Errorf("new-api 余额 = %d, 期望清零", n.Quota)
+	}
+}
+
+func TestRefundOrderRecomputesQuotaFromRemainingSubscriptions(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("kate", "password", "default", 1000)
+	user := createTestUser(t, "kate", newAPIUser.ID)
+	basic := createTestPlan(t, 1000, 0, 0)
+	createSyncedSubscription(t, user, basic, localToday(user).AddDate(0, 0, 10))
+
+	premium := createTestPlan(t, 1000, 0, 0)
+	model.DB.Model(premium).Updates(map[string]interface{}{"newapi_group": "svip", "group_priority": 10})
+	order := createTestOrder(t, user, premium)
+	order.PaymentMethod = model.PaymentMethodWallet
+	model.DB.Save(order)
+	if err := CompleteOrder(order, ""); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+	if got, _ := fake.User(newAPIUser.ID); got.Quota != 2000 || got.Group != "svip" {
+		t.Fatalf("购买后 new-api 余额 = %d, 分组 = %s", got.Quota, got.Group)
+	}
+
+	// 还有其他订阅时，退款终止的订阅的本期额度被收回，分组回到剩余订阅
+	if _, err := RefundOrder(order, 0, "test", false); err != nil {
+		t.Fatalf("RefundOrder: %v", err)
+	}
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 1000 || got.Group != "vip" {
+		t.Errorf("退款后 new-api 余额 = %d, 分组 = %s, 期望 1000, vip", got.Quota, got.Group)
+	}
+}
+
+func TestRefundBoosterOrderRemovesBoosterQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("liam", "password", "default", 1000)
+	user := createTestUser(t, "liam", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+	order := createTestOrder(t, user, plan)
+	model.DB.Model(order).Updates(map[string]interface{}{
+		"order_type":          model.OrderTypeBooster,
+		"subscription_id":     sub.ID,
+		"booster_quota":       500,
+		"booster_carry_quota": 200,
+		"payment_method":      model.PaymentMethodWallet,
+	})
+	model.DB.First(order, order.ID)
+	if err := CompleteOrder(order, ""); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	if _, err := RefundOrder(order, 0, "test", false); err != nil {
+		t.Fatalf("RefundOrder: %v", err)
+	}
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 1000 {
+		t.Errorf("退款后 new-api 余额 = %d, 期望收回加油包额度后为 1000", got.Quota)
+	}
+	model.DB.First(sub, sub.ID)
+	if sub.BoosterQuota != 0 || sub.BoosterCarryQuota != 0 {
+		t.Errorf("订阅加油包额度 = %d/%d, 期望清零", sub.BoosterQuota, sub.BoosterCarryQuota)
+	}
+}
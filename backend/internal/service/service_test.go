@@ -0,0 +1,87 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
+)
+
+// setupTest 使用临时数据库和内存 new-api 初始化服务，测试结束时恢复原依赖
+func setupTest(t *testing.T) *FakeNewAPI {
+	t.Helper()
+
+	config.Cfg = &config.Config{
+		JWTSecret:       "test-secret",
+		SyncConcurrency: 1,
+		SyncTimeout:     1,
+	}
+	if err := model.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("初始化数据库失败: %v", err)
+	}
+	model.DB.Logger = logger.Discard
+
+	fake := NewFakeNewAPI("default", "vip")
+	old := SetContainer(fake.Container())
+	groupModelsCache = make(map[string]groupModelsEntry)
+	t.Cleanup(func() {
+		SetContainer(old)
+		if sqlDB, err := model.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return fake
+}
+
+// createTestUser 创建本地用户，newAPIUserID 为 0 时不绑定 new-api 账号
+func createTestUser(t *testing.T, username string, newAPIUserID int) *model.User {
+	t.Helper()
+
+	user := &model.User{
+		Username:     username,
+		Role:         model.RoleUser,
+		Status:       model.StatusEnabled,
+		NewAPIUserID: newAPIUserID,
+	}
+	if newAPIUserID > 0 {
+		user.NewAPIUsername = username
+		user.NewAPIBound = 1
+	}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+// createTestPlan 创建按天计费、每日发放 dailyQuota 的套餐
+func createTestPlan(t *testing.T, dailyQuota, carryOver, maxCarryOver int) *model.Plan {
+	t.Helper()
+
+	plan := &model.Plan{
+		Name:         "测试套餐",
+		PeriodType:   model.PeriodTypeDay,
+		PeriodDays:   30,
+		QuotaWindow:  model.QuotaWindowDay,
+		DailyQuota:   dailyQuota,
+		CarryOver:    carryOver,
+		MaxCarryOver: maxCarryOver,
+		PriceType:    model.PriceTypeFixed,
+		Price:        10,
+		GraceDays:    -1,
+		NewAPIGroup:  "vip",
+		Status:       1,
+	}
+	if err := model.DB.Create(plan).Error; err != nil {
+		t.Fatalf("创建套餐失败: %v", err)
+	}
+	return plan
+}
+
+// localToday 用户时区的今天
+func localToday(user *model.User) time.Time {
+	loc := user.Location()
+	return model.LocalDate(time.Now().In(loc), loc)
+}
@@ -1,37 +1,44 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"time"
 
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/config"
 	"newapi-subscribe/internal/model"
 )
 
-// SyncAllSubscriptions 同步所有订阅的额度
+// SyncAllSubscriptions 每日定时同步所有订阅的额度，并处理暂停恢复和到期提醒
+// 其他同步正在执行时等待其完成，避免错过当天的暂停恢复和到期提醒
 func SyncAllSubscriptions() {
-	log.Println("开始执行订阅额度同步...")
-
-	client := NewNewAPIClient()
-	if err := client.AdminLogin(); err != nil {
-		log.Printf("管理员登录失败: %v", err)
+	lock, err := acquireSyncLock(time.Duration(config.Cfg.SyncTimeout) * time.Minute)
+	if err != nil {
+		log.Printf("获取同步锁失败，跳过本次同步: %v", err)
+		return
+	}
+	run, err := startSyncRun(lock, model.SyncSourceSchedule, 0)
+	if err != nil {
+		releaseSyncLock(lock)
+		log.Printf("创建同步记录失败: %v", err)
 		return
 	}
+	syncAllSubscriptions(lock, run)
+}
 
-	today := time.Now().Truncate(24 * time.Hour)
+// syncAllSubscriptions 同步所有订阅的额度，并处理暂停恢复和到期提醒，完成后释放同步锁
+func syncAllSubscriptions(lock *model.SyncLock, run *model.SyncRun) {
+	defer releaseSyncLock(lock)
+	log.Println("开始执行订阅额度同步...")
 
-	// 获取所有活跃订阅
-	var subscriptions []model.Subscription
-	model.DB.Preload("User").
-		Where("status = ?", model.SubscriptionStatusActive).
-		Find(&subscriptions)
+	runSync(lock, run, nil)
 
-	for _, sub := range subscriptions {
-		if err := syncSubscription(client, &sub, today); err != nil {
-			log.Printf("同步订阅 %d 失败: %v", sub.ID, err)
-		}
-	}
+	// 自动恢复暂停天数已用完的订阅
+	ResumeOverduePauses(time.Now())
 
 	// 发送到期提醒
 	sendExpirationReminders()
@@ -39,75 +46,340 @@ func SyncAllSubscriptions() {
 	log.Println("订阅额度同步完成")
 }
 
-// syncSubscription 同步单个订阅
-func syncSubscription(client *NewAPIClient, sub *model.Subscription, today time.Time) error {
-	// 检查是否过期
-	if sub.EndDate.Before(today) {
-		sub.Status = model.SubscriptionStatusExpired
-		model.DB.Save(sub)
-
-		// 清零 new-api 余额
-		if sub.User != nil && sub.User.NewAPIBound == 1 {
-			newAPIUser, err := client.GetUser(sub.User.NewAPIUserID)
-			if err == nil {
-				newAPIUser.Quota = 0
-				client.UpdateUser(newAPIUser)
-			}
+// SyncDueSubscriptions 处理订阅到期，并为进入新额度周期的订阅发放额度
+// 额度周期和日期按各用户的时区计算，需要定期执行以便在用户当地的周期开始时重置额度；
+// 只有存在需要更新的订阅时才记录同步记录，其他同步正在执行时跳过
+func SyncDueSubscriptions() {
+	lock, err := tryAcquireSyncLock()
+	if err != nil {
+		if !errors.Is(err, ErrSyncRunning) {
+			log.Printf("获取同步锁失败: %v", err)
 		}
-		log.Printf("订阅 %d 已过期", sub.ID)
-		return nil
+		return
 	}
+	defer releaseSyncLock(lock)
 
-	// 获取用户
-	if sub.User == nil || sub.User.NewAPIBound != 1 {
-		return nil
+	runSync(lock, &model.SyncRun{
+		Source:    model.SyncSourcePeriodic,
+		Status:    model.SyncRunStatusRunning,
+		StartedAt: time.Now(),
+	}, nil)
+}
+
+// groupSubscriptionsByUser 按用户分组订阅
+func groupSubscriptionsByUser(subscriptions []model.Subscription) [][]*model.Subscription {
+	var groups [][]*model.Subscription
+	index := make(map[uint]int)
+	for i := range subscriptions {
+		sub := &subscriptions[i]
+		if j, ok := index[sub.UserID]; ok {
+			groups[j] = append(groups[j], sub)
+			continue
+		}
+		index[sub.UserID] = len(groups)
+		groups = append(groups, []*model.Subscription{sub})
+	}
+	return groups
+}
+
+// syncUserSubscriptions 同步单个用户的所有订阅，返回各订阅的同步结果，无需更新时返回 nil
+// 各订阅分别处理到期，仍有效的订阅中进入新额度周期的重新发放额度，与其他订阅的剩余额度叠加后写入 new-api；
+// 用户当地进入新的一天时，未设置跨日保留的加油包额度失效；new-api 请求失败时按退避重试，ctx 结束时不再重试
+// subs 只用于判断是否需要同步，确认需要后在用户额度锁内重新读取订阅，并只更新同步修改的字段，
+// 避免覆盖同步期间完成的续费、加油包等订单对订阅的修改
+func syncUserSubscriptions(ctx context.Context, client NewAPIBackend, subs []*model.Subscription, now time.Time) ([]model.SyncResult, error) {
+	userID := subs[0].UserID
+	loc := model.SiteLocation()
+	if subs[0].User != nil {
+		loc = subs[0].User.Location()
+	}
+	now = now.In(loc)
+	today := model.LocalDate(now, loc)
+
+	// 到期的订阅先从钱包自动续费，续费走订单流程，之后重新读取订阅时生效
+	for _, sub := range subs {
+		if sub.EndDate.Before(today) && sub.Status == model.SubscriptionStatusActive && sub.AutoRenew == 1 {
+			autoRenewExpiring(sub)
+		}
+	}
+	if !syncMaybeNeeded(subs, now, today) {
+		return nil, nil
+	}
+
+	lock, err := lockUserQuota(userID)
+	if err != nil {
+		return failSyncResults(newSyncResults(subs), err), err
 	}
+	defer releaseSyncLock(lock)
 
-	// 获取 new-api 当前余额（昨日剩余）
-	newAPIUser, err := client.GetUser(sub.User.NewAPIUserID)
+	subs, err = loadUserSyncSubscriptions(userID)
 	if err != nil {
+		return failSyncResults(newSyncResults(subs), err), err
+	}
+	if len(subs) == 0 {
+		return nil, nil
+	}
+	user := subs[0].User
+
+	var live []*model.Subscription
+	for _, sub := range subs {
+		if checkSubscriptionExpiry(sub, today) {
+			live = append(live, sub)
+		}
+	}
+
+	// 没有订阅到期、进入新周期或需要失效的加油包时无需更新
+	changed, expireBoosters := syncNeeded(live, len(live) < len(subs), now, today)
+	if !changed {
+		return nil, nil
+	}
+
+	// 未绑定 new-api 账号时只记录订阅到期；额度周期保持待同步，绑定后的首次同步再发放
+	if user == nil || user.NewAPIBound != 1 {
+		if len(live) == len(subs) {
+			return nil, nil
+		}
+		return setSyncResults(newSyncResults(subs), 0, 0, "未绑定 new-api 账号，仅更新订阅状态"), nil
+	}
+
+	results := newSyncResults(subs)
+
+	// 获取 new-api 当前余额（上期剩余）
+	var newAPIUser *NewAPIUser
+	err = withRetry(ctx, func() (err error) {
+		newAPIUser, err = client.GetUser(user.NewAPIUserID)
 		return err
+	})
+	if err != nil {
+		return failSyncResults(results, err), err
 	}
-	yesterdayRemaining := newAPIUser.Quota
+	remaining := newAPIUser.Quota
 
-	// 计算新的每日额度
-	var newQuota int
-	var carriedQuota int
+	// 所有订阅均已过期，清零 new-api 余额
+	if len(live) == 0 {
+		newAPIUser.Quota = 0
+		if err := withRetry(ctx, func() error { return client.UpdateUser(newAPIUser) }); err != nil {
+			return failSyncResults(results, err), err
+		}
+		return setSyncResults(results, remaining, 0, "所有订阅已过期，清零余额"), nil
+	}
 
-	if sub.CarryOver == 1 && yesterdayRemaining > 0 {
-		// 计算结转额度
-		carriedQuota = yesterdayRemaining
-		if sub.MaxCarryOver > 0 && carriedQuota > sub.MaxCarryOver {
-			carriedQuota = sub.MaxCarryOver
+	// 计算叠加后的额度
+	stack := stackSubscriptionQuota(live, now, remaining, graceQuotaPercent(), expireBoosters)
+	if stack.Frozen {
+		for _, sub := range live {
+			if _, ok := stack.Granted[sub.ID]; ok {
+				markWindowSynced(sub, now)
+				saveSyncedSubscription(sub, map[string]interface{}{
+					"window_start":   sub.WindowStart,
+					"last_sync_date": sub.LastSyncDate,
+				})
+			}
 		}
-		newQuota = sub.DailyQuota + carriedQuota
-	} else {
-		newQuota = sub.DailyQuota
-		carriedQuota = 0
+		log.Printf("用户 %d 的订阅处于宽限期，额度已冻结: 当前余额=%d", user.ID, remaining)
+		return setSyncResults(results, remaining, remaining, "宽限期内额度已冻结"), nil
 	}
 
 	// 更新 new-api 用户余额和分组
-	newAPIUser.Quota = newQuota
-	newAPIUser.Group = sub.NewAPIGroup
-	if err := client.UpdateUser(newAPIUser); err != nil {
-		return err
+	newAPIUser.Quota = stack.Quota
+	newAPIUser.Group = stack.Group
+	if err := withRetry(ctx, func() error { return client.UpdateUser(newAPIUser) }); err != nil {
+		return failSyncResults(results, err), err
 	}
 
-	// 更新本地记录
-	sub.TodayQuota = newQuota
-	sub.CarriedQuota = carriedQuota
+	// 更新本地记录，结转额度记在进入新周期的主订阅上，保留的加油包额度记在主订阅上；
+	// 加油包额度按变化量更新，保留同步期间新购加油包的额度
+	for _, sub := range live {
+		booster, boosterCarry := sub.BoosterQuota, sub.BoosterCarryQuota
+		sub.LastSyncDate = &today
+		if granted, ok := stack.Granted[sub.ID]; ok {
+			sub.TodayQuota = granted
+			sub.CarriedQuota = 0
+			if sub.ID == stack.CarriedID {
+				sub.TodayQuota += stack.Carried
+				sub.CarriedQuota = stack.Carried
+			}
+			markWindowSynced(sub, now)
+		}
+		sub.BoosterQuota = 0
+		sub.BoosterCarryQuota = 0
+		if sub.ID == stack.PrimaryID {
+			sub.BoosterQuota = stack.Booster - stack.BoosterCarry
+			sub.BoosterCarryQuota = stack.BoosterCarry
+		}
+		saveSyncedSubscription(sub, map[string]interface{}{
+			"last_sync_date":      sub.LastSyncDate,
+			"window_start":        sub.WindowStart,
+			"today_quota":         sub.TodayQuota,
+			"carried_quota":       sub.CarriedQuota,
+			"booster_quota":       gorm.Expr("booster_quota + ?", sub.BoosterQuota-booster),
+			"booster_carry_quota": gorm.Expr("booster_carry_quota + ?", sub.BoosterCarryQuota-boosterCarry),
+		})
+	}
+
+	log.Printf("用户 %d 同步完成: 订阅数=%d, 新周期=%d, 结转=%d, 加油包=%d, 新额度=%d, 分组=%s",
+		user.ID, len(live), len(stack.Granted), stack.Carried, stack.Booster, stack.Quota, stack.Group)
+
+	setSyncResults(results, remaining, stack.Quota, "")
+	for i := range results {
+		results[i].Granted = stack.Granted[results[i].SubscriptionID]
+		if results[i].SubscriptionID == stack.CarriedID {
+			results[i].Carried = stack.Carried
+		}
+	}
+	return results, nil
+}
+
+// syncMaybeNeeded 按已加载的订阅判断是否可能需要同步，不修改订阅；需要时再加锁重新读取确认
+func syncMaybeNeeded(subs []*model.Subscription, now, today time.Time) bool {
+	var live []*model.Subscription
+	statusChanged := false
+	for _, sub := range subs {
+		status, _ := subscriptionExpiryStatus(sub, today)
+		if status != sub.Status {
+			statusChanged = true
+		}
+		if status != model.SubscriptionStatusExpired {
+			live = append(live, sub)
+		}
+	}
+	if statusChanged {
+		return true
+	}
+	if user := subs[0].User; user == nil || user.NewAPIBound != 1 {
+		return false
+	}
+	changed, _ := syncNeeded(live, false, now, today)
+	return changed
+}
+
+// loadUserSyncSubscriptions 读取用户需要同步额度的订阅
+func loadUserSyncSubscriptions(userID uint) ([]*model.Subscription, error) {
+	var subscriptions []model.Subscription
+	if err := model.DB.Preload("User").Preload("Plan").
+		Where("user_id = ? AND status IN ?", userID, model.SubscriptionSyncStatuses).
+		Order("id ASC").
+		Find(&subscriptions).Error; err != nil {
+		return nil, err
+	}
+	subs := make([]*model.Subscription, len(subscriptions))
+	for i := range subscriptions {
+		subs[i] = &subscriptions[i]
+	}
+	return subs, nil
+}
+
+// saveSyncedSubscription 保存同步修改的订阅字段，订阅状态已被其他操作改变时不写入
+func saveSyncedSubscription(sub *model.Subscription, updates map[string]interface{}) {
+	result := model.DB.Model(&model.Subscription{}).
+		Where("id = ? AND status = ?", sub.ID, sub.Status).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("保存订阅 %d 的同步结果失败: %v", sub.ID, result.Error)
+	} else if result.RowsAffected == 0 {
+		log.Printf("订阅 %d 在同步期间状态已变更，未保存同步结果", sub.ID)
+	}
+}
+
+// syncNeeded 判断用户的订阅是否需要同步，以及加油包额度是否失效
+// live 为仍有效的订阅，expired 表示有订阅本次过期；用户当地进入新的一天时未跨日保留的加油包额度失效
+func syncNeeded(live []*model.Subscription, expired bool, now, today time.Time) (changed, expireBoosters bool) {
+	changed = expired
+	for _, sub := range live {
+		if sub.LastSyncDate == nil || sub.LastSyncDate.Before(today) {
+			expireBoosters = true
+		}
+		if sub.WindowDue(now) {
+			changed = true
+		}
+	}
+	for _, sub := range live {
+		if expireBoosters && sub.BoosterQuota > 0 {
+			changed = true
+		}
+	}
+	return changed, expireBoosters
+}
+
+// markWindowSynced 记录订阅已在 now 所处的额度周期发放额度，now 需为用户时区的时间
+func markWindowSynced(sub *model.Subscription, now time.Time) {
+	windowStart := model.QuotaWindowStart(sub.QuotaWindow, now)
+	today := model.LocalDate(now, now.Location())
+	sub.WindowStart = &windowStart
 	sub.LastSyncDate = &today
-	model.DB.Save(sub)
+}
 
-	log.Printf("订阅 %d 同步完成: 每日额度=%d, 结转=%d, 新额度=%d",
-		sub.ID, sub.DailyQuota, carriedQuota, newQuota)
+// checkSubscriptionExpiry 处理订阅到期：进入宽限期或标记过期，返回订阅是否仍有效
+// 以读取时的状态和已到期为条件更新，期间被续费或变更的订阅重新读取，留到下次同步再处理
+func checkSubscriptionExpiry(sub *model.Subscription, today time.Time) bool {
+	status, graceEnd := subscriptionExpiryStatus(sub, today)
+	if status == sub.Status {
+		return true
+	}
 
-	return nil
+	updates := map[string]interface{}{"status": status}
+	if status == model.SubscriptionStatusGrace {
+		updates["grace_end_date"] = graceEnd
+	}
+	result := model.DB.Model(&model.Subscription{}).
+		Where("id = ? AND status = ? AND end_date < ?", sub.ID, sub.Status, today).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("更新订阅 %d 到期状态失败: %v", sub.ID, result.Error)
+		return true
+	}
+	if result.RowsAffected == 0 {
+		if err := model.DB.Preload("User").Preload("Plan").First(sub, sub.ID).Error; err != nil {
+			log.Printf("重新加载订阅 %d 失败: %v", sub.ID, err)
+			return false
+		}
+		// 仍未终止的订阅保留到下次同步再判断
+		return sub.Status == model.SubscriptionStatusActive || sub.Status == model.SubscriptionStatusGrace
+	}
+
+	sub.Status = status
+	if status == model.SubscriptionStatusExpired {
+		log.Printf("订阅 %d 已过期", sub.ID)
+		return false
+	}
+	sub.GraceEndDate = graceEnd
+	log.Printf("订阅 %d 已到期，进入宽限期至 %s", sub.ID, graceEnd.Format("2006-01-02"))
+	return true
+}
+
+// subscriptionExpiryStatus 计算订阅在 today 的状态（不含自动续费），不修改订阅
+// 未到期时返回当前状态；进入宽限期时同时返回宽限期截止日期
+func subscriptionExpiryStatus(sub *model.Subscription, today time.Time) (string, *time.Time) {
+	if !sub.EndDate.Before(today) {
+		return sub.Status, nil
+	}
+	if graceEnd := subscriptionGraceEnd(sub); graceEnd != nil && !graceEnd.Before(today) {
+		return model.SubscriptionStatusGrace, graceEnd
+	}
+	return model.SubscriptionStatusExpired, nil
+}
+
+// subscriptionGraceEnd 计算订阅宽限期截止日期，无宽限期时返回 nil
+func subscriptionGraceEnd(sub *model.Subscription) *time.Time {
+	if sub.GraceEndDate != nil {
+		return sub.GraceEndDate
+	}
+
+	var plan model.Plan
+	if err := model.DB.Unscoped().First(&plan, sub.PlanID).Error; err != nil {
+		return nil
+	}
+	days := plan.GracePeriodDays()
+	if days <= 0 {
+		return nil
+	}
+	graceEnd := sub.EndDate.AddDate(0, 0, days)
+	return &graceEnd
 }
 
 // sendExpirationReminders 发送到期提醒
 func sendExpirationReminders() {
-	today := time.Now().Truncate(24 * time.Hour)
+	now := time.Now()
 
 	var subscriptions []model.Subscription
 	model.DB.Preload("User").Preload("Plan").
@@ -119,6 +391,8 @@ func sendExpirationReminders() {
 			continue
 		}
 
+		// 剩余天数按用户时区计算
+		today := model.LocalDate(now, sub.User.Location())
 		daysRemaining := int(sub.EndDate.Sub(today).Hours() / 24)
 		if daysRemaining <= sub.User.RemindDays && daysRemaining >= 0 {
 			// 发送提醒邮件
@@ -126,134 +400,291 @@ func sendExpirationReminders() {
 			if sub.Plan != nil {
 				planName = sub.Plan.Name
 			}
+			if sub.Plan != nil && sub.Plan.IsTrial == 1 {
+				SendTrialEndingReminder(sub.User.Email, sub.User.Username, planName, daysRemaining)
+				continue
+			}
+			// 自动续费的订阅余额充足时无需提醒，余额不足时提醒充值
+			if sub.AutoRenew == 1 && sub.Plan != nil {
+				price := sub.Plan.CalculatePrice(sub.Plan.PeriodDays)
+				if sub.User.Balance < price {
+					SendLowBalanceReminder(sub.User.Email, sub.User.Username, planName, price, sub.User.Balance, daysRemaining)
+				}
+				continue
+			}
 			SendExpirationReminder(sub.User.Email, sub.User.Username, planName, daysRemaining)
 		}
 	}
 }
 
 // CompleteOrder 完成订单
+// 本地状态（订单、订阅）在同一事务中更新，并以订单状态作为幂等条件；
+// new-api 侧操作记录为 OrderTask，失败后由定时任务重试
 func CompleteOrder(order *model.Order, tradeNo string) error {
-	// 更新订单状态
 	now := time.Now()
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		// 同一交易号只能完成一个订单
+		if tradeNo != "" {
+			var count int64
+			tx.Model(&model.Order{}).
+				Where("trade_no = ? AND id <> ? AND status IN ?", tradeNo, order.ID,
+					[]string{model.OrderStatusPaid, model.OrderStatusRefunded}).
+				Count(&count)
+			if count > 0 {
+				return fmt.Errorf("交易号 %s 已被其他订单使用", tradeNo)
+			}
+		}
+
+		// 以订单状态为条件更新，并发完成时只有一个能成功
+		result := tx.Model(&model.Order{}).
+			Where("id = ? AND (status = ? OR (status = ? AND needs_review = ?))",
+				order.ID, model.OrderStatusPending, model.OrderStatusCancelled, 1).
+			Updates(map[string]interface{}{
+				"status":           model.OrderStatusPaid,
+				"trade_no":         tradeNo,
+				"paid_at":          &now,
+				"needs_review":     0,
+				"payment_provider": order.PaymentProvider,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errOrderAlreadyCompleted
+		}
+
+		// 钱包充值订单只需入账
+		if order.OrderType == model.OrderTypeTopup {
+			return CreditWallet(tx, order.UserID, order.Amount, model.WalletTxTopup, order.ID, "余额充值")
+		}
+
+		var user model.User
+		if err := tx.First(&user, order.UserID).Error; err != nil {
+			return err
+		}
+
+		// 加油包订单只为订阅增加额度
+		if order.OrderType == model.OrderTypeBooster {
+			subscription, err := applyOrderBooster(tx, order)
+			if err != nil {
+				return err
+			}
+			order.SubscriptionID = subscription.ID
+			return createOrderTasks(tx, order.ID, model.OrderTaskStepAddBooster)
+		}
+
+		var plan model.Plan
+		if err := tx.First(&plan, order.PlanID).Error; err != nil {
+			return err
+		}
+
+		// 变更套餐前原套餐本周期已发放的额度，发放新套餐额度前先从余额扣除
+		replacedQuota := 0
+		if order.IsPlanChange() {
+			var current model.Subscription
+			if err := tx.First(&current, order.SubscriptionID).Error; err == nil {
+				replacedQuota = current.TodayQuota
+			}
+		}
+
+		subscription, extended, err := applyOrderSubscription(tx, order, &user, &plan)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&model.Order{}).Where("id = ?", order.ID).
+			Update("subscription_id", subscription.ID).Error; err != nil {
+			return err
+		}
+
+		// 变更套餐时原订阅剩余价值超出应付金额的部分退回钱包；原订阅已失效按新购处理时不退
+		if order.IsPlanChange() && order.WalletCredit > 0 && subscription.ID == order.SubscriptionID {
+			if err := CreditWallet(tx, order.UserID, order.WalletCredit, model.WalletTxRefund, order.ID, "变更套餐剩余价值退回"); err != nil {
+				return err
+			}
+		}
+
+		// 记录 new-api 侧待执行步骤；续费仅延长有效期：订阅当前额度周期的额度已发放，
+		// 再执行 ApplyQuota 会在余额上重复叠加一份周期额度，新周期的额度由同步发放
+		var steps []string
+		if user.NewAPIBound != 1 {
+			steps = append(steps, model.OrderTaskStepCreateUser)
+		}
+		if !extended || user.NewAPIBound != 1 {
+			steps = append(steps, model.OrderTaskStepApplyQuota)
+		}
+		if err := createOrderTasks(tx, order.ID, steps...); err != nil {
+			return err
+		}
+		// 原订阅已失效按新购处理时不扣除
+		if replacedQuota > 0 && subscription.ID == order.SubscriptionID {
+			if err := tx.Model(&model.OrderTask{}).
+				Where("order_id = ? AND step = ?", order.ID, model.OrderTaskStepApplyQuota).
+				Update("replaced_quota", replacedQuota).Error; err != nil {
+				return err
+			}
+		}
+
+		order.SubscriptionID = subscription.ID
+		return nil
+	})
+
+	if errors.Is(err, errOrderAlreadyCompleted) {
+		log.Printf("订单 %s 已处理，忽略重复完成请求", order.OrderNo)
+		return model.DB.First(order, order.ID).Error
+	}
+	if err != nil {
+		return err
+	}
+
 	order.Status = model.OrderStatusPaid
 	order.TradeNo = tradeNo
 	order.PaidAt = &now
+	order.NeedsReview = 0
 
-	if err := model.DB.Save(order).Error; err != nil {
-		return err
+	if order.OrderType == model.OrderTypeTopup {
+		log.Printf("订单 %s 完成，用户 %d 钱包已充值 %.2f", order.OrderNo, order.UserID, order.Amount)
+		return nil
 	}
 
-	// 获取用户和套餐
-	var user model.User
-	var plan model.Plan
-	model.DB.First(&user, order.UserID)
-	model.DB.First(&plan, order.PlanID)
+	// 执行 new-api 侧操作，失败的步骤留待重试
+	if err := RunOrderTasks(order.ID); err != nil {
+		log.Printf("订单 %s 的 new-api 操作未完成，将稍后重试: %v", order.OrderNo, err)
+	}
 
-	// 处理 new-api 账号
-	client := NewNewAPIClient()
+	if order.OrderType == model.OrderTypeBooster {
+		log.Printf("订单 %s 完成，用户 %d 订阅 %d 已增加加油包额度 %d", order.OrderNo, order.UserID, order.SubscriptionID, order.BoosterQuota)
+		return nil
+	}
+	log.Printf("订单 %s 完成，用户 %d 订阅已激活", order.OrderNo, order.UserID)
+	return nil
+}
 
-	if user.NewAPIBound != 1 {
-		// 生成随机用户名: 前缀_随机字符串
-		randomUsername := generateRandomUsername()
-		// 生成随机密码
-		randomPassword := generateRandomPassword()
+// errOrderAlreadyCompleted 订单已被其他请求完成
+var errOrderAlreadyCompleted = errors.New("订单已完成")
 
-		// 创建新账号
-		newAPIUser, err := client.CreateUser(randomUsername, randomPassword, plan.NewAPIGroup)
-		if err != nil {
-			log.Printf("创建 new-api 账号失败: %v", err)
-			// 尝试使用带时间戳的用户名再次创建
-			randomUsername = fmt.Sprintf("u_%d_%s", time.Now().Unix(), generateRandomString(4))
-			newAPIUser, err = client.CreateUser(randomUsername, randomPassword, plan.NewAPIGroup)
-			if err != nil {
-				log.Printf("重试创建 new-api 账号仍然失败: %v", err)
-			}
+// createOrderTasks 记录订单在 new-api 侧待执行的步骤
+func createOrderTasks(tx *gorm.DB, orderID uint, steps ...string) error {
+	for _, step := range steps {
+		task := &model.OrderTask{
+			OrderID: orderID,
+			Step:    step,
+			Status:  model.OrderTaskStatusPending,
 		}
-
-		if newAPIUser != nil {
-			user.NewAPIUserID = newAPIUser.ID
-			user.NewAPIUsername = newAPIUser.Username
-			user.NewAPIBound = 1
-			model.DB.Save(&user)
-			log.Printf("为用户 %d 创建 new-api 账号: %s", user.ID, newAPIUser.Username)
+		if err := tx.Create(task).Error; err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// 创建或更新订阅
+// applyOrderSubscription 根据订单创建或延长订阅，返回的 bool 表示是否仅延长了现有订阅
+func applyOrderSubscription(tx *gorm.DB, order *model.Order, user *model.User, plan *model.Plan) (*model.Subscription, bool, error) {
 	var subscription model.Subscription
-	today := time.Now().Truncate(24 * time.Hour)
-
-	// 计算到期时间的辅助函数
-	calcEndDate := func(baseDate time.Time, periodDays int) time.Time {
-		if plan.PeriodType == model.PeriodTypeMonth {
-			// 按月订阅：计算月数
-			months := periodDays / 30
-			if months < 1 {
-				months = 1
+	loc := user.Location()
+	now := time.Now().In(loc)
+	today := model.LocalDate(now, loc)
+	windowStart := model.QuotaWindowStart(plan.QuotaWindow, now)
+
+	if order.IsPlanChange() {
+		// 变更套餐：替换订阅的套餐快照，从今天起按新套餐计算有效期
+		if err := tx.Where("id = ? AND user_id = ? AND status = ?",
+			order.SubscriptionID, user.ID, model.SubscriptionStatusActive).
+			First(&subscription).Error; err == nil {
+			subscription.PlanID = plan.ID
+			subscription.StartDate = today
+			subscription.EndDate = orderEndDate(plan, order, today)
+			subscription.TodayQuota = plan.DailyQuota
+			subscription.CarriedQuota = 0
+			subscription.QuotaWindow = plan.QuotaWindow
+			subscription.DailyQuota = plan.DailyQuota
+			subscription.CarryOver = plan.CarryOver
+			subscription.MaxCarryOver = plan.MaxCarryOver
+			subscription.NewAPIGroup = plan.NewAPIGroup
+			subscription.LastSyncDate = &today
+			subscription.WindowStart = &windowStart
+			subscription.PausedDays = 0
+			if err := tx.Save(&subscription).Error; err != nil {
+				return nil, false, err
 			}
-			return baseDate.AddDate(0, months, 0)
-		} else if plan.PeriodType == model.PeriodTypeWeek {
-			// 按周订阅：计算周数
-			weeks := periodDays / 7
-			if weeks < 1 {
-				weeks = 1
-			}
-			return baseDate.AddDate(0, 0, weeks*7)
+			return &subscription, false, nil
 		}
-		// 按天或自定义
-		return baseDate.AddDate(0, 0, periodDays)
+		// 原订阅已失效时按新购处理
 	}
 
 	if order.OrderType == model.OrderTypeRenew {
-		// 续费：延长现有订阅
-		model.DB.Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-			First(&subscription)
-
-		if subscription.ID > 0 {
-			subscription.EndDate = calcEndDate(subscription.EndDate, order.PeriodDays)
-			model.DB.Save(&subscription)
-		}
-	} else {
-		// 新购：创建新订阅
-		// 先将旧订阅设为过期
-		model.DB.Model(&model.Subscription{}).
-			Where("user_id = ? AND status = ?", user.ID, model.SubscriptionStatusActive).
-			Update("status", model.SubscriptionStatusExpired)
-
-		subscription = model.Subscription{
-			UserID:       user.ID,
-			PlanID:       plan.ID,
-			Status:       model.SubscriptionStatusActive,
-			StartDate:    today,
-			EndDate:      calcEndDate(today, order.PeriodDays),
-			TodayQuota:   plan.DailyQuota,
-			DailyQuota:   plan.DailyQuota,
-			CarryOver:    plan.CarryOver,
-			MaxCarryOver: plan.MaxCarryOver,
-			NewAPIGroup:  plan.NewAPIGroup,
-			LastSyncDate: &today,
-		}
-		model.DB.Create(&subscription)
-	}
-
-	// 设置 new-api 初始额度（重要：必须在创建订阅后设置）
-	if user.NewAPIBound == 1 {
-		newAPIUser, err := client.GetUser(user.NewAPIUserID)
-		if err == nil {
-			newAPIUser.Quota = plan.DailyQuota
-			newAPIUser.Group = plan.NewAPIGroup
-			if err := client.UpdateUser(newAPIUser); err != nil {
-				log.Printf("设置用户 %d new-api 额度失败: %v", user.ID, err)
-			} else {
-				log.Printf("用户 %d new-api 额度已设置为 %d", user.ID, plan.DailyQuota)
-			}
+		// 续费：延长订单指定的订阅（历史订单按同套餐查找），宽限期内续费从原到期日接续，视为未中断
+		query := tx.Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionCurrentStatuses)
+		if order.SubscriptionID > 0 {
+			query = query.Where("id = ?", order.SubscriptionID)
 		} else {
-			log.Printf("获取用户 %d new-api 信息失败: %v", user.ID, err)
+			query = query.Where("plan_id = ?", plan.ID)
+		}
+		if err := query.First(&subscription).Error; err == nil {
+			subscription.EndDate = orderEndDate(plan, order, subscription.EndDate)
+			subscription.PausedDays = 0 // 新的计费周期重新计算暂停天数
+			if subscription.Status == model.SubscriptionStatusGrace {
+				subscription.Status = model.SubscriptionStatusActive
+				subscription.GraceEndDate = nil
+			}
+			if err := tx.Save(&subscription).Error; err != nil {
+				return nil, false, err
+			}
+			return &subscription, true, nil
 		}
+		// 待续费的订阅已不存在时按新购处理
 	}
 
-	log.Printf("订单 %s 完成，用户 %d 订阅已激活", order.OrderNo, user.ID)
-	return nil
+	// 新购：与用户的其他订阅并存，额度在每日同步时叠加
+	subscription = model.Subscription{
+		UserID:       user.ID,
+		PlanID:       plan.ID,
+		Status:       model.SubscriptionStatusActive,
+		StartDate:    today,
+		EndDate:      orderEndDate(plan, order, today),
+		TodayQuota:   plan.DailyQuota,
+		QuotaWindow:  plan.QuotaWindow,
+		DailyQuota:   plan.DailyQuota,
+		CarryOver:    plan.CarryOver,
+		MaxCarryOver: plan.MaxCarryOver,
+		NewAPIGroup:  plan.NewAPIGroup,
+		LastSyncDate: &today,
+		WindowStart:  &windowStart,
+	}
+	if err := tx.Create(&subscription).Error; err != nil {
+		return nil, false, err
+	}
+	return &subscription, false, nil
+}
+
+// orderEndDate 计算订单生效后的到期时间，兑换码订单按兑换码天数精确延长，不按套餐周期取整
+func orderEndDate(plan *model.Plan, order *model.Order, baseDate time.Time) time.Time {
+	if order.PaymentMethod == model.PaymentMethodRedeem {
+		return baseDate.AddDate(0, 0, order.PeriodDays)
+	}
+	return calcEndDate(plan, baseDate, order.PeriodDays)
+}
+
+// calcEndDate 计算到期时间
+func calcEndDate(plan *model.Plan, baseDate time.Time, periodDays int) time.Time {
+	if plan.PeriodType == model.PeriodTypeMonth {
+		// 按月订阅：计算月数
+		months := periodDays / 30
+		if months < 1 {
+			months = 1
+		}
+		return baseDate.AddDate(0, months, 0)
+	} else if plan.PeriodType == model.PeriodTypeWeek {
+		// 按周订阅：计算周数
+		weeks := periodDays / 7
+		if weeks < 1 {
+			weeks = 1
+		}
+		return baseDate.AddDate(0, 0, weeks*7)
+	}
+	// 按天或自定义
+	return baseDate.AddDate(0, 0, periodDays)
 }
 
 // generateRandomUsername 生成随机用户名
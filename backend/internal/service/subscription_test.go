@@ -0,0 +1,407 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+// createTestOrder 创建待支付的新购订单
+func createTestOrder(t *testing.T, user *model.User, plan *model.Plan) *model.Order {
+	t.Helper()
+
+	order := &model.Order{
+		OrderNo:    "T" + time.Now().Format("150405.000000000"),
+		UserID:     user.ID,
+		PlanID:     plan.ID,
+		OrderType:  model.OrderTypeNew,
+		PeriodDays: plan.PeriodDays,
+		Amount:     plan.Price,
+		Status:     model.OrderStatusPending,
+	}
+	if err := model.DB.Create(order).Error; err != nil {
+		t.Fatalf("创建订单失败: %v", err)
+	}
+	return order
+}
+
+// createSyncedSubscription 创建上一个额度周期已发放过额度的有效订阅
+func createSyncedSubscription(t *testing.T, user *model.User, plan *model.Plan, endDate time.Time) *model.Subscription {
+	t.Helper()
+
+	loc := user.Location()
+	yesterday := time.Now().In(loc).AddDate(0, 0, -1)
+	lastSync := model.LocalDate(yesterday, loc)
+	windowStart := model.QuotaWindowStart(plan.QuotaWindow, yesterday)
+	sub := &model.Subscription{
+		UserID:       user.ID,
+		PlanID:       plan.ID,
+		Status:       model.SubscriptionStatusActive,
+		StartDate:    lastSync.AddDate(0, 0, -10),
+		EndDate:      endDate,
+		TodayQuota:   plan.DailyQuota,
+		LastSyncDate: &lastSync,
+		WindowStart:  &windowStart,
+		QuotaWindow:  plan.QuotaWindow,
+		DailyQuota:   plan.DailyQuota,
+		CarryOver:    plan.CarryOver,
+		MaxCarryOver: plan.MaxCarryOver,
+		NewAPIGroup:  plan.NewAPIGroup,
+	}
+	if err := model.DB.Create(sub).Error; err != nil {
+		t.Fatalf("创建订阅失败: %v", err)
+	}
+	return sub
+}
+
+// syncTestUser 加载用户的订阅并执行一次同步
+func syncTestUser(t *testing.T, user *model.User) []model.SyncResult {
+	t.Helper()
+
+	var subscriptions []model.Subscription
+	model.DB.Preload("User").Preload("Plan").
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionSyncStatuses).
+		Order("id ASC").
+		Find(&subscriptions)
+	if len(subscriptions) == 0 {
+		t.Fatalf("用户 %d 没有需要同步的订阅", user.ID)
+	}
+
+	results, err := syncUserSubscriptions(context.Background(), NewAPI(), groupSubscriptionsByUser(subscriptions)[0], time.Now())
+	if err != nil {
+		t.Fatalf("同步失败: %v", err)
+	}
+	return results
+}
+
+func TestCompleteOrderCreatesNewAPIUser(t *testing.T) {
+	fake := setupTest(t)
+	user := createTestUser(t, "alice", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+
+	if err := CompleteOrder(order, "trade-1"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+	if order.Status != model.OrderStatusPaid || order.SubscriptionID == 0 {
+		t.Fatalf("订单状态 = %s, 订阅 = %d", order.Status, order.SubscriptionID)
+	}
+
+	model.DB.First(user, user.ID)
+	if user.NewAPIBound != 1 || user.NewAPIUserID == 0 {
+		t.Fatalf("用户未绑定 new-api 账号: %+v", user)
+	}
+	newAPIUser, ok := fake.User(user.NewAPIUserID)
+	if !ok {
+		t.Fatalf("new-api 用户 %d 不存在", user.NewAPIUserID)
+	}
+	if newAPIUser.Quota != 1000 || newAPIUser.Group != "vip" {
+		t.Errorf("new-api 余额 = %d, 分组 = %s, 期望 1000, vip", newAPIUser.Quota, newAPIUser.Group)
+	}
+
+	var pending int64
+	model.DB.Model(&model.OrderTask{}).
+		Where("order_id = ? AND status <> ?", order.ID, model.OrderTaskStatusDone).
+		Count(&pending)
+	if pending != 0 {
+		t.Errorf("仍有 %d 个未完成的 new-api 步骤", pending)
+	}
+}
+
+func TestCompleteOrderIsIdempotent(t *testing.T) {
+	fake := setupTest(t)
+	user := createTestUser(t, "bob", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+
+	if err := CompleteOrder(order, "trade-2"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+	again := &model.Order{}
+	model.DB.First(again, order.ID)
+	if err := CompleteOrder(again, "trade-2"); err != nil {
+		t.Fatalf("重复 CompleteOrder: %v", err)
+	}
+
+	if calls := fake.Calls("CreateUser"); calls != 1 {
+		t.Errorf("CreateUser 调用 %d 次，期望 1 次", calls)
+	}
+	var count int64
+	model.DB.Model(&model.Subscription{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("订阅数 = %d，期望 1", count)
+	}
+}
+
+func TestCompleteOrderRetriesNewAPIFailure(t *testing.T) {
+	fake := setupTest(t)
+	user := createTestUser(t, "carol", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+
+	// new-api 不可用时订单仍完成，步骤留待重试
+	fake.SetError("CreateUser", errors.New("connection refused"))
+	if err := CompleteOrder(order, "trade-3"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+	model.DB.First(user, user.ID)
+	if user.NewAPIBound == 1 {
+		t.Fatal("new-api 创建失败时不应绑定账号")
+	}
+
+	fake.SetError("CreateUser", nil)
+	RetryOrderTasks()
+
+	model.DB.First(user, user.ID)
+	newAPIUser, ok := fake.User(user.NewAPIUserID)
+	if user.NewAPIBound != 1 || !ok || newAPIUser.Quota != 1000 {
+		t.Fatalf("重试后用户 = %+v, new-api 用户 = %+v", user, newAPIUser)
+	}
+}
+
+func TestCompleteOrderResetsBoundUserQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("dave", "password", "default", 50)
+	user := createTestUser(t, "dave", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	order := createTestOrder(t, user, plan)
+
+	if err := CompleteOrder(order, "trade-4"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 1000 || got.Group != "vip" {
+		t.Errorf("new-api 余额 = %d, 分组 = %s, 期望 1000, vip", got.Quota, got.Group)
+	}
+	if calls := fake.Calls("CreateUser"); calls != 0 {
+		t.Errorf("已绑定用户不应创建 new-api 账号，CreateUser 调用 %d 次", calls)
+	}
+}
+
+func TestApplyQuotaTaskRetryDoesNotGrantTwice(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("gina", "password", "default", 1000)
+	user := createTestUser(t, "gina", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+	order := createTestOrder(t, user, plan)
+
+	// 写入 new-api 失败时撤销标记，重试时正常发放
+	fake.SetError("UpdateUser", errors.New("connection refused"))
+	if err := CompleteOrder(order, "trade-7"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+	fake.SetError("UpdateUser", nil)
+	RetryOrderTasks()
+
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 2000 {
+		t.Fatalf("重试后 new-api 余额 = %d, 期望在 1000 上叠加到 2000", got.Quota)
+	}
+
+	// 写入成功但步骤结果未保存时，重试不再叠加
+	model.DB.Model(&model.OrderTask{}).
+		Where("order_id = ? AND step = ?", order.ID, model.OrderTaskStepApplyQuota).
+		Update("status", model.OrderTaskStatusPending)
+	RetryOrderTasks()
+
+	got, _ = fake.User(newAPIUser.ID)
+	if got.Quota != 2000 {
+		t.Errorf("重复执行后 new-api 余额 = %d, 期望保持 2000", got.Quota)
+	}
+	var task model.OrderTask
+	model.DB.Where("order_id = ? AND step = ?", order.ID, model.OrderTaskStepApplyQuota).First(&task)
+	if task.Status != model.OrderTaskStatusDone || task.Applied != 1 {
+		t.Errorf("步骤 = %+v, 期望已完成且已写入", task)
+	}
+}
+
+func TestBoosterTaskRetryDoesNotAddTwice(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("hank", "password", "default", 1000)
+	user := createTestUser(t, "hank", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+	order := createTestOrder(t, user, plan)
+	model.DB.Model(order).Updates(map[string]interface{}{
+		"order_type":      model.OrderTypeBooster,
+		"subscription_id": sub.ID,
+		"booster_quota":   500,
+	})
+	model.DB.First(order, order.ID)
+
+	if err := CompleteOrder(order, "trade-8"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+
+	// 写入成功但步骤结果未保存时，重试不再增加
+	model.DB.Model(&model.OrderTask{}).
+		Where("order_id = ? AND step = ?", order.ID, model.OrderTaskStepAddBooster).
+		Update("status", model.OrderTaskStatusPending)
+	RetryOrderTasks()
+
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 1500 {
+		t.Errorf("new-api 余额 = %d, 期望只增加一次加油包额度到 1500", got.Quota)
+	}
+}
+
+func TestCompleteRenewalKeepsCurrentQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("erin", "password", "default", 0)
+	user := createTestUser(t, "erin", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	if err := CompleteOrder(createTestOrder(t, user, plan), "trade-5"); err != nil {
+		t.Fatalf("CompleteOrder: %v", err)
+	}
+	var sub model.Subscription
+	model.DB.Where("user_id = ?", user.ID).First(&sub)
+
+	// 用户已用掉部分额度后续费，余额和已发放的周期额度都不变
+	fake.SetQuota(newAPIUser.ID, 400)
+	renewal := createTestOrder(t, user, plan)
+	model.DB.Model(renewal).Updates(map[string]interface{}{
+		"order_type":      model.OrderTypeRenew,
+		"subscription_id": sub.ID,
+	})
+	renewal.OrderType = model.OrderTypeRenew
+	renewal.SubscriptionID = sub.ID
+	if err := CompleteOrder(renewal, "trade-6"); err != nil {
+		t.Fatalf("CompleteOrder 续费: %v", err)
+	}
+
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 400 {
+		t.Errorf("续费后 new-api 余额 = %d, 期望保持 400", got.Quota)
+	}
+	var renewed model.Subscription
+	model.DB.First(&renewed, sub.ID)
+	if want := sub.EndDate.AddDate(0, 0, plan.PeriodDays); !renewed.EndDate.Equal(want) {
+		t.Errorf("续费后到期日 = %v, 期望 %v", renewed.EndDate, want)
+	}
+}
+
+func TestSyncCarriesOverRemainingQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("erin", "password", "vip", 500)
+	user := createTestUser(t, "erin", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 1, 300)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+
+	results := syncTestUser(t, user)
+
+	got, _ := fake.User(newAPIUser.ID)
+	if got.Quota != 1300 {
+		t.Errorf("new-api 余额 = %d，期望 1000 + 结转上限 300", got.Quota)
+	}
+	model.DB.First(sub, sub.ID)
+	if sub.TodayQuota != 1300 || sub.CarriedQuota != 300 {
+		t.Errorf("订阅额度 = %d, 结转 = %d", sub.TodayQuota, sub.CarriedQuota)
+	}
+	if len(results) != 1 || results[0].Carried != 300 || results[0].QuotaBefore != 500 {
+		t.Errorf("同步结果 = %+v", results)
+	}
+}
+
+func TestSyncWithoutCarryOverResetsQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("frank", "password", "vip", 500)
+	user := createTestUser(t, "frank", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+
+	syncTestUser(t, user)
+
+	if got, _ := fake.User(newAPIUser.ID); got.Quota != 1000 {
+		t.Errorf("new-api 余额 = %d，期望 1000", got.Quota)
+	}
+}
+
+func TestSyncSkipsSubscriptionInCurrentWindow(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("grace", "password", "vip", 500)
+	user := createTestUser(t, "grace", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 1, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+	markWindowSynced(sub, time.Now().In(user.Location()))
+	model.DB.Save(sub)
+
+	if results := syncTestUser(t, user); results != nil {
+		t.Errorf("本周期已同步时不应更新，结果 = %+v", results)
+	}
+	if calls := fake.Calls("UpdateUser"); calls != 0 {
+		t.Errorf("UpdateUser 调用 %d 次，期望 0 次", calls)
+	}
+}
+
+func TestSyncExpiresSubscriptionAndClearsQuota(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("heidi", "password", "vip", 800)
+	user := createTestUser(t, "heidi", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 1, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, -1))
+
+	syncTestUser(t, user)
+
+	model.DB.First(sub, sub.ID)
+	if sub.Status != model.SubscriptionStatusExpired {
+		t.Errorf("订阅状态 = %s，期望 expired", sub.Status)
+	}
+	if got, _ := fake.User(newAPIUser.ID); got.Quota != 0 {
+		t.Errorf("new-api 余额 = %d，期望清零", got.Quota)
+	}
+}
+
+func TestSyncKeepsRenewalMadeAfterLoading(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("ivan", "password", "vip", 800)
+	user := createTestUser(t, "ivan", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, -1))
+
+	// 同步读取订阅后、处理该用户前，订阅被续费并购买了加油包
+	var loaded []model.Subscription
+	model.DB.Preload("User").Preload("Plan").Where("user_id = ?", user.ID).Find(&loaded)
+	renewedEnd := localToday(user).AddDate(0, 0, 30)
+	model.DB.Model(sub).Updates(map[string]interface{}{"end_date": renewedEnd, "booster_carry_quota": 300})
+
+	if _, err := syncUserSubscriptions(context.Background(), NewAPI(), groupSubscriptionsByUser(loaded)[0], time.Now()); err != nil {
+		t.Fatalf("同步失败: %v", err)
+	}
+
+	model.DB.First(sub, sub.ID)
+	if sub.Status != model.SubscriptionStatusActive || !sub.EndDate.Equal(renewedEnd) {
+		t.Errorf("订阅状态 = %s, 到期日 = %v，期望保留续费后的 active, %v", sub.Status, sub.EndDate, renewedEnd)
+	}
+	if sub.BoosterCarryQuota != 300 {
+		t.Errorf("加油包额度 = %d，期望保留 300", sub.BoosterCarryQuota)
+	}
+	if got, _ := fake.User(newAPIUser.ID); got.Quota != 1300 {
+		t.Errorf("new-api 余额 = %d，期望发放新周期额度 1000 并保留加油包 300", got.Quota)
+	}
+}
+
+func TestSyncSkipsUnboundUserWithoutExpiry(t *testing.T) {
+	setupTest(t)
+	user := createTestUser(t, "unbound", 0)
+	plan := createTestPlan(t, 1000, 0, 0)
+	createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+
+	// 未绑定用户的额度周期到期不产生同步记录，每次同步都不应重复记录
+	for i := 0; i < 2; i++ {
+		if results := syncTestUser(t, user); results != nil {
+			t.Fatalf("第 %d 次同步未绑定用户返回了同步结果: %+v", i+1, results)
+		}
+	}
+
+	// 订阅到期时仍记录状态变更
+	model.DB.Model(&model.Subscription{}).Where("user_id = ?", user.ID).
+		Update("end_date", localToday(user).AddDate(0, 0, -1))
+	if results := syncTestUser(t, user); len(results) != 1 {
+		t.Fatalf("订阅到期时同步结果数 = %d, want 1", len(results))
+	}
+}
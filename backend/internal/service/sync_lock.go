@@ -0,0 +1,152 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm/clause"
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
+)
+
+// ErrSyncRunning 已有同步在执行（可能在其他进程中）
+var ErrSyncRunning = errors.New("同步正在进行中")
+
+// errUserQuotaBusy 用户的 new-api 余额正在被其他任务修改
+var errUserQuotaBusy = errors.New("用户额度正在被其他任务修改，请稍后重试")
+
+const (
+	// syncLockGrace 锁超时时间在同步最长执行时间之外的余量，用于暂停恢复和到期提醒
+	syncLockGrace = 10 * time.Minute
+	// syncLockPollInterval 等待锁时的轮询间隔
+	syncLockPollInterval = 5 * time.Second
+
+	// userQuotaLockTTL 用户额度锁的超时时间，覆盖一次带重试的读取和写回
+	userQuotaLockTTL = 5 * time.Minute
+	// userQuotaLockWait 等待用户额度锁的最长时间
+	userQuotaLockWait = 30 * time.Second
+	// userQuotaLockPollInterval 等待用户额度锁时的轮询间隔
+	userQuotaLockPollInterval = 100 * time.Millisecond
+)
+
+// SyncStatus 正在执行的同步及进度
+type SyncStatus struct {
+	Owner      string         `json:"owner"`
+	AcquiredAt time.Time      `json:"acquired_at"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	Run        *model.SyncRun `json:"run"`       // 周期同步尚未记录时为 nil
+	Processed  int            `json:"processed"` // 已处理的订阅数
+}
+
+// RunningSync 获取正在执行的同步，没有时返回 nil
+func RunningSync() *SyncStatus {
+	var lock model.SyncLock
+	if err := model.DB.Where("name = ? AND expires_at > ?", model.SyncLockName, time.Now()).
+		First(&lock).Error; err != nil {
+		return nil
+	}
+
+	status := &SyncStatus{
+		Owner:      lock.Owner,
+		AcquiredAt: lock.AcquiredAt,
+		ExpiresAt:  lock.ExpiresAt,
+	}
+	if lock.RunID > 0 {
+		var run model.SyncRun
+		if err := model.DB.First(&run, lock.RunID).Error; err == nil {
+			status.Run = &run
+			status.Processed = run.Succeeded + run.Failed + run.Skipped
+		}
+	}
+	return status
+}
+
+// tryAcquireSyncLock 获取同步锁，已被其他同步持有且未超时时返回 ErrSyncRunning
+func tryAcquireSyncLock() (*model.SyncLock, error) {
+	return tryAcquireLock(model.SyncLockName, time.Duration(config.Cfg.SyncTimeout)*time.Minute+syncLockGrace)
+}
+
+// tryAcquireLock 获取名为 name 的数据库锁，ttl 后超时；已被持有且未超时时返回 ErrSyncRunning
+func tryAcquireLock(name string, ttl time.Duration) (*model.SyncLock, error) {
+	host, _ := os.Hostname()
+	now := time.Now()
+	lock := &model.SyncLock{
+		Name:       name,
+		Owner:      fmt.Sprintf("%s:%d:%d", host, os.Getpid(), now.UnixNano()),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	// 锁不存在时创建，已超时时接管；两步均为单条语句，由数据库保证只有一个进程成功
+	result := model.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(lock)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return lock, nil
+	}
+
+	result = model.DB.Model(&model.SyncLock{}).
+		Where("name = ? AND expires_at <= ?", name, now).
+		Updates(map[string]interface{}{
+			"owner":       lock.Owner,
+			"run_id":      0,
+			"acquired_at": lock.AcquiredAt,
+			"expires_at":  lock.ExpiresAt,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return lock, nil
+	}
+	return nil, ErrSyncRunning
+}
+
+// acquireSyncLock 获取同步锁，被占用时最多等待 wait
+func acquireSyncLock(wait time.Duration) (*model.SyncLock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lock, err := tryAcquireSyncLock()
+		if !errors.Is(err, ErrSyncRunning) || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(syncLockPollInterval)
+	}
+}
+
+// lockUserQuota 获取用户额度锁：读取 new-api 余额再写回的操作（同步、订单任务、暂停恢复、退款）按用户互斥，
+// 避免并发时后写入的一方覆盖另一方的修改；被占用时最多等待 userQuotaLockWait，用完后需 releaseSyncLock
+func lockUserQuota(userID uint) (*model.SyncLock, error) {
+	name := fmt.Sprintf("quota_user_%d", userID)
+	deadline := time.Now().Add(userQuotaLockWait)
+	for {
+		lock, err := tryAcquireLock(name, userQuotaLockTTL)
+		if !errors.Is(err, ErrSyncRunning) {
+			return lock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errUserQuotaBusy
+		}
+		time.Sleep(userQuotaLockPollInterval)
+	}
+}
+
+// setSyncLockRun 记录持有锁的同步记录，用于查询进度
+func setSyncLockRun(lock *model.SyncLock, runID uint) {
+	lock.RunID = runID
+	model.DB.Model(&model.SyncLock{}).
+		Where("name = ? AND owner = ?", lock.Name, lock.Owner).
+		Update("run_id", runID)
+}
+
+// releaseSyncLock 释放同步锁或用户额度锁，锁已超时被其他进程接管时不做处理
+func releaseSyncLock(lock *model.SyncLock) {
+	if err := model.DB.Where("name = ? AND owner = ?", lock.Name, lock.Owner).
+		Delete(&model.SyncLock{}).Error; err != nil {
+		log.Printf("释放同步锁失败: %v", err)
+	}
+}
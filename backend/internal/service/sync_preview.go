@@ -0,0 +1,154 @@
+package service
+
+import (
+	"time"
+
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
+)
+
+// SyncPreview 预览同步对单个订阅的影响
+// OldQuota/NewQuota/Expiring 为用户 new-api 余额的变化，同一用户的多个订阅相同
+type SyncPreview struct {
+	SubscriptionID uint   `json:"subscription_id"`
+	UserID         uint   `json:"user_id"`
+	Username       string `json:"username"`
+	PlanName       string `json:"plan_name"`
+	Status         string `json:"status"`     // 当前订阅状态
+	NewStatus      string `json:"new_status"` // 同步后的订阅状态
+	AutoRenew      bool   `json:"auto_renew"` // 到期时将尝试自动续费，预览按未续费计算
+
+	OldQuota int    `json:"old_quota"`
+	NewQuota int    `json:"new_quota"`
+	Granted  int    `json:"granted"`  // 该订阅本次发放的周期额度
+	Carried  int    `json:"carried"`  // 记在该订阅上的结转额度
+	Expiring int    `json:"expiring"` // 不予结转、将失效的剩余额度（含失效的加油包额度）
+	Group    string `json:"group"`
+
+	Changed bool   `json:"changed"` // 是否需要更新，为 false 时实际同步会跳过
+	Remark  string `json:"remark"`
+	Error   string `json:"error"`
+}
+
+// PreviewSync 预览一次全量同步的结果：读取 new-api 当前余额并计算同步后的额度，
+// 不写入 new-api，也不修改本地订阅
+func PreviewSync() ([]SyncPreview, error) {
+	client := rateLimited(NewAPI(), newRateLimiter(config.Cfg.SyncRateLimit))
+	if err := client.AdminLogin(); err != nil {
+		return nil, err
+	}
+
+	var subscriptions []model.Subscription
+	model.DB.Preload("User").Preload("Plan").
+		Where("status IN ?", model.SubscriptionSyncStatuses).
+		Order("user_id ASC, id ASC").
+		Find(&subscriptions)
+
+	now := time.Now()
+	previews := make([]SyncPreview, 0, len(subscriptions))
+	for _, subs := range groupSubscriptionsByUser(subscriptions) {
+		previews = append(previews, previewUserSync(client, subs, now)...)
+	}
+	return previews, nil
+}
+
+// previewUserSync 按 syncUserSubscriptions 的规则计算单个用户的同步结果，不产生任何修改
+func previewUserSync(client NewAPIBackend, subs []*model.Subscription, now time.Time) []SyncPreview {
+	user := subs[0].User
+	loc := model.SiteLocation()
+	if user != nil {
+		loc = user.Location()
+	}
+	now = now.In(loc)
+	today := model.LocalDate(now, loc)
+
+	previews := make([]SyncPreview, len(subs))
+	var live []*model.Subscription
+	for i, sub := range subs {
+		preview := SyncPreview{
+			SubscriptionID: sub.ID,
+			UserID:         sub.UserID,
+			Status:         sub.Status,
+			AutoRenew: sub.EndDate.Before(today) &&
+				sub.Status == model.SubscriptionStatusActive && sub.AutoRenew == 1,
+		}
+		if user != nil {
+			preview.Username = user.Username
+		}
+		if sub.Plan != nil {
+			preview.PlanName = sub.Plan.Name
+		}
+
+		status, _ := subscriptionExpiryStatus(sub, today)
+		preview.NewStatus = status
+		previews[i] = preview
+		if status != model.SubscriptionStatusExpired {
+			// 在副本上设置到期后的状态，避免修改查询结果
+			copied := *sub
+			copied.Status = status
+			live = append(live, &copied)
+		}
+	}
+
+	changed, expireBoosters := syncNeeded(live, len(live) < len(subs), now, today)
+	if user == nil || user.NewAPIBound != 1 {
+		return setSyncPreviews(previews, changed, "未绑定 new-api 账号，仅更新订阅状态")
+	}
+
+	newAPIUser, err := client.GetUser(user.NewAPIUserID)
+	if err != nil {
+		for i := range previews {
+			previews[i].Error = err.Error()
+		}
+		return setSyncPreviews(previews, changed, "")
+	}
+	remaining := newAPIUser.Quota
+
+	for i := range previews {
+		previews[i].OldQuota = remaining
+		previews[i].NewQuota = remaining
+		previews[i].Group = newAPIUser.Group
+	}
+
+	if !changed {
+		return setSyncPreviews(previews, false, "无需更新")
+	}
+	if len(live) == 0 {
+		for i := range previews {
+			previews[i].NewQuota = 0
+			previews[i].Expiring = max(remaining, 0)
+		}
+		return setSyncPreviews(previews, true, "所有订阅已过期，清零余额")
+	}
+
+	stack := stackSubscriptionQuota(live, now, remaining, graceQuotaPercent(), expireBoosters)
+	if stack.Frozen {
+		return setSyncPreviews(previews, true, "宽限期内额度已冻结")
+	}
+
+	// 新余额中除新发放的周期额度外均来自原余额，其余部分失效
+	granted := 0
+	for _, quota := range stack.Granted {
+		granted += quota
+	}
+	expiring := max(remaining, 0) - (stack.Quota - granted)
+	for i := range previews {
+		previews[i].NewQuota = stack.Quota
+		previews[i].Group = stack.Group
+		previews[i].Expiring = expiring
+		previews[i].Granted = stack.Granted[previews[i].SubscriptionID]
+		if previews[i].SubscriptionID == stack.CarriedID {
+			previews[i].Carried = stack.Carried
+		}
+	}
+	return setSyncPreviews(previews, true, "")
+}
+
+// setSyncPreviews 设置用户所有订阅的预览结论
+func setSyncPreviews(previews []SyncPreview, changed bool, remark string) []SyncPreview {
+	for i := range previews {
+		previews[i].Changed = changed
+		previews[i].Remark = remark
+	}
+	return previews
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
+)
+
+// TriggerSync 手动触发全量同步，立即返回同步记录，同步在后台执行
+// 已有同步在执行时返回 ErrSyncRunning
+func TriggerSync() (*model.SyncRun, error) {
+	lock, err := tryAcquireSyncLock()
+	if err != nil {
+		return nil, err
+	}
+	run, err := startSyncRun(lock, model.SyncSourceManual, 0)
+	if err != nil {
+		releaseSyncLock(lock)
+		return nil, err
+	}
+	go syncAllSubscriptions(lock, run)
+	return run, nil
+}
+
+// RetrySyncRun 重新同步某次同步中失败的订阅，立即返回新的同步记录
+// 同一用户的订阅叠加同步，因此按失败订阅所属的用户重跑；已有同步在执行时返回 ErrSyncRunning
+func RetrySyncRun(runID uint) (*model.SyncRun, error) {
+	var userIDs []uint
+	model.DB.Model(&model.SyncResult{}).
+		Where("run_id = ? AND status = ?", runID, model.SyncResultStatusFailed).
+		Distinct().
+		Pluck("user_id", &userIDs)
+	if len(userIDs) == 0 {
+		return nil, errors.New("该次同步没有失败的订阅")
+	}
+
+	lock, err := tryAcquireSyncLock()
+	if err != nil {
+		return nil, err
+	}
+	run, err := startSyncRun(lock, model.SyncSourceRetry, runID)
+	if err != nil {
+		releaseSyncLock(lock)
+		return nil, err
+	}
+	go func() {
+		defer releaseSyncLock(lock)
+		runSync(lock, run, userIDs)
+	}()
+	return run, nil
+}
+
+// startSyncRun 创建执行中的同步记录并记到同步锁上
+func startSyncRun(lock *model.SyncLock, source string, retryOfID uint) (*model.SyncRun, error) {
+	run := &model.SyncRun{
+		Source:    source,
+		Status:    model.SyncRunStatusRunning,
+		RetryOfID: retryOfID,
+		StartedAt: time.Now(),
+	}
+	if err := model.DB.Create(run).Error; err != nil {
+		return nil, err
+	}
+	setSyncLockRun(lock, run.ID)
+	return run, nil
+}
+
+// errSyncTimeout 同步超过最长执行时间，未处理的订阅记为失败，可重新同步
+var errSyncTimeout = errors.New("同步超时，未处理")
+
+// runSync 执行同步并记录结果；userIDs 为 nil 时同步所有用户
+// 调用方需持有同步锁；按用户分发给 config.Cfg.SyncConcurrency 个并发任务，共享对 new-api 的限流；
+// 尚未保存的同步记录（周期同步）在出现第一条结果时才保存
+func runSync(lock *model.SyncLock, run *model.SyncRun, userIDs []uint) {
+	// 客户端共享管理员会话，可在多个任务间并发使用
+	client := rateLimited(NewAPI(), newRateLimiter(config.Cfg.SyncRateLimit))
+	if err := client.AdminLogin(); err != nil {
+		log.Printf("管理员登录失败: %v", err)
+		run.Error = fmt.Sprintf("管理员登录失败: %v", err)
+		finishSyncRun(run)
+		return
+	}
+
+	// 获取所有活跃和宽限期内的订阅（暂停中的订阅不同步）
+	var subscriptions []model.Subscription
+	query := model.DB.Preload("User").Preload("Plan").
+		Where("status IN ?", model.SubscriptionSyncStatuses)
+	if userIDs != nil {
+		query = query.Where("user_id IN ?", userIDs)
+	}
+	query.Order("user_id ASC, id ASC").Find(&subscriptions)
+
+	run.Total = len(subscriptions)
+	if run.ID != 0 {
+		model.DB.Model(run).Update("total", run.Total)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Cfg.SyncTimeout)*time.Minute)
+	defer cancel()
+
+	// 同一用户的多个订阅叠加为一个 new-api 余额，由同一个任务处理
+	now := time.Now()
+	jobs := make(chan []*model.Subscription)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	timedOut := false
+	for i := 0; i < max(config.Cfg.SyncConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for subs := range jobs {
+				var results []model.SyncResult
+				var err error
+				if ctx.Err() != nil {
+					err = errSyncTimeout
+					results = failSyncResults(newSyncResults(subs), err)
+				} else {
+					results, err = syncUserSubscriptions(ctx, client, subs, now)
+				}
+				if err != nil {
+					log.Printf("同步用户 %d 的订阅失败: %v", subs[0].UserID, err)
+				}
+
+				mu.Lock()
+				if err == errSyncTimeout {
+					timedOut = true
+				}
+				recordSyncResults(lock, run, subs, results)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, subs := range groupSubscriptionsByUser(subscriptions) {
+		jobs <- subs
+	}
+	close(jobs)
+	wg.Wait()
+
+	if timedOut {
+		run.Error = fmt.Sprintf("同步超过 %d 分钟，未处理的订阅已记为失败", config.Cfg.SyncTimeout)
+	}
+	finishSyncRun(run)
+}
+
+// recordSyncResults 统计并保存单个用户的同步结果，results 为 nil 表示无需更新
+// 每处理一个用户更新一次同步记录的统计，用于查询进度
+func recordSyncResults(lock *model.SyncLock, run *model.SyncRun, subs []*model.Subscription, results []model.SyncResult) {
+	if results == nil {
+		run.Skipped += len(subs)
+	}
+	for _, result := range results {
+		if result.Status == model.SyncResultStatusFailed {
+			run.Failed++
+		} else {
+			run.Succeeded++
+		}
+	}
+
+	if results != nil {
+		if err := saveSyncResults(lock, run, results); err != nil {
+			log.Printf("保存用户 %d 的同步结果失败: %v", subs[0].UserID, err)
+		}
+	}
+	if run.ID != 0 {
+		model.DB.Model(run).Updates(map[string]interface{}{
+			"succeeded": run.Succeeded,
+			"failed":    run.Failed,
+			"skipped":   run.Skipped,
+		})
+	}
+}
+
+// saveSyncResults 保存同步结果，同步记录尚未保存时先保存并记到同步锁上
+func saveSyncResults(lock *model.SyncLock, run *model.SyncRun, results []model.SyncResult) error {
+	if run.ID == 0 {
+		if err := model.DB.Create(run).Error; err != nil {
+			return err
+		}
+		setSyncLockRun(lock, run.ID)
+	}
+	for i := range results {
+		results[i].RunID = run.ID
+	}
+	return model.DB.Create(&results).Error
+}
+
+// finishSyncRun 按结果统计更新同步记录状态；没有结果的周期同步不保存
+func finishSyncRun(run *model.SyncRun) {
+	now := time.Now()
+	run.FinishedAt = &now
+
+	switch {
+	case run.Succeeded == 0 && (run.Error != "" || run.Failed > 0):
+		run.Status = model.SyncRunStatusFailed
+	case run.Failed > 0:
+		run.Status = model.SyncRunStatusPartial
+	default:
+		run.Status = model.SyncRunStatusSuccess
+	}
+
+	if run.ID == 0 && run.Source == model.SyncSourcePeriodic && run.Error == "" {
+		return
+	}
+	if err := model.DB.Save(run).Error; err != nil {
+		log.Printf("保存同步记录失败: %v", err)
+	}
+}
+
+// newSyncResults 为用户的每个订阅创建同步结果，默认为成功
+func newSyncResults(subs []*model.Subscription) []model.SyncResult {
+	results := make([]model.SyncResult, len(subs))
+	for i, sub := range subs {
+		results[i] = model.SyncResult{
+			SubscriptionID:     sub.ID,
+			UserID:             sub.UserID,
+			Status:             model.SyncResultStatusSuccess,
+			SubscriptionStatus: sub.Status,
+		}
+	}
+	return results
+}
+
+// setSyncResults 记录用户同步前后的 new-api 余额
+func setSyncResults(results []model.SyncResult, before, after int, remark string) []model.SyncResult {
+	for i := range results {
+		results[i].QuotaBefore = before
+		results[i].QuotaAfter = after
+		results[i].Remark = remark
+	}
+	return results
+}
+
+// failSyncResults 将用户的所有订阅标记为同步失败
+func failSyncResults(results []model.SyncResult, err error) []model.SyncResult {
+	for i := range results {
+		results[i].Status = model.SyncResultStatusFailed
+		results[i].Error = err.Error()
+	}
+	return results
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// SetUserTimezone 修改用户时区，并将当前订阅按新时区所处的额度周期记为已发放
+// 额度周期按用户时区划分，否则来回切换时区可以在同一天多次进入新周期、重复领取额度
+func SetUserTimezone(user *model.User, timezone string) error {
+	if timezone == user.Timezone {
+		return nil
+	}
+
+	changed := *user
+	changed.Timezone = timezone
+	now := time.Now().In(changed.Location())
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", user.ID).
+			Update("timezone", timezone).Error; err != nil {
+			return err
+		}
+
+		var subscriptions []model.Subscription
+		if err := tx.Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionCurrentStatuses).
+			Find(&subscriptions).Error; err != nil {
+			return err
+		}
+		for _, sub := range subscriptions {
+			windowStart := model.QuotaWindowStart(sub.QuotaWindow, now)
+			if err := tx.Model(&model.Subscription{}).Where("id = ?", sub.ID).
+				Update("window_start", &windowStart).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	user.Timezone = timezone
+	return nil
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+func TestSetUserTimezoneDoesNotStartNewWindow(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("leo", "password", "vip", 500)
+	user := createTestUser(t, "leo", newAPIUser.ID)
+	plan := createTestPlan(t, 1000, 0, 0)
+	sub := createSyncedSubscription(t, user, plan, localToday(user).AddDate(0, 0, 10))
+	markWindowSynced(sub, time.Now().In(user.Location()))
+	model.DB.Save(sub)
+
+	// 在最早和最晚的时区之间来回切换，任何时刻都不应进入新的额度周期
+	for _, timezone := range []string{"Pacific/Kiritimati", "Etc/GMT+12", "Pacific/Kiritimati"} {
+		if err := SetUserTimezone(user, timezone); err != nil {
+			t.Fatalf("SetUserTimezone(%s): %v", timezone, err)
+		}
+		if results := syncTestUser(t, user); results != nil {
+			t.Fatalf("切换到 %s 后发放了新额度: %+v", timezone, results)
+		}
+	}
+	if calls := fake.Calls("UpdateUser"); calls != 0 {
+		t.Errorf("UpdateUser 调用 %d 次，期望 0 次", calls)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// ClaimTrial 领取试用套餐，创建 0 元订单并直接激活，不经过支付
+func ClaimTrial(user *model.User, plan *model.Plan) (*model.Order, error) {
+	if plan.IsTrial != 1 {
+		return nil, errors.New("该套餐不是试用套餐")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(user.Email))
+
+	var order *model.Order
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTrialEligible(tx, user, email); err != nil {
+			return err
+		}
+
+		order = &model.Order{
+			OrderNo:       fmt.Sprintf("TRL%d%d", user.ID, time.Now().UnixNano()),
+			UserID:        user.ID,
+			PlanID:        plan.ID,
+			OrderType:     model.OrderTypeNew,
+			PeriodDays:    plan.PeriodDays,
+			Amount:        0,
+			PaymentMethod: model.PaymentMethodTrial,
+			Status:        model.OrderStatusPending,
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+
+		claim := &model.TrialClaim{
+			UserID:       user.ID,
+			PlanID:       plan.ID,
+			OrderID:      order.ID,
+			NewAPIUserID: user.NewAPIUserID,
+			Email:        email,
+		}
+		if err := tx.Create(claim).Error; err != nil {
+			return errors.New("您已领取过试用")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CompleteOrder(order, "TRIAL_"+order.OrderNo); err != nil {
+		// 激活失败时撤销领取记录，允许重新领取
+		model.DB.Where("order_id = ?", order.ID).Delete(&model.TrialClaim{})
+		model.DB.Model(order).Update("status", model.OrderStatusCancelled)
+		return nil, err
+	}
+
+	log.Printf("用户 %d 领取试用套餐 %d，订单 %s", user.ID, plan.ID, order.OrderNo)
+	return order, nil
+}
+
+// checkTrialEligible 检查用户是否可以领取试用
+func checkTrialEligible(tx *gorm.DB, user *model.User, email string) error {
+	var activeCount int64
+	tx.Model(&model.Subscription{}).
+		Where("user_id = ? AND status IN ?", user.ID, model.SubscriptionCurrentStatuses).
+		Count(&activeCount)
+	if activeCount > 0 {
+		return errors.New("您已有有效订阅，无法领取试用")
+	}
+
+	query := tx.Model(&model.TrialClaim{}).Where("user_id = ?", user.ID)
+	if user.NewAPIBound == 1 && user.NewAPIUserID > 0 {
+		query = query.Or("newapi_user_id = ?", user.NewAPIUserID)
+	}
+	if email != "" {
+		query = query.Or("email = ?", email)
+	}
+
+	var claimed int64
+	query.Count(&claimed)
+	if claimed > 0 {
+		return errors.New("您的账号、new-api 账号或邮箱已领取过试用")
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"newapi-subscribe/internal/config"
+	"newapi-subscribe/internal/model"
+)
+
+// usageLogLookbackDays 首次汇总时回溯的天数
+const usageLogLookbackDays = 31
+
+// usageMu 避免上一次汇总未完成时重复执行
+var usageMu sync.Mutex
+
+// usageDay 用户一天的用量汇总
+type usageDay struct {
+	quota, requests, promptTokens, completionTokens int
+	models                                          map[string]*model.ModelUsage
+}
+
+// IngestUsageLogs 将有有效订阅的已绑定用户的 new-api 消费日志按天、按模型汇总到 UsageLog
+// 每个用户记录已汇总的最大日志 ID，重复执行不会重复计入
+func IngestUsageLogs() {
+	if !usageMu.TryLock() {
+		return
+	}
+	defer usageMu.Unlock()
+
+	client := rateLimited(NewAPI(), newRateLimiter(config.Cfg.SyncRateLimit))
+
+	var subscriptions []model.Subscription
+	model.DB.Preload("User").Preload("Plan").
+		Where("status IN ?", model.SubscriptionSyncStatuses).
+		Order("user_id ASC, id ASC").
+		Find(&subscriptions)
+
+	for _, subs := range groupSubscriptionsByUser(subscriptions) {
+		user := subs[0].User
+		if user == nil || user.NewAPIBound != 1 {
+			continue
+		}
+		if err := ingestUserUsageLogs(client, user, primarySubscription(subs).ID); err != nil {
+			log.Printf("汇总用户 %d 的使用日志失败: %v", user.ID, err)
+		}
+	}
+}
+
+// ingestUserUsageLogs 汇总用户上次汇总之后的 new-api 日志，新的日期记到 subscriptionID 上
+func ingestUserUsageLogs(client NewAPIBackend, user *model.User, subscriptionID uint) error {
+	cursor := model.UsageLogCursor{UserID: user.ID}
+	if err := model.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&cursor).Error; err != nil {
+		return err
+	}
+	if err := model.DB.First(&cursor, "user_id = ?", user.ID).Error; err != nil {
+		return err
+	}
+
+	start := cursor.LastLogAt
+	if cursor.LastLogID == 0 {
+		start = time.Now().AddDate(0, 0, -usageLogLookbackDays).Unix()
+	}
+
+	// 日志按 ID 倒序返回，读到已汇总的日志为止；失败时从头重新读取
+	// 截止时间固定为开始读取的时刻，读取期间新写入的日志留到下次汇总（EachUserLog 已按 ID 去重）
+	end := time.Now().Unix()
+	var logs []NewAPILog
+	err := withRetry(context.Background(), func() error {
+		logs = nil
+		return client.EachUserLog(NewAPILogQuery{
+			UserID:         user.NewAPIUserID,
+			Type:           NewAPILogTypeConsume,
+			StartTimestamp: start,
+			EndTimestamp:   end,
+		}, func(item NewAPILog) bool {
+			if item.ID <= cursor.LastLogID {
+				return false
+			}
+			logs = append(logs, item)
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+
+	// 按用户时区的日期汇总
+	loc := user.Location()
+	lastID, lastAt := cursor.LastLogID, cursor.LastLogAt
+	days := make(map[time.Time]*usageDay)
+	for _, item := range logs {
+		if item.ID > lastID {
+			lastID, lastAt = item.ID, item.CreatedAt
+		}
+
+		date := model.LocalDate(time.Unix(item.CreatedAt, 0), loc)
+		day, ok := days[date]
+		if !ok {
+			day = &usageDay{models: make(map[string]*model.ModelUsage)}
+			days[date] = day
+		}
+		day.quota += item.Quota
+		day.requests++
+		day.promptTokens += item.PromptTokens
+		day.completionTokens += item.CompletionTokens
+
+		usage, ok := day.models[item.ModelName]
+		if !ok {
+			usage = &model.ModelUsage{}
+			day.models[item.ModelName] = usage
+		}
+		usage.Quota += item.Quota
+		usage.RequestCount++
+		usage.PromptTokens += item.PromptTokens
+		usage.CompletionTokens += item.CompletionTokens
+	}
+
+	return model.DB.Transaction(func(tx *gorm.DB) error {
+		// 游标未被其他进程推进时才写入，保证每条日志只计入一次
+		result := tx.Model(&model.UsageLogCursor{}).
+			Where("user_id = ? AND last_log_id = ?", user.ID, cursor.LastLogID).
+			Updates(map[string]interface{}{
+				"last_log_id": lastID,
+				"last_log_at": lastAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("使用日志已被其他任务汇总")
+		}
+
+		for date, day := range days {
+			if err := mergeUsageLog(tx, user.ID, subscriptionID, date, day); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// mergeUsageLog 将一天的用量累加到用户当天的 UsageLog 上
+func mergeUsageLog(tx *gorm.DB, userID, subscriptionID uint, date time.Time, day *usageDay) error {
+	var usageLog model.UsageLog
+	err := tx.Where("user_id = ? AND log_date = ?", userID, date).First(&usageLog).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		usageLog = model.UsageLog{
+			UserID:         userID,
+			SubscriptionID: subscriptionID,
+			LogDate:        date,
+		}
+	} else if err != nil {
+		return err
+	}
+
+	models := make(map[string]*model.ModelUsage)
+	if usageLog.ModelUsage != "" {
+		json.Unmarshal([]byte(usageLog.ModelUsage), &models)
+	}
+	for name, usage := range day.models {
+		total, ok := models[name]
+		if !ok || total == nil {
+			total = &model.ModelUsage{}
+			models[name] = total
+		}
+		total.Quota += usage.Quota
+		total.RequestCount += usage.RequestCount
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+	}
+	modelUsage, _ := json.Marshal(models)
+
+	usageLog.TotalQuota += day.quota
+	usageLog.RequestCount += day.requests
+	usageLog.PromptTokens += day.promptTokens
+	usageLog.CompletionTokens += day.completionTokens
+	usageLog.ModelUsage = string(modelUsage)
+	return tx.Save(&usageLog).Error
+}
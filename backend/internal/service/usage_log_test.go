@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"newapi-subscribe/internal/model"
+)
+
+// shiftingLogBackend 读取第一页日志后写入一条新日志，使按偏移分页的后续页整体后移
+type shiftingLogBackend struct {
+	NewAPIBackend
+	fake   *FakeNewAPI
+	userID int
+	added  bool
+}
+
+func (b *shiftingLogBackend) GetUserLogsPage(query NewAPILogQuery, page, pageSize int) ([]NewAPILog, error) {
+	logs, err := b.NewAPIBackend.GetUserLogsPage(query, page, pageSize)
+	if page == 1 && !b.added {
+		b.added = true
+		b.fake.AddLog(NewAPILog{UserID: b.userID, Type: NewAPILogTypeConsume, Quota: 10, CreatedAt: time.Now().Unix() - 1})
+	}
+	return logs, err
+}
+
+func (b *shiftingLogBackend) EachUserLog(query NewAPILogQuery, fn func(NewAPILog) bool) error {
+	return eachUserLog(b, query, fn)
+}
+
+func TestIngestUsageLogsCountsEachLogOnce(t *testing.T) {
+	fake := setupTest(t)
+	newAPIUser := fake.AddUser("mia", "password", "vip", 0)
+	user := createTestUser(t, "mia", newAPIUser.ID)
+	createdAt := time.Now().Add(-time.Minute).Unix()
+	for i := 0; i < 150; i++ {
+		fake.AddLog(NewAPILog{UserID: newAPIUser.ID, Type: NewAPILogTypeConsume, ModelName: "gpt-4o", Quota: 10, CreatedAt: createdAt})
+	}
+
+	backend := &shiftingLogBackend{NewAPIBackend: fake.Client(), fake: fake, userID: newAPIUser.ID}
+	if err := ingestUserUsageLogs(backend, user, 1); err != nil {
+		t.Fatalf("ingestUserUsageLogs: %v", err)
+	}
+	if got := totalUsage(user.ID); got != 1500 {
+		t.Fatalf("汇总额度 = %d，期望 1500", got)
+	}
+
+	// 读取期间写入的日志在下次汇总时计入
+	if err := ingestUserUsageLogs(fake.Client(), user, 1); err != nil {
+		t.Fatalf("ingestUserUsageLogs: %v", err)
+	}
+	if got := totalUsage(user.ID); got != 1510 {
+		t.Errorf("再次汇总后额度 = %d，期望 1510", got)
+	}
+}
+
+// totalUsage 用户所有日期汇总的额度之和
+func totalUsage(userID uint) int {
+	var total int
+	model.DB.Model(&model.UsageLog{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(total_quota), 0)").Scan(&total)
+	return total
+}
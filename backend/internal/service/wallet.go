@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"newapi-subscribe/internal/model"
+)
+
+// ErrInsufficientBalance 钱包余额不足
+var ErrInsufficientBalance = errors.New("钱包余额不足")
+
+// CreditWallet 钱包入账并记录流水
+func CreditWallet(tx *gorm.DB, userID uint, amount float64, txType string, orderID uint, remark string) error {
+	return changeWallet(tx, userID, amount, txType, orderID, remark)
+}
+
+// DebitWallet 钱包扣款并记录流水，余额不足时返回 ErrInsufficientBalance
+func DebitWallet(tx *gorm.DB, userID uint, amount float64, txType string, orderID uint, remark string) error {
+	return changeWallet(tx, userID, -amount, txType, orderID, remark)
+}
+
+// changeWallet 变动钱包余额，扣款时以余额充足为条件更新，防止并发扣成负数
+func changeWallet(tx *gorm.DB, userID uint, delta float64, txType string, orderID uint, remark string) error {
+	query := tx.Model(&model.User{}).Where("id = ?", userID)
+	if delta < 0 {
+		query = query.Where("balance >= ?", -delta)
+	}
+	result := query.Update("balance", gorm.Expr("ROUND(balance + ?, 2)", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if delta < 0 {
+			return ErrInsufficientBalance
+		}
+		return errors.New("用户不存在")
+	}
+
+	var user model.User
+	if err := tx.Select("balance").First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&model.WalletTransaction{
+		UserID:  userID,
+		Type:    txType,
+		Amount:  delta,
+		Balance: user.Balance,
+		OrderID: orderID,
+		Remark:  remark,
+	}).Error
+}
+
+// AutoRenewSubscription 从钱包余额扣款续费订阅，按套餐当前标价续费一个周期
+func AutoRenewSubscription(sub *model.Subscription) (*model.Order, error) {
+	var plan model.Plan
+	if err := model.DB.First(&plan, sub.PlanID).Error; err != nil {
+		return nil, errors.New("套餐不存在")
+	}
+	if plan.Status != model.PlanStatusOn {
+		return nil, errors.New("套餐已下架")
+	}
+	if plan.IsTrial == 1 {
+		return nil, errors.New("试用套餐不支持续费")
+	}
+
+	order := &model.Order{
+		OrderNo:         fmt.Sprintf("ARN%d%d", sub.UserID, time.Now().UnixNano()),
+		UserID:          sub.UserID,
+		PlanID:          plan.ID,
+		OrderType:       model.OrderTypeRenew,
+		SubscriptionID:  sub.ID,
+		PeriodDays:      plan.PeriodDays,
+		Amount:          plan.CalculatePrice(plan.PeriodDays),
+		PaymentProvider: model.PaymentMethodWallet,
+		PaymentMethod:   model.PaymentMethodWallet,
+		Status:          model.OrderStatusPending,
+	}
+
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		return DebitWallet(tx, sub.UserID, order.Amount, model.WalletTxDebit, order.ID, "自动续费 "+plan.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CompleteOrder(order, "WALLET_"+order.OrderNo); err != nil {
+		// 续费失败时退回扣款
+		if refundErr := CreditWallet(model.DB, sub.UserID, order.Amount, model.WalletTxRefund, order.ID, "自动续费失败退回"); refundErr != nil {
+			log.Printf("订单 %s 自动续费失败且退回余额失败: %v", order.OrderNo, refundErr)
+		}
+		model.DB.Model(order).Update("status", model.OrderStatusCancelled)
+		return nil, err
+	}
+
+	log.Printf("订阅 %d 已通过钱包自动续费，订单 %s", sub.ID, order.OrderNo)
+	return order, nil
+}
+
+// autoRenewExpiring 在订阅到期时尝试自动续费，并邮件通知结果；返回是否续费成功
+func autoRenewExpiring(sub *model.Subscription) bool {
+	order, err := AutoRenewSubscription(sub)
+
+	var user model.User
+	model.DB.First(&user, sub.UserID)
+	var plan model.Plan
+	model.DB.Unscoped().First(&plan, sub.PlanID)
+
+	if err != nil {
+		log.Printf("订阅 %d 自动续费失败: %v", sub.ID, err)
+		if user.Email != "" {
+			reason := err.Error()
+			if errors.Is(err, ErrInsufficientBalance) {
+				reason = fmt.Sprintf("钱包余额 %.2f 元不足以支付续费金额 %.2f 元", user.Balance, plan.CalculatePrice(plan.PeriodDays))
+			}
+			SendAutoRenewFailed(user.Email, user.Username, plan.Name, reason)
+		}
+		return false
+	}
+
+	if user.Email != "" {
+		var renewed model.Subscription
+		model.DB.First(&renewed, order.SubscriptionID)
+		SendAutoRenewSuccess(user.Email, user.Username, plan.Name, order.Amount, user.Balance, renewed.EndDate)
+	}
+	return true
+}
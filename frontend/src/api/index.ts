@@ -44,17 +44,44 @@ export const planApi = {
 export const subscriptionApi = {
   current: () => api.get('/subscriptions/current'),
   purchase: (data: any) => api.post('/subscriptions/purchase', data),
-  renew: (data: { period_days: number }) => api.post('/subscriptions/renew', data),
+  claimTrial: (data: { plan_id: number }) => api.post('/subscriptions/trial', data),
+  renew: (data: { period_days: number; coupon_code?: string; subscription_id?: number }) => api.post('/subscriptions/renew', data),
+  setAutoRenew: (data: { auto_renew: number; subscription_id?: number }) => api.put('/subscriptions/auto-renew', data),
+  pause: (data?: { subscription_id?: number }) => api.post('/subscriptions/pause', data),
+  resume: (data?: { subscription_id?: number }) => api.post('/subscriptions/resume', data),
+  redeem: (data: { code: string }) => api.post('/subscriptions/redeem', data),
+  previewChangePlan: (params: { plan_id: number; period_days?: number; subscription_id?: number }) => api.get('/subscriptions/change-plan/preview', { params }),
+  changePlan: (data: { plan_id: number; period_days?: number; coupon_code?: string; subscription_id?: number }) => api.post('/subscriptions/change-plan', data),
   usage: (params?: any) => api.get('/subscriptions/usage', { params }),
   usageDetail: (params?: any) => api.get('/subscriptions/usage/detail', { params }),
   todayUsage: () => api.get('/subscriptions/usage/today'),
 }
 
+// 优惠码
+export const couponApi = {
+  validate: (data: { code: string; plan_id: number; period_days?: number }) => api.post('/coupons/validate', data),
+}
+
 // 订单
 export const orderApi = {
   list: (params?: any) => api.get('/orders', { params }),
   get: (id: number) => api.get(`/orders/${id}`),
-  pay: (data: { order_id: number; payment_method: string }) => api.post('/orders/pay', data),
+  pay: (data: { order_id: number; payment_method: string; provider?: string }) => api.post('/orders/pay', data),
+  paymentMethods: () => api.get('/orders/payment-methods'),
+  checkPayment: (id: number) => api.post(`/orders/${id}/check`),
+}
+
+// 钱包
+export const walletApi = {
+  get: () => api.get('/wallet'),
+  transactions: (params?: any) => api.get('/wallet/transactions', { params }),
+  topup: (data: { amount: number }) => api.post('/wallet/topup', data),
+}
+
+// 加油包
+export const boosterApi = {
+  list: () => api.get('/boosters'),
+  purchase: (data: { booster_id: number; subscription_id?: number }) => api.post('/boosters/purchase', data),
 }
 
 // 用户
@@ -62,6 +89,7 @@ export const userApi = {
   updateProfile: (data: any) => api.put('/user/profile', data),
   bindNewAPI: (data: { username: string; password: string }) => api.post('/user/bind-newapi', data),
   updateEmailSettings: (data: any) => api.put('/user/email-settings', data),
+  updateTimezone: (data: { timezone: string }) => api.put('/user/timezone', data),
 }
 
 // 管理员
@@ -79,18 +107,46 @@ export const adminApi = {
   // 订单
   getOrders: (params?: any) => api.get('/admin/orders', { params }),
   completeOrder: (id: number) => api.post(`/admin/orders/${id}/complete`),
+  getOrderTasks: (params?: any) => api.get('/admin/order-tasks', { params }),
+  retryOrderTasks: (id: number) => api.post(`/admin/orders/${id}/retry-tasks`),
+  refundOrder: (id: number, data?: { amount?: number; reason?: string; offline?: boolean }) => api.post(`/admin/orders/${id}/refund`, data || {}),
 
   // 套餐
   createPlan: (data: any) => api.post('/admin/plans', data),
   updatePlan: (id: number, data: any) => api.put(`/admin/plans/${id}`, data),
   deletePlan: (id: number) => api.delete(`/admin/plans/${id}`),
 
+  // 加油包
+  getBoosters: () => api.get('/admin/boosters'),
+  createBooster: (data: any) => api.post('/admin/boosters', data),
+  updateBooster: (id: number, data: any) => api.put(`/admin/boosters/${id}`, data),
+  deleteBooster: (id: number) => api.delete(`/admin/boosters/${id}`),
+
+  // 优惠码
+  getCoupons: (params?: any) => api.get('/admin/coupons', { params }),
+  createCoupon: (data: any) => api.post('/admin/coupons', data),
+  updateCoupon: (id: number, data: any) => api.put(`/admin/coupons/${id}`, data),
+  deleteCoupon: (id: number) => api.delete(`/admin/coupons/${id}`),
+  getCouponUsage: (id: number, params?: any) => api.get(`/admin/coupons/${id}/usage`, { params }),
+
+  // 兑换码
+  getRedeemCodes: (params?: any) => api.get('/admin/redeem-codes', { params }),
+  generateRedeemCodes: (data: any) => api.post('/admin/redeem-codes', data),
+  exportRedeemCodes: (params?: any) => api.get('/admin/redeem-codes/export', { params, responseType: 'blob' }),
+  revokeRedeemCode: (id: number) => api.post(`/admin/redeem-codes/${id}/revoke`),
+  revokeRedeemBatch: (batchNo: string) => api.post(`/admin/redeem-codes/batch/${batchNo}/revoke`),
+
   // 设置
   getSettings: () => api.get('/admin/settings'),
   updateSettings: (data: any) => api.put('/admin/settings', data),
 
   // 同步
   triggerSync: () => api.post('/admin/sync/trigger'),
+  previewSync: (params?: any) => api.get('/admin/sync/preview', { params }),
+  getSyncRuns: (params?: any) => api.get('/admin/sync/runs', { params }),
+  getSyncRun: (id: number) => api.get(`/admin/sync/runs/${id}`),
+  retrySyncRun: (id: number) => api.post(`/admin/sync/runs/${id}/retry`),
+  getSyncResults: (params?: any) => api.get('/admin/sync/results', { params }),
 
   // new-api
   getNewAPIGroups: () => api.get('/admin/newapi/groups'),
@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react'
-import { Button, Spin, message, Modal } from 'antd'
+import { Button, Spin, message, Modal, Tooltip } from 'antd'
 import { CheckOutlined, ThunderboltOutlined, CrownOutlined, RocketOutlined } from '@ant-design/icons'
 import { useNavigate } from 'react-router-dom'
 import { planApi } from '../../api'
@@ -19,6 +19,18 @@ interface Plan {
   newapi_group: string
 }
 
+interface PlanModel {
+  name: string
+  quota_type: number
+  daily_tokens: number
+  daily_requests: number
+}
+
+interface PlanModels {
+  group: string
+  models: PlanModel[]
+}
+
 const periodTypeMap: Record<string, string> = {
   day: '天',
   week: '周',
@@ -26,6 +38,24 @@ const periodTypeMap: Record<string, string> = {
   custom: '自定义',
 }
 
+// 卡片上直接展示的模型数量，其余在提示中查看
+const visibleModelCount = 3
+
+const formatTokens = (tokens: number) => {
+  if (tokens >= 100000000) return `${(tokens / 100000000).toFixed(1)} 亿`
+  if (tokens >= 10000) return `${(tokens / 10000).toFixed(1)} 万`
+  return tokens.toLocaleString()
+}
+
+// 每日额度按各模型输入价格估算的 token 数范围
+const dailyTokenRange = (models: PlanModel[]) => {
+  const tokens = models.map((m) => m.daily_tokens).filter((t) => t > 0)
+  if (tokens.length === 0) return null
+  const min = Math.min(...tokens)
+  const max = Math.max(...tokens)
+  return min === max ? `约 ${formatTokens(min)}` : `约 ${formatTokens(min)} - ${formatTokens(max)}`
+}
+
 const planIcons: Record<number, React.ReactNode> = {
   0: <ThunderboltOutlined />,
   1: <CrownOutlined />,
@@ -46,6 +76,7 @@ export default function Home() {
   const [plans, setPlans] = useState<Plan[]>([])
   const [loading, setLoading] = useState(true)
   const [hoveredPlan, setHoveredPlan] = useState<number | null>(null)
+  const [planModels, setPlanModels] = useState<Record<number, PlanModels>>({})
 
   useEffect(() => {
     loadPlans()
@@ -56,6 +87,7 @@ export default function Home() {
       const res: any = await planApi.list()
       if (res.success) {
         setPlans(res.data || [])
+        loadPlanModels(res.data || [])
       }
     } catch (error: any) {
       message.error(error.message || '加载失败')
@@ -64,6 +96,19 @@ export default function Home() {
     }
   }
 
+  // 模型列表只用于展示，获取失败时不提示
+  const loadPlanModels = async (list: Plan[]) => {
+    const results = await Promise.allSettled(list.map((plan) => planApi.getModels(plan.id)))
+    const models: Record<number, PlanModels> = {}
+    results.forEach((result, i) => {
+      const res: any = result.status === 'fulfilled' ? result.value : null
+      if (res?.success && res.data?.models?.length) {
+        models[list[i].id] = res.data
+      }
+    })
+    setPlanModels(models)
+  }
+
   const handlePurchase = (plan: Plan) => {
     if (!isAuthenticated) {
       Modal.confirm({
@@ -148,6 +193,32 @@ export default function Home() {
                   <CheckOutlined className="feature-icon" />
                   <span>每日额度 {plan.daily_quota.toLocaleString()}</span>
                 </li>
+                {planModels[plan.id] && dailyTokenRange(planModels[plan.id].models) && (
+                  <li className="feature-item">
+                    <CheckOutlined className="feature-icon" />
+                    <span>每日{dailyTokenRange(planModels[plan.id].models)} tokens</span>
+                  </li>
+                )}
+                {planModels[plan.id] && (
+                  <li className="feature-item">
+                    <CheckOutlined className="feature-icon" />
+                    <Tooltip
+                      title={planModels[plan.id].models.map((m) => (
+                        <div key={m.name}>
+                          {m.name}
+                          {m.daily_tokens > 0 && `：每日约 ${formatTokens(m.daily_tokens)} tokens`}
+                          {m.daily_requests > 0 && `：每日约 ${m.daily_requests.toLocaleString()} 次`}
+                        </div>
+                      ))}
+                    >
+                      <span className="feature-models">
+                        包含 {planModels[plan.id].models.slice(0, visibleModelCount).map((m) => m.name).join(', ')}
+                        {planModels[plan.id].models.length > visibleModelCount &&
+                          ` 等 ${planModels[plan.id].models.length} 个模型`}
+                      </span>
+                    </Tooltip>
+                  </li>
+                )}
                 <li className="feature-item">
                   <CheckOutlined className="feature-icon" />
                   <span>{plan.carry_over ? '支持额度结转' : '额度不结转'}</span>
@@ -11,6 +11,7 @@ interface User {
   newapi_username: string
   email_remind?: number
   remind_days?: number
+  timezone?: string
 }
 
 interface AuthState {